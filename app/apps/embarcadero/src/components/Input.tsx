@@ -136,7 +136,7 @@ export function Input({
         {hasAvailableBalance && usdValue && (
           <Text>${usdValue.toLocaleString()} USD</Text>
         )}
-        {usdValue && <Text>+ 5 SC fee</Text>}
+        {usdValue && <Text>+ miner fee</Text>}
       </Flex>
     </Flex>
   )
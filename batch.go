@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+// Batch statuses.
+const (
+	waitingForParticipantsToJoin = "waitingForParticipantsToJoin"
+	waitingForSignatures         = "waitingForSignatures"
+)
+
+// A BatchParticipant is one party to a batch swap. The participant agrees to
+// send and receive the specified amounts, then joins the batch by adding
+// inputs funding what they send and outputs paying what they receive, plus
+// any change.
+type BatchParticipant struct {
+	Name      string         `json:"name"`
+	SendSC    types.Currency `json:"sendSC"`
+	SendSF    types.Currency `json:"sendSF"`
+	ReceiveSC types.Currency `json:"receiveSC"`
+	ReceiveSF types.Currency `json:"receiveSF"`
+
+	Joined         bool                  `json:"joined"`
+	SiacoinInputs  []types.SiacoinInput  `json:"siacoinInputs"`
+	SiafundInputs  []types.SiafundInput  `json:"siafundInputs"`
+	SiacoinOutputs []types.SiacoinOutput `json:"siacoinOutputs"`
+	SiafundOutputs []types.SiafundOutput `json:"siafundOutputs"`
+}
+
+// A BatchSwap settles a swap between any number of participants in a single
+// transaction. The siacoins sent by the participants, less the siacoins they
+// receive, pay the miner fee.
+//
+// Each participant joins the batch in turn. Once every participant has
+// joined, the transaction is final, and each participant signs their own
+// inputs. Signatures from separate copies of the batch can be merged, and the
+// transaction is broadcast once every input is signed.
+type BatchSwap struct {
+	ID           string                       `json:"id"`
+	Participants []BatchParticipant           `json:"participants"`
+	Signatures   []types.TransactionSignature `json:"signatures"`
+}
+
+// minerFee returns the miner fee paid by the batch.
+func (b *BatchSwap) minerFee() types.Currency {
+	var send, receive types.Currency
+	for _, p := range b.Participants {
+		send = send.Add(p.SendSC)
+		receive = receive.Add(p.ReceiveSC)
+	}
+	if send.Cmp(receive) <= 0 {
+		return types.ZeroCurrency
+	}
+	return send.Sub(receive)
+}
+
+// transaction converts the batch into a full transaction, concatenating the
+// inputs and outputs of each participant in order.
+func (b *BatchSwap) transaction() types.Transaction {
+	txn := types.Transaction{
+		MinerFees:             []types.Currency{b.minerFee()},
+		TransactionSignatures: b.Signatures,
+	}
+	for _, p := range b.Participants {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, p.SiacoinInputs...)
+		txn.SiafundInputs = append(txn.SiafundInputs, p.SiafundInputs...)
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs, p.SiacoinOutputs...)
+		txn.SiafundOutputs = append(txn.SiafundOutputs, p.SiafundOutputs...)
+	}
+	return txn
+}
+
+// journalSwap returns the batch's transaction as a SwapTransaction, so that
+// it can be recorded in the journal, reserving the inputs of its participants.
+// Its terms are those of the named participant.
+func (b *BatchSwap) journalSwap(name string) SwapTransaction {
+	txn := b.transaction()
+	swap := SwapTransaction{
+		SiacoinInputs:  txn.SiacoinInputs,
+		SiafundInputs:  txn.SiafundInputs,
+		SiacoinOutputs: txn.SiacoinOutputs,
+		SiafundOutputs: txn.SiafundOutputs,
+		MinerFee:       b.minerFee(),
+		Signatures:     txn.TransactionSignatures,
+	}
+	if i, err := b.participant(name); err == nil {
+		p := b.Participants[i]
+		swap.Terms = SwapTerms{
+			Creator:  Basket{SC: p.SendSC, SF: p.SendSF},
+			Acceptor: Basket{SC: p.ReceiveSC, SF: p.ReceiveSF},
+		}
+	}
+	return swap
+}
+
+// reserveBatchJoin joins the batch as name, funding it through the journal so
+// that the inputs added are reserved, and records the batch under a label
+// naming it.
+func reserveBatchJoin(be Backend, j *journal, b *BatchSwap, name string, opts fundingOptions) (SwapRecord, error) {
+	r, err := j.FundSwap(be, stageAccepted, func(w Wallet) (SwapTransaction, error) {
+		if err := joinBatch(w, b, name, opts); err != nil {
+			return SwapTransaction{}, err
+		}
+		return b.journalSwap(name), nil
+	})
+	if err != nil {
+		return SwapRecord{}, err
+	}
+	id := b.ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	annotateSwap(j, r.ID, "batch "+id, "")
+	return r, nil
+}
+
+// participant returns the index of the named participant.
+func (b *BatchSwap) participant(name string) (int, error) {
+	for i, p := range b.Participants {
+		if p.Name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no participant named %q", name)
+}
+
+// owner returns the name of the first participant whose inputs belong to w,
+// or the empty string if none do.
+func (b *BatchSwap) owner(w Wallet) string {
+	addrs, err := w.Addresses()
+	if err != nil {
+		return ""
+	}
+	belongsToUs := make(map[types.UnlockHash]bool)
+	for _, addr := range addrs {
+		belongsToUs[addr] = true
+	}
+	for _, p := range b.Participants {
+		for _, sci := range p.SiacoinInputs {
+			if belongsToUs[sci.UnlockConditions.UnlockHash()] {
+				return p.Name
+			}
+		}
+		for _, sfi := range p.SiafundInputs {
+			if belongsToUs[sfi.UnlockConditions.UnlockHash()] {
+				return p.Name
+			}
+		}
+	}
+	return ""
+}
+
+// joined reports whether every participant has joined the batch.
+func (b *BatchSwap) joined() bool {
+	for _, p := range b.Participants {
+		if !p.Joined {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBatchTerms parses a participant's terms, written as
+// "name=send:receive", e.g. "alice=7MS:2SF". Either amount may be empty, and
+// amounts may combine siacoins and siafunds, e.g. "bob=1SF+2MS:".
+func parseBatchTerms(terms string) (BatchParticipant, error) {
+	eq := strings.Index(terms, "=")
+	colon := strings.LastIndex(terms, ":")
+	if eq <= 0 || colon < eq {
+		return BatchParticipant{}, fmt.Errorf("invalid participant terms %q: must be of the form name=send:receive", terms)
+	}
+	p := BatchParticipant{Name: terms[:eq]}
+	if send := terms[eq+1 : colon]; send != "" {
+		b, err := parseBasket(send)
+		if err != nil {
+			return BatchParticipant{}, fmt.Errorf("invalid participant terms %q: %w", terms, err)
+		}
+		p.SendSC, p.SendSF = b.SC, b.SF
+	}
+	if receive := terms[colon+1:]; receive != "" {
+		b, err := parseBasket(receive)
+		if err != nil {
+			return BatchParticipant{}, fmt.Errorf("invalid participant terms %q: %w", terms, err)
+		}
+		p.ReceiveSC, p.ReceiveSF = b.SC, b.SF
+	}
+	return p, nil
+}
+
+// checkBatchTerms checks that the participants' terms are consistent: every
+// siafund sent is received, and the siacoins sent cover those received plus a
+// non-zero miner fee.
+func checkBatchTerms(b BatchSwap) error {
+	if len(b.Participants) < 2 {
+		return errors.New("batch must have at least two participants")
+	}
+	names := make(map[string]bool)
+	var sendSC, receiveSC, sendSF, receiveSF types.Currency
+	for _, p := range b.Participants {
+		if p.Name == "" {
+			return errors.New("participants must be named")
+		} else if names[p.Name] {
+			return fmt.Errorf("participant %q appears more than once", p.Name)
+		} else if p.SendSC.IsZero() && p.SendSF.IsZero() && p.ReceiveSC.IsZero() && p.ReceiveSF.IsZero() {
+			return fmt.Errorf("participant %q neither sends nor receives anything", p.Name)
+		}
+		names[p.Name] = true
+		sendSC, receiveSC = sendSC.Add(p.SendSC), receiveSC.Add(p.ReceiveSC)
+		sendSF, receiveSF = sendSF.Add(p.SendSF), receiveSF.Add(p.ReceiveSF)
+	}
+	if !sendSF.Equals(receiveSF) {
+		return fmt.Errorf("participants send %v SF but receive %v SF", sendSF, receiveSF)
+	} else if sendSC.Cmp(receiveSC) <= 0 {
+		return fmt.Errorf("participants send %v but receive %v, leaving nothing for the miner fee", sendSC.HumanString(), receiveSC.HumanString())
+	}
+	return nil
+}
+
+// createBatch creates a new batch swap from the participants' terms.
+func createBatch(participants []BatchParticipant) (BatchSwap, error) {
+	b := BatchSwap{
+		ID:           newRecordID(),
+		Participants: participants,
+	}
+	if err := checkBatchTerms(b); err != nil {
+		return BatchSwap{}, err
+	}
+	return b, nil
+}
+
+// joinBatch adds the named participant's inputs and outputs to the batch,
+// funded from w.
+func joinBatch(w Wallet, b *BatchSwap, name string, opts fundingOptions) error {
+	i, err := b.participant(name)
+	if err != nil {
+		return err
+	} else if err := checkBatchTerms(*b); err != nil {
+		return err
+	} else if b.Participants[i].Joined {
+		return fmt.Errorf("participant %q has already joined", name)
+	} else if len(b.Signatures) > 0 {
+		return errors.New("batch has already been signed")
+	}
+	p := b.Participants[i]
+
+	// fund the participant's contribution; the miner fee is fixed by the
+	// terms, so change cannot be added to it
+	opts.dustThreshold = types.ZeroCurrency
+	var funding SwapTransaction
+	if !p.SendSC.IsZero() {
+		if err := addSC(w, &funding, p.SendSC, opts); err != nil {
+			return fmt.Errorf("failed to add siacoin inputs: %w", err)
+		}
+	}
+	if !p.SendSF.IsZero() {
+		if err := addSF(w, &funding, p.SendSF, opts); err != nil {
+			return fmt.Errorf("failed to add siafund inputs: %w", err)
+		}
+	}
+	scos, sfos, err := payProceeds(w, Basket{SC: p.ReceiveSC, SF: p.ReceiveSF}, opts.destinations)
+	if err != nil {
+		return err
+	}
+	p.SiacoinOutputs = append(p.SiacoinOutputs, scos...)
+	p.SiafundOutputs = append(p.SiafundOutputs, sfos...)
+	p.SiacoinInputs = funding.SiacoinInputs
+	p.SiafundInputs = funding.SiafundInputs
+	p.SiacoinOutputs = append(p.SiacoinOutputs, funding.SiacoinOutputs...)
+	p.SiafundOutputs = append(p.SiafundOutputs, funding.SiafundOutputs...)
+	p.Joined = true
+	b.Participants[i] = p
+	return nil
+}
+
+// checkBatch checks that the batch is complete and that our net change in
+// siacoins and siafunds matches the terms we agreed to. The net change is
+// computed from every input and output in the transaction that belongs to the
+// wallet, regardless of which participant declared it.
+func checkBatch(w Wallet, b BatchSwap, name string) error {
+	i, err := b.participant(name)
+	if err != nil {
+		return err
+	} else if err := checkBatchTerms(b); err != nil {
+		return err
+	} else if !b.joined() {
+		return errors.New("not every participant has joined the batch")
+	}
+	p := b.Participants[i]
+
+	in, out, err := ourNetChange(w, b.transaction())
+	if err != nil {
+		return err
+	}
+	proceeds, err := whitelistedProceeds(w, b.transaction(), Basket{SC: p.ReceiveSC, SF: p.ReceiveSF})
+	if err != nil {
+		return err
+	}
+	out.SC, out.SF = out.SC.Add(proceeds.SC), out.SF.Add(proceeds.SF)
+	// compare out - in against receive - send without going negative
+	if !out.SC.Add(p.SendSC).Equals(in.SC.Add(p.ReceiveSC)) {
+		return fmt.Errorf("batch does not give us the agreed siacoins: we contribute %v and receive %v", in.SC.HumanString(), out.SC.HumanString())
+	} else if !out.SF.Add(p.SendSF).Equals(in.SF.Add(p.ReceiveSF)) {
+		return fmt.Errorf("batch does not give us the agreed siafunds: we contribute %v SF and receive %v SF", in.SF, out.SF)
+	}
+	return nil
+}
+
+// batchFullySigned reports whether every input of the batch has a signature.
+func batchFullySigned(b BatchSwap) bool {
+	txn := b.transaction()
+	return len(b.Signatures) == len(txn.SiacoinInputs)+len(txn.SiafundInputs)
+}
+
+// signBatch checks the batch as the named participant, then signs every input
+// of the batch that belongs to w.
+func signBatch(w Wallet, b *BatchSwap, name string) error {
+	if err := checkBatch(w, *b, name); err != nil {
+		return err
+	}
+	addrs, err := w.Addresses()
+	if err != nil {
+		return fmt.Errorf("failed to get wallet addresses: %w", err)
+	}
+	belongsToUs := make(map[types.UnlockHash]bool)
+	for _, addr := range addrs {
+		belongsToUs[addr] = true
+	}
+	signed := make(map[crypto.Hash]bool)
+	for _, sig := range b.Signatures {
+		signed[sig.ParentID] = true
+	}
+
+	txn := b.transaction()
+	var toSign []crypto.Hash
+	addSig := func(id crypto.Hash, uh types.UnlockHash) {
+		if belongsToUs[uh] && !signed[id] {
+			txn.TransactionSignatures = append(txn.TransactionSignatures, types.TransactionSignature{
+				ParentID:       id,
+				PublicKeyIndex: 0,
+				CoveredFields:  types.FullCoveredFields,
+			})
+			toSign = append(toSign, id)
+		}
+	}
+	for _, sci := range txn.SiacoinInputs {
+		addSig(crypto.Hash(sci.ParentID), sci.UnlockConditions.UnlockHash())
+	}
+	for _, sfi := range txn.SiafundInputs {
+		addSig(crypto.Hash(sfi.ParentID), sfi.UnlockConditions.UnlockHash())
+	}
+	if len(toSign) == 0 {
+		return nil
+	} else if err := w.SignTransaction(&txn, toSign); err != nil {
+		return fmt.Errorf("failed to sign batch: %w", err)
+	}
+	b.Signatures = txn.TransactionSignatures
+	return nil
+}
+
+// mergeBatches combines the signatures of several copies of the same batch.
+func mergeBatches(batches ...BatchSwap) (BatchSwap, error) {
+	if len(batches) == 0 {
+		return BatchSwap{}, errors.New("no batches to merge")
+	}
+	merged := batches[0]
+	merged.Signatures = append([]types.TransactionSignature(nil), merged.Signatures...)
+	signed := make(map[crypto.Hash]bool)
+	for _, sig := range merged.Signatures {
+		signed[sig.ParentID] = true
+	}
+	id := merged.transaction().ID()
+	for _, b := range batches[1:] {
+		if b.transaction().ID() != id {
+			return BatchSwap{}, errors.New("batches are not the same transaction")
+		}
+		for _, sig := range b.Signatures {
+			if !signed[sig.ParentID] {
+				merged.Signatures = append(merged.Signatures, sig)
+				signed[sig.ParentID] = true
+			}
+		}
+	}
+	return merged, nil
+}
+
+// finishBatch signs the batch as the named participant and, if every input is
+// then signed, broadcasts it.
+func finishBatch(b Backend, batch *BatchSwap, name string) error {
+	if err := signBatch(b, batch, name); err != nil {
+		return err
+	} else if !batchFullySigned(*batch) {
+		return nil
+	}
+	return b.BroadcastTransaction(batch.transaction())
+}
+
+// batchStatus returns the status of a batch swap.
+func batchStatus(w Wallet, b BatchSwap) string {
+	if !b.joined() {
+		return waitingForParticipantsToJoin
+	}
+	pt, err := w.Transaction(b.transaction().ID())
+	if err != nil {
+		return waitingForSignatures
+	} else if pt.ConfirmationHeight == math.MaxUint64 {
+		return swapTransactionPending
+	}
+	return swapTransactionConfirmed
+}
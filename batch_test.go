@@ -0,0 +1,219 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.sia.tech/siad/types"
+)
+
+func TestBatchSwap(t *testing.T) {
+	c, alice, bob := newTestSwappers()
+	carol := newMemWallet(c, types.ZeroCurrency, types.NewCurrency64(5))
+	sc := types.SiacoinPrecision.Mul64
+
+	var participants []BatchParticipant
+	for _, terms := range []string{"alice=300SC:3SF", "bob=2SF:150SC", "carol=1SF:149SC"} {
+		p, err := parseBatchTerms(terms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		participants = append(participants, p)
+	}
+	batch, err := createBatch(participants)
+	if err != nil {
+		t.Fatal(err)
+	} else if !batch.minerFee().Equals(sc(1)) {
+		t.Fatalf("expected miner fee of 1 SC, got %v", batch.minerFee().HumanString())
+	} else if s := batchStatus(alice, batch); s != waitingForParticipantsToJoin {
+		t.Fatalf("expected status %q, got %q", waitingForParticipantsToJoin, s)
+	}
+
+	// participants join in turn
+	for _, p := range []struct {
+		w    *memWallet
+		name string
+	}{{alice, "alice"}, {bob, "bob"}, {carol, "carol"}} {
+		if err := signBatch(p.w, &batch, p.name); err == nil {
+			t.Fatal("expected signing to fail before every participant has joined")
+		} else if err := joinBatch(p.w, &batch, p.name, fundingOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := joinBatch(alice, &batch, "alice", fundingOptions{}); err == nil {
+		t.Fatal("expected joining twice to fail")
+	} else if s := batchStatus(alice, batch); s != waitingForSignatures {
+		t.Fatalf("expected status %q, got %q", waitingForSignatures, s)
+	}
+
+	// a participant cannot sign for terms that are not their own
+	if err := checkBatch(bob, batch, "carol"); err == nil {
+		t.Fatal("expected bob to reject carol's terms")
+	}
+
+	// each participant signs a separate copy, which are then merged
+	var copies []BatchSwap
+	for _, p := range []struct {
+		w    *memWallet
+		name string
+	}{{alice, "alice"}, {bob, "bob"}, {carol, "carol"}} {
+		b := batch
+		if err := signBatch(p.w, &b, p.name); err != nil {
+			t.Fatal(err)
+		}
+		copies = append(copies, b)
+	}
+	if _, err := mergeBatches(copies[0], batch, BatchSwap{}); err == nil {
+		t.Fatal("expected merging different batches to fail")
+	}
+	merged, err := mergeBatches(copies...)
+	if err != nil {
+		t.Fatal(err)
+	} else if !batchFullySigned(merged) {
+		t.Fatal("expected merged batch to be fully signed")
+	} else if name := merged.owner(bob); name != "bob" {
+		t.Fatalf("expected bob to own his inputs, got %q", name)
+	} else if err := c.BroadcastTransaction(merged.transaction()); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	if s := batchStatus(alice, merged); s != swapTransactionConfirmed {
+		t.Fatalf("expected status %q, got %q", swapTransactionConfirmed, s)
+	}
+
+	for _, want := range []struct {
+		w      *memWallet
+		name   string
+		sc, sf types.Currency
+	}{
+		{alice, "alice", sc(700), types.NewCurrency64(3)},
+		{bob, "bob", sc(150), types.NewCurrency64(8)},
+		{carol, "carol", sc(149), types.NewCurrency64(4)},
+	} {
+		if sc, sf := want.w.balance(); !sc.Equals(want.sc) || !sf.Equals(want.sf) {
+			t.Errorf("%v has wrong balance after swap: %v SC, %v SF", want.name, sc.HumanString(), sf)
+		}
+	}
+}
+
+func TestBatchReservations(t *testing.T) {
+	c, alice, bob := newTestSwappers()
+	j, err := openJournal(filepath.Join(t.TempDir(), "swaps.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBatch := func() BatchSwap {
+		var participants []BatchParticipant
+		for _, terms := range []string{"alice=600SC:2SF", "bob=2SF:599SC"} {
+			p, err := parseBatchTerms(terms)
+			if err != nil {
+				t.Fatal(err)
+			}
+			participants = append(participants, p)
+		}
+		batch, err := createBatch(participants)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return batch
+	}
+
+	// alice's single output can fund only one of two concurrent joins
+	batches := []BatchSwap{newBatch(), newBatch()}
+	errs := make(chan error, len(batches))
+	for i := range batches {
+		go func(b *BatchSwap) {
+			_, err := reserveBatchJoin(alice, j, b, "alice", fundingOptions{})
+			errs <- err
+		}(&batches[i])
+	}
+	var failed int
+	for range batches {
+		if err := <-errs; err != nil {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("expected exactly one join to fail, got %v", failed)
+	}
+	batch := batches[0]
+	if !batch.Participants[0].Joined {
+		batch = batches[1]
+	}
+
+	// the joined batch is recorded, and its inputs reserved from other swaps
+	records := j.Swaps()
+	if len(records) != 1 || records[0].Stage != stageAccepted {
+		t.Fatalf("expected one record at stage %q, got %+v", stageAccepted, records)
+	} else if _, err := j.FundSwap(alice, stageCreated, func(w Wallet) (SwapTransaction, error) {
+		return createSwap(w, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(1)}, testMinerFee, feePayerSC, fundingOptions{})
+	}); err == nil {
+		t.Fatal("expected the joined batch's inputs to be reserved")
+	}
+
+	// once signed and broadcast, the record follows the batch transaction
+	if err := joinBatch(bob, &batch, "bob", fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := signBatch(bob, &batch, "bob"); err != nil {
+		t.Fatal(err)
+	} else if err := finishBatch(alice, &batch, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	recordSwap(j, batch.journalSwap("alice"), stageBroadcast)
+	c.mine()
+	if err := j.Refresh(alice); err != nil {
+		t.Fatal(err)
+	} else if r, err := j.Swap(records[0].ID); err != nil {
+		t.Fatal(err)
+	} else if r.Stage != stageConfirmed || r.TxnID != batch.transaction().ID() {
+		t.Fatalf("expected record of confirmed batch, got stage %q", r.Stage)
+	}
+}
+
+func TestBatchTampering(t *testing.T) {
+	c, alice, bob := newTestSwappers()
+	carol := newMemWallet(c, types.ZeroCurrency, types.NewCurrency64(5))
+
+	batch, err := createBatch([]BatchParticipant{
+		{Name: "alice", SendSC: types.SiacoinPrecision.Mul64(300), ReceiveSF: types.NewCurrency64(3)},
+		{Name: "bob", SendSF: types.NewCurrency64(2), ReceiveSC: types.SiacoinPrecision.Mul64(150)},
+		{Name: "carol", SendSF: types.NewCurrency64(1), ReceiveSC: types.SiacoinPrecision.Mul64(149)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := joinBatch(alice, &batch, "alice", fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := joinBatch(bob, &batch, "bob", fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := joinBatch(carol, &batch, "carol", fundingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// carol redirects one of alice's siafunds to herself
+	tampered := batch
+	tampered.Participants = append([]BatchParticipant(nil), batch.Participants...)
+	alicePart := tampered.Participants[0]
+	alicePart.SiafundOutputs = append([]types.SiafundOutput(nil), alicePart.SiafundOutputs...)
+	alicePart.SiafundOutputs[0].Value = types.NewCurrency64(2)
+	tampered.Participants[0] = alicePart
+	carolPart := tampered.Participants[2]
+	addr, _ := carol.Address()
+	carolPart.SiafundOutputs = append(carolPart.SiafundOutputs, types.SiafundOutput{UnlockHash: addr, Value: types.NewCurrency64(1)})
+	tampered.Participants[2] = carolPart
+	if err := signBatch(alice, &tampered, "alice"); err == nil {
+		t.Fatal("expected alice to reject a batch that shortchanges her")
+	}
+
+	// the terms must balance
+	for _, terms := range [][]BatchParticipant{
+		{{Name: "alice", SendSC: types.SiacoinPrecision, ReceiveSF: types.NewCurrency64(1)}},
+		{{Name: "alice", SendSC: types.SiacoinPrecision, ReceiveSF: types.NewCurrency64(1)}, {Name: "alice", SendSF: types.NewCurrency64(1)}},
+		{{Name: "alice", SendSC: types.SiacoinPrecision, ReceiveSF: types.NewCurrency64(2)}, {Name: "bob", SendSF: types.NewCurrency64(1)}},
+		{{Name: "alice", SendSC: types.SiacoinPrecision, ReceiveSF: types.NewCurrency64(1)}, {Name: "bob", SendSF: types.NewCurrency64(1), ReceiveSC: types.SiacoinPrecision}},
+	} {
+		if _, err := createBatch(terms); err == nil {
+			t.Errorf("expected terms %v to be rejected", terms)
+		}
+	}
+}
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
 	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+	"rsc.io/qr"
 )
 
 var statusToDescription = map[string]string{
@@ -15,34 +25,130 @@ var statusToDescription = map[string]string{
 	waitingForCounterpartyToFinish: "Waiting for counterparty to finish",
 	swapTransactionPending:         "Swap transaction pending",
 	swapTransactionConfirmed:       "Swap transaction confirmed",
+	swapCancelled:                  "Swap cancelled",
+	offerClosed:                    "Offer filled or withdrawn",
+	waitingForParticipantsToJoin:   "Waiting for participants to join",
+	waitingForSignatures:           "Waiting for signatures",
+}
+
+// outputOptions control how a swap file is passed to the counterparty.
+type outputOptions struct {
+	encrypt EncryptionOptions
+	qr      bool
+	// mailbox, if set, is the URL of a relay mailbox to post the swap file
+	// to, instead of writing it to disk.
+	mailbox string
+	// pairing, if set, is a session with the counterparty to send the swap
+	// file through.
+	pairing *pairing
 }
 
-func encodeSwapFile(s SwapTransaction) (string, error) {
-	txnID := s.transaction().ID()
+func encodeSwapFile(txnID types.TransactionID, envelope interface{}) (string, error) {
 	f, err := os.Create(fmt.Sprintf("embc_txn_%x.json", txnID[:4]))
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
-	if err := encodeJSON(f, s); err != nil {
+	if err := encodeJSON(f, envelope); err != nil {
 		return "", err
 	}
 	return f.Name(), nil
 }
 
-func decodeSwapFile(filePath string) (swap SwapTransaction, err error) {
-	f, err := os.Open(filePath)
+// decodeSwapFile decodes the swap file at filePath, or, if filePath is the
+// text encoding of a swap file, the text itself. Encrypted swap files are
+// decrypted with key, or with a passphrase that is prompted for.
+func decodeSwapFile(filePath string, key x25519Key) (SwapFile, error) {
+	if strings.HasPrefix(filePath, swapTextPrefix) {
+		return decodeSwapText(filePath)
+	} else if strings.HasPrefix(filePath, encryptedTextPrefix) {
+		ef, err := decodeEncryptedText(filePath)
+		if err != nil {
+			return SwapFile{}, err
+		}
+		return decryptSwapFile(ef, promptPassphrase, key)
+	}
+	js, err := os.ReadFile(filePath)
 	if err != nil {
-		return SwapTransaction{}, err
+		return SwapFile{}, err
 	}
-	defer f.Close()
-	err = json.NewDecoder(f).Decode(&swap)
-	return
+	return decodeSwapEnvelope(js, promptPassphrase, key)
+}
+
+// readSwapInput reads a swap file from input, which is either the URL of a
+// relay mailbox or a file path or text accepted by decodeSwapFile. From a
+// mailbox, the latest swap at stage is pulled, waiting for the counterparty
+// to post it if necessary.
+func readSwapInput(input, stage string, key x25519Key) (SwapFile, error) {
+	if !isMailboxURL(input) {
+		return decodeSwapFile(input, key)
+	}
+	msg, err := pullMailbox(input, stage, false)
+	if errors.Is(err, errNoSwapPosted) {
+		fmt.Println("Waiting for the counterparty to post the swap to the mailbox...")
+		fmt.Println()
+		msg, err = pullMailbox(input, stage, true)
+	}
+	if err != nil {
+		return SwapFile{}, err
+	}
+	return decodeSwapEnvelope(msg.Envelope, promptPassphrase, key)
+}
+
+func promptPassphrase() (string, error) {
+	return readPassphrase(false)
+}
+
+// readPassphrase prompts for a passphrase with which to encrypt or decrypt a
+// swap file. If confirm is set, the passphrase must be entered twice.
+func readPassphrase(confirm bool) (string, error) {
+	r := bufio.NewReader(os.Stdin)
+	fmt.Printf("Passphrase: ")
+	p, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return "", errors.New("passphrase must not be empty")
+	}
+	if confirm {
+		fmt.Printf("Confirm passphrase: ")
+		p2, err := r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		} else if strings.TrimSpace(p2) != p {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	fmt.Println()
+	return p, nil
+}
+
+// parseEncryption parses the -encrypt flag: either 'passphrase', in which
+// case a passphrase is prompted for, or the X25519 public key of the
+// counterparty.
+func parseEncryption(s string) (EncryptionOptions, error) {
+	var enc EncryptionOptions
+	switch {
+	case s == "":
+	case s == schemePassphrase:
+		p, err := readPassphrase(true)
+		if err != nil {
+			return EncryptionOptions{}, err
+		}
+		enc.Passphrase = p
+	case strings.HasPrefix(s, x25519Prefix):
+		enc.Recipient = s
+	default:
+		return EncryptionOptions{}, fmt.Errorf("invalid -encrypt value %q; must be 'passphrase' or an X25519 public key", s)
+	}
+	return enc, enc.check()
 }
 
 func noUserInteractionRequired(s SwapSummary) bool {
 	switch s.Status {
-	case waitingForCounterpartyToAccept, waitingForCounterpartyToFinish, swapTransactionPending, swapTransactionConfirmed:
+	case waitingForCounterpartyToAccept, waitingForCounterpartyToFinish, swapTransactionPending, swapTransactionConfirmed, swapCancelled, offerClosed:
 		return true
 	default:
 		return false
@@ -51,7 +157,7 @@ func noUserInteractionRequired(s SwapSummary) bool {
 
 func userStepsComplete(s SwapSummary) bool {
 	switch s.Status {
-	case swapTransactionPending, swapTransactionConfirmed:
+	case swapTransactionPending, swapTransactionConfirmed, swapCancelled, offerClosed:
 		return true
 	default:
 		return false
@@ -68,33 +174,80 @@ func acceptStepsComplete(s SwapSummary) bool {
 }
 
 func printSummary(s SwapSummary) error {
-	ours, theirs := s.AmountSC.HumanString(), s.AmountSF.String()+" SF"
-	if s.ReceiveSF {
-		theirs, ours = ours, theirs
-	}
 	fmt.Println("Swap summary:")
-	fmt.Println("  You receive:           ", ours)
-	fmt.Println("  Counterparty receives: ", theirs)
+	fmt.Println("  You receive:           ", s.You.Receive)
+	fmt.Println("  Counterparty receives: ", s.Counterparty.Receive)
 	fmt.Println("  Status:                ", statusToDescription[s.Status])
-	if s.ReceiveSF {
-		fmt.Println()
-		fmt.Println("  You will also pay the 5 SC transaction fee.")
+	ourFee, theirFee := s.You.Fee, s.Counterparty.Fee
+	fmt.Println()
+	switch {
+	case theirFee.IsZero():
+		fmt.Printf("  You will also pay the %v transaction fee.\n", s.MinerFee.HumanString())
+	case ourFee.IsZero():
+		fmt.Printf("  The counterparty will pay the %v transaction fee.\n", s.MinerFee.HumanString())
+	default:
+		fmt.Printf("  You will pay %v of the %v transaction fee.\n", ourFee.HumanString(), s.MinerFee.HumanString())
+	}
+	if !ourFee.IsZero() && !s.You.Receive.SC.IsZero() {
+		fmt.Println("  Your share of the fee is deducted from the siacoins you receive.")
+	} else if !theirFee.IsZero() && !s.Counterparty.Receive.SC.IsZero() {
+		fmt.Println("  The counterparty's share of the fee is deducted from the siacoins they receive.")
+	}
+	if s.Open {
+		fmt.Println("  This is an open offer: anyone with the transaction file can fill it.")
 	}
 	return nil
 }
 
-func printTransaction(swap SwapTransaction) error {
-	s, err := summarize(swap)
+// printSwapFile prints the memo and expiry of a swap file, if any.
+func printSwapFile(f SwapFile) {
+	if f.Memo != "" {
+		fmt.Println("  Memo:                  ", f.Memo)
+	}
+	if f.Expiry != 0 {
+		fmt.Println("  Expires at height:     ", f.Expiry)
+	}
+}
+
+func printTransaction(w Wallet, f SwapFile, recordID string, out outputOptions) error {
+	s, err := summarize(w, f.Swap)
 	if err != nil {
 		return err
 	}
-	nextFilePath, err := encodeSwapFile(swap)
+	if out.pairing != nil {
+		if err := out.pairing.send(swapStage(s), f); err != nil {
+			return err
+		}
+		fmt.Println("Transaction:")
+		fmt.Println("  ID:   ", f.Swap.transaction().ID())
+		if recordID != "" {
+			fmt.Println("  Swap: ", recordID)
+		}
+		fmt.Println()
+		return nil
+	}
+	envelope, text, err := sealSwapFile(f, out.encrypt)
 	if err != nil {
 		return err
 	}
+	next := out.mailbox
+	if next != "" {
+		if err := postMailbox(next, swapStage(s), envelope); err != nil {
+			return err
+		}
+	} else if next, err = encodeSwapFile(f.Swap.transaction().ID(), envelope); err != nil {
+		return err
+	}
 	fmt.Println("Transaction:")
-	fmt.Println("  ID:   ", swap.transaction().ID())
-	fmt.Println("  File: ", nextFilePath)
+	fmt.Println("  ID:     ", f.Swap.transaction().ID())
+	if recordID != "" {
+		fmt.Println("  Swap:   ", recordID)
+	}
+	if out.mailbox != "" {
+		fmt.Println("  Mailbox:", next)
+	} else {
+		fmt.Println("  File:   ", next)
+	}
 	fmt.Println()
 	if userStepsComplete(s) {
 		return nil
@@ -103,89 +256,901 @@ func printTransaction(swap SwapTransaction) error {
 	if acceptStepsComplete(s) {
 		command = "finish"
 	}
-	fmt.Println("To proceed, send your counterparty the transaction file and ask them to run the following command:")
+	switch {
+	case out.mailbox != "" && s.Open:
+		fmt.Println("To proceed, publish the mailbox URL. Anyone can fill the offer by running the following command:")
+	case out.mailbox != "":
+		fmt.Println("To proceed, send your counterparty the mailbox URL and ask them to run the following command:")
+	case s.Open:
+		fmt.Println("To proceed, publish the transaction file. Anyone can fill the offer by running the following command:")
+	default:
+		fmt.Println("To proceed, send your counterparty the transaction file and ask them to run the following command:")
+	}
 	fmt.Println()
-	fmt.Println("  embc", command, nextFilePath)
+	fmt.Println("  embc", command, next)
 	fmt.Println()
+	if out.encrypt.Passphrase != "" {
+		fmt.Println("The swap is encrypted; your counterparty will be asked for the passphrase.")
+		fmt.Println()
+	} else if out.encrypt.Recipient != "" {
+		fmt.Println("The swap is encrypted; only the holder of", out.encrypt.Recipient, "can read it.")
+		fmt.Println()
+	}
+	if out.mailbox != "" {
+		if command == "accept" && !s.Open {
+			fmt.Println("Once they have, the following command waits for their response and finishes the swap:")
+			fmt.Println()
+			fmt.Println("  embc finish", next)
+			fmt.Println()
+		}
+		text = next
+	} else {
+		fmt.Println("Alternatively, the following text can be sent and pasted in place of the file path:")
+		fmt.Println()
+		fmt.Println(" ", text)
+		fmt.Println()
+	}
+	if out.qr {
+		if err := writeQR(os.Stdout, text); err != nil {
+			log.Println("Warning: failed to print QR code:", err)
+		}
+		fmt.Println()
+	}
 	return nil
 }
 
-func createCLI(inStr, outStr string) {
-	if strings.Contains(inStr, "SF") == strings.Contains(outStr, "SF") {
-		log.Fatal("Invalid swap: must specify one SC value and one SF value")
+// writeQR writes s to w as a QR code, drawn with Unicode half blocks so that
+// each line holds two rows of modules. As with qrencode, light modules are
+// drawn and dark ones left blank, which suits terminals with a dark
+// background.
+func writeQR(w io.Writer, s string) error {
+	code, err := qr.Encode(s, qr.L)
+	if err != nil {
+		return err
+	}
+	const quiet = 2
+	var sb strings.Builder
+	for y := -quiet; y < code.Size+quiet; y += 2 {
+		for x := -quiet; x < code.Size+quiet; x++ {
+			top, bottom := !code.Black(x, y), !code.Black(x, y+1)
+			if y+1 >= code.Size+quiet {
+				bottom = false
+			}
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+// createOptions are the flags of 'embc create'.
+type createOptions struct {
+	fee      string
+	feePayer string
+	// strategy, dust, allowUnconfirmed, and to are parsed into
+	// fundingOptions.
+	strategy         string
+	dust             string
+	allowUnconfirmed bool
+	to               string
+	open             bool
+	offline          bool
+	expiry           types.BlockHeight
+	memo             string
+	label            string
+	notes            string
+	encrypt          string
+	relay            string
+	share            bool
+	qr               bool
+}
+
+func createCLI(b Backend, j *journal, inStr, outStr string, co createOptions) {
+	if co.offline && !co.open {
+		log.Fatal("-offline requires -open; a swap is not signed until it is accepted")
+	} else if co.share && co.relay == "" {
+		log.Fatal("-share requires -relay, the relay through which to pair with the counterparty")
+	} else if co.share && (co.open || co.offline || co.encrypt != "") {
+		log.Fatal("-share cannot be combined with -open, -offline, or -encrypt")
+	}
+	out := outputOptions{qr: co.qr}
+	enc, err := parseEncryption(co.encrypt)
+	if err != nil {
+		log.Fatal(err)
 	}
-	input, output := parseCurrency(inStr), parseCurrency(outStr)
-	swap, err := createSwap(input, output, strings.Contains(inStr, "SF"))
+	out.encrypt = enc
+	if co.relay != "" && !co.offline && !co.share {
+		if out.mailbox, err = createMailbox(co.relay); err != nil {
+			log.Fatal(err)
+		}
+	}
+	send, err := parseBasket(inStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	receive, err := parseBasket(outStr)
 	if err != nil {
 		log.Fatal(err)
 	}
-	sum, err := summarize(swap)
+	fee, err := parseMinerFee(b, co.fee)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts, err := parseFundingOptions(co.strategy, co.dust, co.allowUnconfirmed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.destinations, err = parseDestinations(co.to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	create := createSwap
+	if co.open {
+		create = createOffer
+	}
+	r, err := j.FundSwap(signingBackend(b, co.offline), stageCreated, func(w Wallet) (SwapTransaction, error) {
+		return create(w, send, receive, fee, co.feePayer, opts)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	annotateSwap(j, r.ID, co.label, co.notes)
+	sum, err := summarize(b, r.Swap)
 	if err != nil {
 		log.Fatal(err)
 	}
 	printSummary(sum)
 	fmt.Println()
-	printTransaction(swap)
+	f, err := newSwapFile(b, r.Swap, co.expiry, co.memo)
+	if err != nil {
+		log.Fatal(err)
+	}
+	recordExpiry(j, r.ID, f)
+	if co.offline {
+		printSigningRequest(b, f, stageCreated)
+		return
+	} else if !co.share {
+		printTransaction(b, f, r.ID, out)
+		return
+	}
+
+	// pair with the counterparty and conduct the rest of the swap through
+	// the relay
+	n, mailbox, err := allocateNameplate(co.relay)
+	if err != nil {
+		log.Fatal(err)
+	}
+	code := generateCode(n)
+	fmt.Println("Code:", code)
+	fmt.Println()
+	fmt.Println("To proceed, tell your counterparty the code and ask them to run the following command:")
+	fmt.Println()
+	fmt.Println("  embc accept -relay", co.relay, code)
+	fmt.Println()
+	fmt.Printf("Waiting up to %v for your counterparty to enter the code...\n", pairTimeout)
+	if out.pairing, err = pair(mailbox, code, true, pairTimeout); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("  Paired!")
+	fmt.Println()
+	if err := printTransaction(b, f, r.ID, out); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Waiting for your counterparty to accept the swap...")
+	fmt.Println()
+	af, err := out.pairing.receive(stageAccepted)
+	if err != nil {
+		log.Fatal(err)
+	}
+	finishSwapFile(b, j, af, false, out)
+}
+
+// acceptOptions are the flags of 'embc accept'.
+type acceptOptions struct {
+	// strategy, dust, allowUnconfirmed, and to are parsed into
+	// fundingOptions.
+	strategy         string
+	dust             string
+	allowUnconfirmed bool
+	to               string
+	offline          bool
+	label            string
+	notes            string
+	encrypt          string
+	relay            string
+	qr               bool
 }
 
-func acceptCLI(filePath string) {
-	swap, err := decodeSwapFile(filePath)
+func acceptCLI(b Backend, j *journal, key x25519Key, filePath string, ao acceptOptions) {
+	var f SwapFile
+	var err error
+	out := outputOptions{qr: ao.qr}
+	if isCode(filePath) {
+		if ao.relay == "" {
+			log.Fatal("Accepting a swap by its code requires -relay, the relay through which to pair with the counterparty")
+		} else if ao.offline || ao.encrypt != "" {
+			log.Fatal("Accepting a swap by its code cannot be combined with -offline or -encrypt")
+		}
+		f, out.pairing = pairWithCreator(ao.relay, filePath)
+	} else if f, err = readSwapInput(filePath, stageCreated, key); err != nil {
+		log.Fatal(err)
+	} else if out.encrypt, err = parseEncryption(ao.encrypt); err != nil {
+		log.Fatal(err)
+	} else if isMailboxURL(filePath) {
+		out.mailbox = filePath
+	}
+	swap := f.Swap
+	opts, err := parseFundingOptions(ao.strategy, ao.dust, ao.allowUnconfirmed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.destinations, err = parseDestinations(ao.to)
 	if err != nil {
 		log.Fatal(err)
 	}
-	sum, err := summarize(swap)
+	sum, err := summarize(b, swap)
 	if err != nil {
 		log.Fatal(err)
 	}
 	printSummary(sum)
+	printSwapFile(f)
 	if noUserInteractionRequired(sum) {
 		return
 	}
-	if err := checkAccept(swap); err != nil {
+	if err := checkSwapFile(b, f); err != nil {
+		log.Fatal(err)
+	} else if err := checkAccept(swap); err != nil {
 		log.Fatal(err)
 	}
 	fmt.Println()
-	fmt.Printf("Accept this swap? [y/n]: ")
+	if isOpenOffer(swap) {
+		fmt.Printf("Fill this offer and broadcast the transaction? [y/n]: ")
+	} else {
+		fmt.Printf("Accept this swap? [y/n]: ")
+	}
 	var resp string
 	fmt.Scanln(&resp)
 	fmt.Println()
 	if !strings.EqualFold(resp, "y") {
 		log.Fatal("  Swap cancelled.")
-	} else if err = acceptSwap(&swap); err != nil {
+	}
+	if isOpenOffer(swap) && ao.offline {
+		r, err := j.FundSwap(signingBackend(b, ao.offline), stageAccepted, func(w Wallet) (SwapTransaction, error) {
+			err := fillOffer(w, &swap, opts)
+			return swap, err
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		annotateSwap(j, r.ID, ao.label, ao.notes)
+		recordExpiry(j, r.ID, f)
+		printSigningRequest(b, f.withSwap(swap), stageBroadcast)
+		return
+	} else if isOpenOffer(swap) {
+		r, err := j.FundSwap(b, stageBroadcast, func(w Wallet) (SwapTransaction, error) {
+			err := takeOffer(w, b, &swap, opts)
+			return swap, err
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		annotateSwap(j, r.ID, ao.label, ao.notes)
+		fmt.Println("  Successfully filled offer and broadcast swap transaction!")
+		fmt.Println()
+		printTransaction(b, f.withSwap(swap), r.ID, out)
+		return
+	}
+	r, err := j.FundSwap(signingBackend(b, ao.offline), stageAccepted, func(w Wallet) (SwapTransaction, error) {
+		err := acceptSwap(w, &swap, opts)
+		return swap, err
+	})
+	if err != nil {
 		log.Fatal(err)
 	}
+	annotateSwap(j, r.ID, ao.label, ao.notes)
+	recordExpiry(j, r.ID, f)
+	if ao.offline {
+		printSigningRequest(b, f.withSwap(swap), stageAccepted)
+		return
+	}
 	fmt.Println("  Swap accepted!")
 	fmt.Println()
-	printTransaction(swap)
+	printTransaction(b, f.withSwap(swap), r.ID, out)
+	if out.pairing != nil {
+		fmt.Println("Waiting for your counterparty to finish the swap...")
+		bf, err := out.pairing.receive(stageBroadcast)
+		if err != nil {
+			log.Fatal(err)
+		}
+		recordSwap(j, bf.Swap, stageBroadcast)
+		fmt.Println("  Your counterparty broadcast the swap transaction!")
+		fmt.Println()
+	}
 }
 
-func finishCLI(filePath string) {
-	swap, err := decodeSwapFile(filePath)
+// pairWithCreator pairs with the creator of a swap using the code they shared,
+// and receives the swap.
+func pairWithCreator(relay, code string) (SwapFile, *pairing) {
+	n, err := parseCode(code)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mailbox, err := claimNameplate(relay, n)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := pair(mailbox, code, false, pairTimeout)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err := checkFinish(swap, false); err != nil {
+	fmt.Println("Paired! Waiting for the swap...")
+	fmt.Println()
+	f, err := p.receive(stageCreated)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return f, p
+}
+
+func finishCLI(b Backend, j *journal, key x25519Key, filePath string, offline, qr bool) {
+	f, err := readSwapInput(filePath, stageAccepted, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := outputOptions{qr: qr}
+	if isMailboxURL(filePath) {
+		out.mailbox = filePath
+	}
+	finishSwapFile(b, j, f, offline, out)
+}
+
+// finishSwapFile prompts to finish the swap in f, then signs and broadcasts
+// it, or with offline, exports a signing request for it.
+func finishSwapFile(b Backend, j *journal, f SwapFile, offline bool, out outputOptions) {
+	swap := f.Swap
+	if err := checkFinish(b, swap, false); err != nil {
+		log.Fatal(err)
+	} else if err := j.CheckChangeFee(swap); err != nil {
 		log.Fatal(err)
 	}
-	sum, err := summarize(swap)
+	sum, err := summarize(b, swap)
 	if err != nil {
 		log.Fatal(err)
 	}
 	printSummary(sum)
+	printSwapFile(f)
 	if noUserInteractionRequired(sum) {
 		return
+	} else if err := checkSwapFile(b, f); err != nil {
+		log.Fatal(err)
 	}
 	fmt.Println()
-	fmt.Printf("Sign and broadcast this transaction? [y/n]: ")
+	if offline {
+		fmt.Printf("Export a signing request for this transaction? [y/n]: ")
+	} else {
+		fmt.Printf("Sign and broadcast this transaction? [y/n]: ")
+	}
 	var resp string
 	fmt.Scanln(&resp)
 	fmt.Println()
 	if !strings.EqualFold(resp, "y") {
 		log.Fatal("  Swap cancelled.")
-	} else if err := finishSwap(&swap); err != nil {
+	} else if offline {
+		if err := signInputs(watchOnlyBackend{b}, &swap); err != nil {
+			log.Fatal(err)
+		}
+		printSigningRequest(b, f.withSwap(swap), stageBroadcast)
+		return
+	} else if err := finishSwap(b, &swap); err != nil {
+		if fullySigned(swap) {
+			recordSwap(j, swap, stageFinished)
+		}
+		log.Fatal(err)
+	}
+	r := recordSwap(j, swap, stageBroadcast)
+	fmt.Println("  Successfully broadcast swap transaction!")
+	fmt.Println()
+	printTransaction(b, f.withSwap(swap), r.ID, out)
+}
+
+// cancelWait is how long cancelCLI waits for a cancellation to confirm.
+const cancelWait = 3 * time.Hour
+
+func cancelCLI(b Backend, j *journal, key x25519Key, filePath string) {
+	f, err := readSwapInput(filePath, "", key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	swap := f.Swap
+	sum, err := summarize(b, swap)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printSummary(sum)
+	switch sum.Status {
+	case swapTransactionPending, swapTransactionConfirmed:
+		log.Fatal("Swap transaction has already been broadcast and can no longer be cancelled")
+	case swapCancelled, offerClosed:
+		return
+	}
+	fmt.Println()
+	fmt.Printf("Cancel this swap by spending your inputs back to your wallet? [y/n]: ")
+	var resp string
+	fmt.Scanln(&resp)
+	fmt.Println()
+	if !strings.EqualFold(resp, "y") {
+		log.Fatal("  Swap not cancelled.")
+	}
+	txn, err := cancelSwap(b, swap)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r, err := j.Cancel(swap, txn.ID())
+	if err != nil {
+		log.Println("Warning: failed to record swap in journal:", err)
+	}
+	fmt.Println("  Broadcast cancellation transaction", txn.ID())
+	if r.ID != "" {
+		fmt.Println("  Swap:", r.ID)
+	}
+	fmt.Println()
+	fmt.Println("Waiting for the cancellation to confirm...")
+	deadline := time.Now().Add(cancelWait)
+	for {
+		pt, err := b.Transaction(txn.ID())
+		if err != nil {
+			log.Fatal("  Cancellation transaction is no longer in the transaction pool; the swap may still be completed. Run embc cancel again to retry.")
+		} else if pt.ConfirmationHeight != math.MaxUint64 {
+			break
+		}
+		if sum, err := summarize(b, swap); err == nil && sum.Status == swapTransactionConfirmed {
+			log.Fatal("  Swap transaction confirmed before the cancellation; the swap has been completed.")
+		}
+		if time.Now().After(deadline) {
+			log.Fatalf("  Cancellation not confirmed after %v; it may still confirm. Check its status with embc list.", cancelWait)
+		}
+		time.Sleep(10 * time.Second)
+	}
+	fmt.Println("  Cancellation confirmed; the swap can no longer be completed.")
+}
+
+func keyCLI(key x25519Key) {
+	fmt.Println(key.PublicKey())
+}
+
+func listCLI(b Backend, j *journal) {
+	if err := j.Refresh(b); err != nil {
+		log.Println("Warning: failed to refresh swap journal:", err)
+	}
+	records := j.Swaps()
+	if len(records) == 0 {
+		fmt.Println("No swaps recorded.")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tStage\tCreator sends\tAcceptor sends\tUpdated\tLabel")
+	for _, r := range records {
+		t := r.Swap.terms()
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", r.ID, r.Stage, t.Creator, t.Acceptor, r.Updated().Format(time.RFC822), r.Label)
+	}
+	tw.Flush()
+}
+
+func showCLI(b Backend, j *journal, id string) {
+	if err := j.Refresh(b); err != nil {
+		log.Println("Warning: failed to refresh swap journal:", err)
+	}
+	r, err := j.Swap(id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Swap:")
+	fmt.Println("  ID:         ", r.ID)
+	fmt.Println("  Transaction:", r.TxnID)
+	fmt.Println("  Stage:      ", r.Stage)
+	if r.Label != "" {
+		fmt.Println("  Label:      ", r.Label)
+	}
+	if r.Notes != "" {
+		fmt.Println("  Notes:      ", r.Notes)
+	}
+	fmt.Println()
+	fmt.Println("History:")
+	for _, e := range r.History {
+		fmt.Printf("  %-10v %v  %v\n", e.Stage, e.Timestamp.Format(time.RFC822), e.TxnID)
+	}
+	fmt.Println()
+	if sum, err := summarize(b, r.Swap); err == nil {
+		printSummary(sum)
+	}
+}
+
+func encodeBatchFile(batch BatchSwap) (string, error) {
+	f, err := os.Create(fmt.Sprintf("embc_batch_%v.json", batch.ID[:8]))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := encodeJSON(f, batch); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func decodeBatchFile(filePath string) (batch BatchSwap, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return BatchSwap{}, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&batch)
+	return
+}
+
+func printBatch(w Wallet, batch BatchSwap) error {
+	filePath, err := encodeBatchFile(batch)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Batch swap:")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  Participant\tSends\tReceives\tJoined")
+	amounts := func(sc, sf types.Currency) string {
+		var s []string
+		if !sc.IsZero() {
+			s = append(s, sc.HumanString())
+		}
+		if !sf.IsZero() {
+			s = append(s, sf.String()+" SF")
+		}
+		return strings.Join(s, " + ")
+	}
+	for _, p := range batch.Participants {
+		fmt.Fprintf(tw, "  %v\t%v\t%v\t%v\n", p.Name, amounts(p.SendSC, p.SendSF), amounts(p.ReceiveSC, p.ReceiveSF), p.Joined)
+	}
+	tw.Flush()
+	fmt.Println()
+	fmt.Println("  Miner fee:  ", batch.minerFee().HumanString())
+	fmt.Println("  Signatures: ", len(batch.Signatures))
+	fmt.Println("  Status:     ", statusToDescription[batchStatus(w, batch)])
+	fmt.Println("  File:       ", filePath)
+	fmt.Println()
+	return nil
+}
+
+func batchCreateCLI(b Backend, terms []string) {
+	var participants []BatchParticipant
+	for _, t := range terms {
+		p, err := parseBatchTerms(t)
+		if err != nil {
+			log.Fatal(err)
+		}
+		participants = append(participants, p)
+	}
+	batch, err := createBatch(participants)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("  Batch created!")
+	fmt.Println()
+	printBatch(b, batch)
+	fmt.Println("To proceed, send each participant the batch file in turn and ask them to run:")
+	fmt.Println()
+	fmt.Println("  embc batch join [name] [file_path]")
+	fmt.Println()
+}
+
+func batchJoinCLI(b Backend, j *journal, name, filePath, strategy, dust string, allowUnconfirmed bool, to string) {
+	batch, err := decodeBatchFile(filePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts, err := parseFundingOptions(strategy, dust, allowUnconfirmed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.destinations, err = parseDestinations(to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := reserveBatchJoin(b, j, &batch, name, opts); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("  Joined batch as", name)
+	fmt.Println()
+	printBatch(b, batch)
+	if !batch.joined() {
+		fmt.Println("To proceed, send the batch file to the next participant to join.")
+	} else {
+		fmt.Println("Every participant has joined. To proceed, send each participant the batch file and ask them to run:")
+		fmt.Println()
+		fmt.Println("  embc batch sign [name] [file_path]")
+	}
+	fmt.Println()
+}
+
+func batchSignCLI(b Backend, j *journal, name, filePath string) {
+	batch, err := decodeBatchFile(filePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkBatch(b, batch, name); err != nil {
+		log.Fatal(err)
+	}
+	printBatch(b, batch)
+	fmt.Printf("Sign this batch as %v? [y/n]: ", name)
+	var resp string
+	fmt.Scanln(&resp)
+	fmt.Println()
+	if !strings.EqualFold(resp, "y") {
+		log.Fatal("  Batch not signed.")
+	} else if err := finishBatch(b, &batch, name); err != nil {
+		log.Fatal(err)
+	}
+	if batchFullySigned(batch) {
+		recordSwap(j, batch.journalSwap(name), stageBroadcast)
+		fmt.Println("  Successfully broadcast batch transaction!")
+		fmt.Println()
+		printBatch(b, batch)
+		return
+	}
+	recordSwap(j, batch.journalSwap(name), stageFinished)
+	fmt.Println("  Batch signed!")
+	fmt.Println()
+	printBatch(b, batch)
+	fmt.Println("To proceed, pass the batch file to the next participant to sign, or collect")
+	fmt.Println("each participant's signed file and combine them with:")
+	fmt.Println()
+	fmt.Println("  embc batch merge [file_path...]")
+	fmt.Println()
+}
+
+func batchMergeCLI(b Backend, j *journal, filePaths []string) {
+	var batches []BatchSwap
+	for _, filePath := range filePaths {
+		batch, err := decodeBatchFile(filePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		batches = append(batches, batch)
+	}
+	batch, err := mergeBatches(batches...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if batchFullySigned(batch) {
+		if err := b.BroadcastTransaction(batch.transaction()); err != nil {
+			log.Fatal(err)
+		}
+		recordSwap(j, batch.journalSwap(batch.owner(b)), stageBroadcast)
+		fmt.Println("  Successfully broadcast batch transaction!")
+		fmt.Println()
+	}
+	printBatch(b, batch)
+}
+
+func whitelistCLI(wl *whitelist, addrStrs []string, remove bool) {
+	var addrs []types.UnlockHash
+	for _, s := range addrStrs {
+		var addr types.UnlockHash
+		if err := addr.LoadString(s); err != nil {
+			log.Fatal("Invalid address: ", err)
+		}
+		addrs = append(addrs, addr)
+	}
+	if remove {
+		if err := wl.Remove(addrs...); err != nil {
+			log.Fatal("Failed to update whitelist: ", err)
+		}
+	} else if len(addrs) > 0 {
+		if err := wl.Add(addrs...); err != nil {
+			log.Fatal("Failed to update whitelist: ", err)
+		}
+	}
+	whitelisted := wl.Addresses()
+	if len(whitelisted) == 0 {
+		fmt.Println("No addresses whitelisted.")
+		return
+	}
+	fmt.Println("Whitelisted addresses:")
+	for _, addr := range whitelisted {
+		fmt.Println("  " + addr.String())
+	}
+}
+
+func encodeSigningRequestFile(req SigningRequest) (string, error) {
+	txnID := req.Swap.transaction().ID()
+	f, err := os.Create(fmt.Sprintf("embc_sign_%x.json", txnID[:4]))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := encodeJSON(f, req); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func decodeSigningRequestFile(filePath string) (req SigningRequest, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return SigningRequest{}, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&req)
+	return
+}
+
+func encodeSignaturesFile(req SigningRequest, sigs []types.TransactionSignature) (string, error) {
+	txnID := req.Swap.transaction().ID()
+	f, err := os.Create(fmt.Sprintf("embc_signatures_%x.json", txnID[:4]))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := encodeJSON(f, sigs); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func decodeSignaturesFile(filePath string) (sigs []types.TransactionSignature, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&sigs)
+	return
+}
+
+// printSigningRequest exports a signing request for the blank signatures of
+// the file's swap and explains how to complete it.
+func printSigningRequest(c Chain, f SwapFile, stage string) {
+	swap := f.Swap
+	req, err := newSigningRequest(c, swap, stage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.File = &f
+	filePath, err := encodeSigningRequestFile(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Signing request:")
+	fmt.Println("  Transaction: ", swap.transaction().ID())
+	fmt.Println("  Inputs:      ", len(req.Inputs))
+	fmt.Println("  File:        ", filePath)
+	fmt.Println()
+	fmt.Println("To proceed, copy the signing request to your offline machine and run:")
+	fmt.Println()
+	fmt.Println("  embc sign", filePath)
+	fmt.Println()
+	fmt.Println("Then copy the resulting signatures file back to this machine and run:")
+	fmt.Println()
+	fmt.Println("  embc import", filePath, "[signatures_file]")
+	fmt.Println()
+}
+
+// readSeed reads a wallet seed from seedFile or, if it is empty, prompts for
+// it.
+func readSeed(seedFile string) modules.Seed {
+	var phrase string
+	if seedFile != "" {
+		b, err := os.ReadFile(seedFile)
+		if err != nil {
+			log.Fatal("Failed to read seed file: ", err)
+		}
+		phrase = string(b)
+	} else {
+		fmt.Printf("Seed: ")
+		var err error
+		phrase, err = bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			log.Fatal("Failed to read seed: ", err)
+		}
+		fmt.Println()
+	}
+	seed, err := parseSeed(strings.TrimSpace(phrase))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return seed
+}
+
+func signCLI(filePath, seedFile string) {
+	req, err := decodeSigningRequestFile(filePath)
+	if err != nil {
+		log.Fatal(err)
+	} else if err := checkSigningRequest(req); err != nil {
+		log.Fatal(err)
+	}
+	txn := req.Swap.transaction()
+	fmt.Println("Signing request:")
+	fmt.Println("  Transaction: ", txn.ID())
+	fmt.Println("  Inputs:      ", len(req.Inputs))
+	fmt.Println("  Miner fee:   ", txn.MinerFees[0].HumanString())
+	fmt.Println()
+	fmt.Println("Outputs:")
+	for _, sco := range txn.SiacoinOutputs {
+		fmt.Printf("  %v  %v\n", sco.UnlockHash, sco.Value.HumanString())
+	}
+	for _, sfo := range txn.SiafundOutputs {
+		fmt.Printf("  %v  %v SF\n", sfo.UnlockHash, sfo.Value)
+	}
+	fmt.Println()
+	fmt.Printf("Sign this transaction? [y/n]: ")
+	var resp string
+	fmt.Scanln(&resp)
+	fmt.Println()
+	if !strings.EqualFold(resp, "y") {
+		log.Fatal("  Signing cancelled.")
+	}
+
+	sigs, err := signRequest(req, readSeed(seedFile))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sigsPath, err := encodeSignaturesFile(req, sigs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("  Signed", len(sigs), "inputs.")
+	fmt.Println("  File: ", sigsPath)
+	fmt.Println()
+	fmt.Println("To proceed, copy the signatures file back to the online machine and run:")
+	fmt.Println()
+	fmt.Println("  embc import", filePath, sigsPath)
+	fmt.Println()
+}
+
+func importCLI(b Backend, j *journal, reqPath, sigsPath string, qr bool) {
+	req, err := decodeSigningRequestFile(reqPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sigs, err := decodeSignaturesFile(sigsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	swap, err := applySignatures(req, sigs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// restore the swap file the request was exported from; requests
+	// exported by older versions do not carry one, so start a new one
+	var f SwapFile
+	if req.File != nil {
+		f = req.File.withSwap(swap)
+	} else if f, err = newSwapFile(b, swap, defaultSwapExpiry, ""); err != nil {
+		log.Fatal(err)
+	}
+	if req.Stage != stageBroadcast {
+		r := recordSwap(j, swap, req.Stage)
+		recordExpiry(j, r.ID, f)
+		fmt.Println("  Signatures imported!")
+		fmt.Println()
+		printTransaction(b, f, r.ID, outputOptions{qr: qr})
+		return
+	}
+	if err := checkFinish(b, swap, false); err != nil {
+		log.Fatal(err)
+	} else if err := b.BroadcastTransaction(swap.transaction()); err != nil {
+		recordSwap(j, swap, stageFinished)
 		log.Fatal(err)
 	}
+	r := recordSwap(j, swap, stageBroadcast)
 	fmt.Println("  Successfully broadcast swap transaction!")
 	fmt.Println()
-	printTransaction(swap)
+	printTransaction(b, f, r.ID, outputOptions{qr: qr})
 }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+)
+
+// Coin selection strategies.
+const (
+	selectFirst    = "first"
+	selectLargest  = "largest"
+	selectSmallest = "smallest"
+	selectExact    = "exact"
+	selectPrivacy  = "privacy"
+)
+
+// maxExactMatchTries bounds the branch-and-bound search of the exact-match
+// strategy, so that wallets with many outputs do not search indefinitely.
+const maxExactMatchTries = 100000
+
+// maxDustThreshold is the largest dust threshold that may be configured.
+// Counterparties reject swaps that add more than this to the miner fee as
+// change, so that neither party can pass off part of the swap as dust.
+var maxDustThreshold = types.SiacoinPrecision
+
+var errInsufficientFunds = errors.New("insufficient funds")
+
+// A CoinSelector chooses which outputs to spend to fund an amount. The outputs
+// are all of the same type, and the selected outputs must sum to at least
+// amount. The selector may use tolerance, the amount of change that the caller
+// is willing to give up rather than create a change output, to avoid change.
+type CoinSelector interface {
+	SelectCoins(outputs []modules.UnspentOutput, amount, tolerance types.Currency) ([]modules.UnspentOutput, error)
+}
+
+// coinSelectors maps the name of each built-in strategy to its CoinSelector.
+var coinSelectors = map[string]CoinSelector{
+	selectFirst:    firstSelector{},
+	selectLargest:  largestFirstSelector{},
+	selectSmallest: smallestFirstSelector{},
+	selectExact:    exactMatchSelector{},
+	selectPrivacy:  privacySelector{},
+}
+
+// sumOutputs returns the total value of a set of outputs.
+func sumOutputs(outputs []modules.UnspentOutput) (sum types.Currency) {
+	for _, o := range outputs {
+		sum = sum.Add(o.Value)
+	}
+	return
+}
+
+// selectInOrder selects outputs in the order given until amount is covered.
+func selectInOrder(outputs []modules.UnspentOutput, amount types.Currency) ([]modules.UnspentOutput, error) {
+	var selected []modules.UnspentOutput
+	var sum types.Currency
+	for _, o := range outputs {
+		if sum.Cmp(amount) >= 0 {
+			break
+		}
+		selected = append(selected, o)
+		sum = sum.Add(o.Value)
+	}
+	if sum.Cmp(amount) < 0 {
+		return nil, errInsufficientFunds
+	}
+	return selected, nil
+}
+
+// sortOutputs returns a copy of outputs sorted by value, largest first.
+func sortOutputs(outputs []modules.UnspentOutput) []modules.UnspentOutput {
+	sorted := append([]modules.UnspentOutput(nil), outputs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Value.Cmp(sorted[j].Value) > 0
+	})
+	return sorted
+}
+
+// firstSelector selects outputs in the order that the wallet returns them.
+type firstSelector struct{}
+
+// SelectCoins implements CoinSelector.
+func (firstSelector) SelectCoins(outputs []modules.UnspentOutput, amount, _ types.Currency) ([]modules.UnspentOutput, error) {
+	return selectInOrder(outputs, amount)
+}
+
+// largestFirstSelector selects the largest outputs first, minimizing the
+// number of inputs.
+type largestFirstSelector struct{}
+
+// SelectCoins implements CoinSelector.
+func (largestFirstSelector) SelectCoins(outputs []modules.UnspentOutput, amount, _ types.Currency) ([]modules.UnspentOutput, error) {
+	return selectInOrder(sortOutputs(outputs), amount)
+}
+
+// smallestFirstSelector selects the smallest outputs first, consolidating
+// small outputs at the cost of a larger transaction.
+type smallestFirstSelector struct{}
+
+// SelectCoins implements CoinSelector.
+func (smallestFirstSelector) SelectCoins(outputs []modules.UnspentOutput, amount, _ types.Currency) ([]modules.UnspentOutput, error) {
+	sorted := sortOutputs(outputs)
+	for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	}
+	return selectInOrder(sorted, amount)
+}
+
+// exactMatchSelector uses a branch-and-bound search to find a set of outputs
+// that covers amount with no more than tolerance left over, so that no change
+// output is needed. If there is no such set, it falls back to selecting the
+// largest outputs first.
+type exactMatchSelector struct{}
+
+// SelectCoins implements CoinSelector.
+func (exactMatchSelector) SelectCoins(outputs []modules.UnspentOutput, amount, tolerance types.Currency) ([]modules.UnspentOutput, error) {
+	sorted := sortOutputs(outputs)
+	// remaining[i] is the sum of sorted[i:], used to prune branches that can
+	// no longer reach the target
+	remaining := make([]types.Currency, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1].Add(sorted[i].Value)
+	}
+	if remaining[0].Cmp(amount) < 0 {
+		return nil, errInsufficientFunds
+	}
+	upper := amount.Add(tolerance)
+
+	var tries int
+	var selected []int
+	var search func(i int, sum types.Currency) bool
+	search = func(i int, sum types.Currency) bool {
+		tries++
+		if sum.Cmp(upper) > 0 || tries > maxExactMatchTries {
+			return false
+		} else if sum.Cmp(amount) >= 0 {
+			return true
+		} else if i == len(sorted) || sum.Add(remaining[i]).Cmp(amount) < 0 {
+			return false
+		}
+		// try including the output, then excluding it
+		selected = append(selected, i)
+		if search(i+1, sum.Add(sorted[i].Value)) {
+			return true
+		}
+		selected = selected[:len(selected)-1]
+		return search(i+1, sum)
+	}
+	if !search(0, types.ZeroCurrency) {
+		return selectInOrder(sorted, amount)
+	}
+	match := make([]modules.UnspentOutput, len(selected))
+	for i, j := range selected {
+		match[i] = sorted[j]
+	}
+	return match, nil
+}
+
+// privacySelector avoids merging outputs from many addresses, which would
+// publicly link those addresses together. It prefers spending from a single
+// address, choosing the one with the smallest balance that covers amount;
+// otherwise it spends from as few addresses as possible, largest first.
+type privacySelector struct{}
+
+// SelectCoins implements CoinSelector.
+func (privacySelector) SelectCoins(outputs []modules.UnspentOutput, amount, _ types.Currency) ([]modules.UnspentOutput, error) {
+	var addrs []types.UnlockHash
+	byAddr := make(map[types.UnlockHash][]modules.UnspentOutput)
+	for _, o := range outputs {
+		if _, ok := byAddr[o.UnlockHash]; !ok {
+			addrs = append(addrs, o.UnlockHash)
+		}
+		byAddr[o.UnlockHash] = append(byAddr[o.UnlockHash], o)
+	}
+	totals := make(map[types.UnlockHash]types.Currency)
+	for addr, outputs := range byAddr {
+		totals[addr] = sumOutputs(outputs)
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return totals[addrs[i]].Cmp(totals[addrs[j]]) > 0
+	})
+
+	// spend from the smallest single address that suffices
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if totals[addrs[i]].Cmp(amount) >= 0 {
+			return selectInOrder(sortOutputs(byAddr[addrs[i]]), amount)
+		}
+	}
+	// otherwise, spend entire addresses, largest first
+	var selected []modules.UnspentOutput
+	for _, addr := range addrs {
+		selected = append(selected, byAddr[addr]...)
+		if sumOutputs(selected).Cmp(amount) >= 0 {
+			return selected, nil
+		}
+	}
+	return nil, errInsufficientFunds
+}
+
+// fundingOptions control how a swap is funded from the wallet, and where
+// its proceeds are paid.
+type fundingOptions struct {
+	// selector chooses which outputs to spend. If nil, outputs are spent in
+	// the order that the wallet returns them.
+	selector CoinSelector
+	// dustThreshold is the value below which siacoin change is added to the
+	// miner fee instead of being returned in a change output.
+	dustThreshold types.Currency
+	// allowUnconfirmed permits spending outputs that are not yet confirmed,
+	// e.g. the change of a previous swap, chaining the swap off of the
+	// transaction that created them.
+	allowUnconfirmed bool
+	// destinations are the addresses that receive the swap's proceeds. Any
+	// proceeds not assigned to a destination are paid to a new wallet
+	// address.
+	destinations []Destination
+}
+
+// selectCoins selects outputs to fund amount using the configured strategy.
+func (opts fundingOptions) selectCoins(outputs []modules.UnspentOutput, amount, tolerance types.Currency) ([]modules.UnspentOutput, error) {
+	selector := opts.selector
+	if selector == nil {
+		selector = firstSelector{}
+	}
+	return selector.SelectCoins(outputs, amount, tolerance)
+}
+
+// parseFundingOptions parses the name of a coin selection strategy and a
+// suffixed Siacoin dust threshold. Empty values select the defaults.
+func parseFundingOptions(strategy, dust string, allowUnconfirmed bool) (fundingOptions, error) {
+	opts := fundingOptions{allowUnconfirmed: allowUnconfirmed}
+	if strategy != "" {
+		selector, ok := coinSelectors[strings.ToLower(strategy)]
+		if !ok {
+			return fundingOptions{}, fmt.Errorf("unknown coin selection strategy %q", strategy)
+		}
+		opts.selector = selector
+	}
+	if dust != "" {
+		threshold, err := parseCurrency(dust)
+		if err != nil {
+			return fundingOptions{}, fmt.Errorf("invalid dust threshold: %w", err)
+		} else if threshold.Cmp(maxDustThreshold) > 0 {
+			return fundingOptions{}, fmt.Errorf("dust threshold may not exceed %v", maxDustThreshold.HumanString())
+		}
+		opts.dustThreshold = threshold
+	}
+	return opts, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+)
+
+func TestCoinSelectors(t *testing.T) {
+	addrA, addrB, addrC := types.UnlockHash{1}, types.UnlockHash{2}, types.UnlockHash{3}
+	output := func(id byte, addr types.UnlockHash, sc uint64) modules.UnspentOutput {
+		return modules.UnspentOutput{
+			ID:         types.OutputID{id},
+			FundType:   types.SpecifierSiacoinOutput,
+			UnlockHash: addr,
+			Value:      types.SiacoinPrecision.Mul64(sc),
+		}
+	}
+	outputs := []modules.UnspentOutput{
+		output(1, addrA, 30),
+		output(2, addrB, 5),
+		output(3, addrB, 50),
+		output(4, addrC, 12),
+		output(5, addrA, 8),
+	}
+
+	tests := []struct {
+		strategy  string
+		amount    uint64
+		tolerance uint64
+		want      []byte
+	}{
+		{selectFirst, 40, 0, []byte{1, 2, 3}},
+		{selectLargest, 40, 0, []byte{3}},
+		{selectLargest, 90, 0, []byte{3, 1, 4}},
+		{selectSmallest, 20, 0, []byte{2, 5, 4}},
+		{selectExact, 42, 0, []byte{1, 4}},
+		{selectExact, 41, 1, []byte{1, 4}},
+		{selectExact, 40, 0, []byte{3}}, // no exact match; falls back to largest
+		{selectPrivacy, 20, 0, []byte{1}},
+		{selectPrivacy, 50, 0, []byte{3}},
+		{selectPrivacy, 70, 0, []byte{2, 3, 1, 5}},
+	}
+	for _, test := range tests {
+		amount := types.SiacoinPrecision.Mul64(test.amount)
+		tolerance := types.SiacoinPrecision.Mul64(test.tolerance)
+		selected, err := coinSelectors[test.strategy].SelectCoins(outputs, amount, tolerance)
+		if err != nil {
+			t.Fatalf("%v (%v SC): %v", test.strategy, test.amount, err)
+		}
+		var ids []byte
+		for _, o := range selected {
+			ids = append(ids, o.ID[0])
+		}
+		if string(ids) != string(test.want) {
+			t.Errorf("%v (%v SC): expected outputs %v, got %v", test.strategy, test.amount, test.want, ids)
+		}
+	}
+
+	for strategy, selector := range coinSelectors {
+		if _, err := selector.SelectCoins(outputs, types.SiacoinPrecision.Mul64(106), types.ZeroCurrency); !errors.Is(err, errInsufficientFunds) {
+			t.Errorf("%v: expected %v, got %v", strategy, errInsufficientFunds, err)
+		}
+	}
+}
+
+func TestDustThreshold(t *testing.T) {
+	c := newMemChain()
+	alice := newMemWallet(c, types.SiacoinPrecision.Mul64(1055).Div64(10), types.ZeroCurrency)
+	bob := newMemWallet(c, types.ZeroCurrency, types.NewCurrency64(10))
+	scAmount := types.SiacoinPrecision.Mul64(100)
+	sfAmount := types.NewCurrency64(2)
+
+	// thresholds above the maximum are rejected
+	if _, err := parseFundingOptions(selectLargest, "1KS", false); err == nil {
+		t.Fatal("expected excessive dust threshold to be rejected")
+	}
+
+	// alice's only output leaves 0.5 SC of change, which is kept unless the
+	// threshold exceeds it
+	opts, err := parseFundingOptions(selectLargest, "1SC", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swap, err := createSwap(alice, Basket{SC: scAmount}, Basket{SF: sfAmount}, testMinerFee, feePayerSC, opts)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(swap.SiacoinOutputs) != 1 {
+		t.Fatal("expected change below the dust threshold to be dropped")
+	} else if change := types.SiacoinPrecision.Div64(2); !swap.ChangeFee.Equals(change) {
+		t.Fatalf("expected %v of change to be added to the fee, got %v", change.HumanString(), swap.ChangeFee.HumanString())
+	}
+	s, err := summarize(alice, swap)
+	if err != nil {
+		t.Fatal(err)
+	} else if !s.SCPartyFee.Equals(testMinerFee.Add(swap.ChangeFee)) || !s.SFPartyFee.IsZero() {
+		t.Fatalf("wrong fee shares: SC party pays %v, SF party pays %v", s.SCPartyFee.HumanString(), s.SFPartyFee.HumanString())
+	}
+
+	// a swap passing off more than dust as change is rejected
+	inflated := swap
+	inflated.ChangeFee = maxDustThreshold
+	if err := checkAccept(inflated); err == nil {
+		t.Fatal("expected excessive change fee to be rejected")
+	}
+
+	if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(alice, &swap); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	if sc, _ := alice.balance(); !sc.IsZero() {
+		t.Fatalf("expected alice's change to go to the miner, has %v", sc.HumanString())
+	} else if sc, _ := bob.balance(); !sc.Equals(scAmount) {
+		t.Fatalf("bob has wrong balance after swap: %v", sc.HumanString())
+	}
+
+	if _, err := parseFundingOptions("random", "", false); err == nil {
+		t.Fatal("expected unknown strategy to be rejected")
+	}
+}
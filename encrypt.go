@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/NebulousLabs/encoding"
+	"go.sia.tech/siad/crypto"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Swap files can be encrypted for a known counterparty, so that the
+// addresses, outputs, and amounts of a swap are not exposed to whatever
+// channel the file is sent through. A file is encrypted either with a
+// passphrase shared with the counterparty, or with the counterparty's X25519
+// public key, which they can print with 'embc key'.
+
+// Encryption schemes.
+const (
+	schemePassphrase = "passphrase"
+	schemeX25519     = "x25519"
+	schemeSPAKE2     = "spake2"
+)
+
+// errDecrypt is returned when a swap file cannot be decrypted with a key.
+var errDecrypt = errors.New("failed to decrypt swap file")
+
+// x25519Prefix begins the string encoding of an X25519 public key.
+const x25519Prefix = "x25519:"
+
+// encryptedTextPrefix begins the text encoding of an encrypted swap file.
+const encryptedTextPrefix = "embcx:"
+
+// An EncryptedSwapFile is a SwapFile encrypted with XChaCha20-Poly1305. With
+// the passphrase scheme, the key is derived from the passphrase and Salt with
+// Argon2id. With the x25519 scheme, the key is derived from an X25519
+// exchange between EphemeralKey and the recipient's public key.
+type EncryptedSwapFile struct {
+	Scheme       string `json:"scheme"`
+	Salt         []byte `json:"salt,omitempty"`
+	EphemeralKey []byte `json:"ephemeralKey,omitempty"`
+	Nonce        []byte `json:"nonce"`
+	Ciphertext   []byte `json:"ciphertext"`
+}
+
+// EncryptionOptions select how a swap file is encrypted: with Passphrase, or
+// for Recipient, an X25519 public key. If neither is set, the file is not
+// encrypted.
+type EncryptionOptions struct {
+	Passphrase string `json:"passphrase"`
+	Recipient  string `json:"recipient"`
+}
+
+// An x25519Key is an X25519 private key.
+type x25519Key [32]byte
+
+// PublicKey returns the string encoding of the key's public key.
+func (k x25519Key) PublicKey() string {
+	pk, _ := curve25519.X25519(k[:], curve25519.Basepoint)
+	return x25519Prefix + hex.EncodeToString(pk)
+}
+
+// generateX25519Key returns a new random X25519 private key.
+func generateX25519Key() (k x25519Key) {
+	if _, err := rand.Read(k[:]); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// loadX25519Key loads the X25519 private key stored at path, generating it if
+// it does not exist.
+func loadX25519Key(path string) (x25519Key, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return x25519Key{}, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		k := generateX25519Key()
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(k[:])+"\n"), 0600); err != nil {
+			return x25519Key{}, err
+		}
+		return k, nil
+	} else if err != nil {
+		return x25519Key{}, err
+	}
+	var k x25519Key
+	if n, err := hex.Decode(k[:], []byte(strings.TrimSpace(string(b)))); err != nil || n != len(k) {
+		return x25519Key{}, errors.New("invalid X25519 key file")
+	}
+	return k, nil
+}
+
+// parseX25519PublicKey parses the string encoding of an X25519 public key.
+func parseX25519PublicKey(s string) ([]byte, error) {
+	if !strings.HasPrefix(s, x25519Prefix) {
+		return nil, fmt.Errorf("public key must begin with %q", x25519Prefix)
+	}
+	pk, err := hex.DecodeString(strings.TrimPrefix(s, x25519Prefix))
+	if err != nil || len(pk) != 32 {
+		return nil, errors.New("invalid X25519 public key")
+	}
+	return pk, nil
+}
+
+// passphraseKey derives an encryption key from a passphrase.
+func passphraseKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, chacha20poly1305.KeySize)
+}
+
+// sharedKey derives an encryption key from an X25519 shared secret and the
+// public keys that produced it.
+func sharedKey(shared, ephemeral, recipient []byte) []byte {
+	h := crypto.HashAll(shared, ephemeral, recipient)
+	return h[:]
+}
+
+// encrypted reports whether opts select encryption.
+func (opts EncryptionOptions) encrypted() bool {
+	return opts.Passphrase != "" || opts.Recipient != ""
+}
+
+// check checks that opts can be used to encrypt a swap file, so that a bad
+// public key is caught before a swap is funded.
+func (opts EncryptionOptions) check() error {
+	if opts.Passphrase != "" && opts.Recipient != "" {
+		return errors.New("cannot encrypt with both a passphrase and a public key")
+	} else if opts.Recipient != "" {
+		if _, err := parseX25519PublicKey(opts.Recipient); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptSwapFile encrypts f as selected by opts.
+func encryptSwapFile(f SwapFile, opts EncryptionOptions) (EncryptedSwapFile, error) {
+	if err := opts.check(); err != nil {
+		return EncryptedSwapFile{}, err
+	}
+	var ef EncryptedSwapFile
+	var key []byte
+	switch {
+	case opts.Passphrase != "":
+		ef.Scheme = schemePassphrase
+		ef.Salt = make([]byte, 16)
+		if _, err := rand.Read(ef.Salt); err != nil {
+			return EncryptedSwapFile{}, err
+		}
+		key = passphraseKey(opts.Passphrase, ef.Salt)
+	case opts.Recipient != "":
+		recipient, err := parseX25519PublicKey(opts.Recipient)
+		if err != nil {
+			return EncryptedSwapFile{}, err
+		}
+		ephemeral := generateX25519Key()
+		ef.Scheme = schemeX25519
+		ef.EphemeralKey, _ = curve25519.X25519(ephemeral[:], curve25519.Basepoint)
+		shared, err := curve25519.X25519(ephemeral[:], recipient)
+		if err != nil {
+			return EncryptedSwapFile{}, fmt.Errorf("invalid X25519 public key: %w", err)
+		}
+		key = sharedKey(shared, ef.EphemeralKey, recipient)
+	default:
+		return EncryptedSwapFile{}, errors.New("no passphrase or public key to encrypt with")
+	}
+	return ef, sealSwapFileWithKey(&ef, key, f, "")
+}
+
+// associatedData returns the data authenticated alongside an encrypted swap
+// file: its scheme and, for a paired session, the stage it was sent at.
+func associatedData(scheme, stage string) []byte {
+	if stage == "" {
+		return []byte(scheme)
+	}
+	return []byte(scheme + "/" + stage)
+}
+
+// sealSwapFileWithKey encrypts f into ef with key, authenticating ef's
+// scheme and stage, if any.
+func sealSwapFileWithKey(ef *EncryptedSwapFile, key []byte, f SwapFile, stage string) error {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return err
+	}
+	ef.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(ef.Nonce); err != nil {
+		return err
+	}
+	ef.Ciphertext = aead.Seal(nil, ef.Nonce, marshalSwapFile(f), associatedData(ef.Scheme, stage))
+	return nil
+}
+
+// openSwapFileWithKey decrypts ef with key, checking that it was sealed for
+// stage.
+func openSwapFileWithKey(ef EncryptedSwapFile, key []byte, stage string) (SwapFile, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return SwapFile{}, err
+	} else if len(ef.Nonce) != aead.NonceSize() {
+		return SwapFile{}, errors.New("invalid nonce")
+	}
+	b, err := aead.Open(nil, ef.Nonce, ef.Ciphertext, associatedData(ef.Scheme, stage))
+	if err != nil {
+		return SwapFile{}, errDecrypt
+	}
+	return unmarshalSwapFile(b)
+}
+
+// decryptSwapFile decrypts ef with passphrase or key, according to its
+// scheme. passphrase is only called if ef was encrypted with a passphrase.
+func decryptSwapFile(ef EncryptedSwapFile, passphrase func() (string, error), key x25519Key) (SwapFile, error) {
+	var k []byte
+	switch ef.Scheme {
+	case schemePassphrase:
+		p, err := passphrase()
+		if err != nil {
+			return SwapFile{}, err
+		}
+		k = passphraseKey(p, ef.Salt)
+	case schemeX25519:
+		shared, err := curve25519.X25519(key[:], ef.EphemeralKey)
+		if err != nil {
+			return SwapFile{}, fmt.Errorf("invalid ephemeral key: %w", err)
+		}
+		pk, _ := curve25519.X25519(key[:], curve25519.Basepoint)
+		k = sharedKey(shared, ef.EphemeralKey, pk)
+	case schemeSPAKE2:
+		return SwapFile{}, errors.New("swap file is encrypted for a paired session; it can only be read through the relay")
+	default:
+		return SwapFile{}, fmt.Errorf("unknown encryption scheme %q", ef.Scheme)
+	}
+	f, err := openSwapFileWithKey(ef, k, "")
+	if errors.Is(err, errDecrypt) && ef.Scheme == schemePassphrase {
+		return SwapFile{}, errors.New("failed to decrypt swap file: wrong passphrase")
+	} else if errors.Is(err, errDecrypt) {
+		return SwapFile{}, errors.New("failed to decrypt swap file: it was not encrypted for this key")
+	}
+	return f, err
+}
+
+// encodeEncryptedText returns the text encoding of ef.
+func encodeEncryptedText(ef EncryptedSwapFile) string {
+	b := encoding.MarshalAll(ef.Scheme, ef.Salt, ef.EphemeralKey, ef.Nonce, ef.Ciphertext)
+	return encryptedTextPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeEncryptedText decodes an encrypted swap file encoded with
+// encodeEncryptedText.
+func decodeEncryptedText(s string) (EncryptedSwapFile, error) {
+	if !strings.HasPrefix(s, encryptedTextPrefix) {
+		return EncryptedSwapFile{}, fmt.Errorf("encrypted swap text must begin with %q", encryptedTextPrefix)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(s, encryptedTextPrefix)))
+	if err != nil {
+		return EncryptedSwapFile{}, fmt.Errorf("failed to decode encrypted swap text: %w", err)
+	}
+	var ef EncryptedSwapFile
+	if err := encoding.UnmarshalAll(b, &ef.Scheme, &ef.Salt, &ef.EphemeralKey, &ef.Nonce, &ef.Ciphertext); err != nil {
+		return EncryptedSwapFile{}, fmt.Errorf("failed to decode encrypted swap text: %w", err)
+	}
+	return ef, nil
+}
+
+// parseEncryptedSwapFile decodes js as an EncryptedSwapFile, rejecting
+// unknown fields. If js does not hold an encrypted swap file, ok is false.
+func parseEncryptedSwapFile(js []byte) (ef EncryptedSwapFile, ok bool, err error) {
+	var probe struct {
+		Ciphertext json.RawMessage `json:"ciphertext"`
+	}
+	if err := json.Unmarshal(js, &probe); err != nil {
+		return EncryptedSwapFile{}, false, fmt.Errorf("failed to decode swap file: %w", err)
+	} else if probe.Ciphertext == nil {
+		return EncryptedSwapFile{}, false, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ef); err != nil {
+		return EncryptedSwapFile{}, true, fmt.Errorf("failed to decode encrypted swap file: %w", err)
+	}
+	return ef, true, nil
+}
+
+// sealSwapFile returns the envelope in which sf is passed to the counterparty,
+// encrypted as selected by enc, along with its text encoding.
+func sealSwapFile(sf SwapFile, enc EncryptionOptions) (interface{}, string, error) {
+	if !enc.encrypted() {
+		return sf, encodeSwapText(sf), nil
+	}
+	ef, err := encryptSwapFile(sf, enc)
+	if err != nil {
+		return nil, "", err
+	}
+	return ef, encodeEncryptedText(ef), nil
+}
+
+// decodeSwapEnvelope decodes a JSON swap file, encrypted or not, decrypting it
+// with key or with passphrase if necessary.
+func decodeSwapEnvelope(js []byte, passphrase func() (string, error), key x25519Key) (SwapFile, error) {
+	ef, encrypted, err := parseEncryptedSwapFile(js)
+	if err != nil {
+		return SwapFile{}, err
+	} else if !encrypted {
+		return readSwapFile(bytes.NewReader(js))
+	}
+	return decryptSwapFile(ef, passphrase, key)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.sia.tech/siad/types"
+)
+
+func TestEncryptSwapFile(t *testing.T) {
+	_, alice, _ := newTestSwappers()
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newSwapFile(alice, swap, defaultSwapExpiry, "thanks!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase := func(p string) func() (string, error) {
+		return func() (string, error) { return p, nil }
+	}
+
+	// passphrase
+	ef, err := encryptSwapFile(f, EncryptionOptions{Passphrase: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	} else if bytes.Contains(ef.Ciphertext, []byte("thanks!")) {
+		t.Fatal("ciphertext contains plaintext")
+	}
+	if f2, err := decryptSwapFile(ef, passphrase("foo"), x25519Key{}); err != nil {
+		t.Fatal(err)
+	} else if f2.Hash != f.Hash {
+		t.Fatal("swap file changed after round trip")
+	} else if _, err := decryptSwapFile(ef, passphrase("bar"), x25519Key{}); err == nil {
+		t.Fatal("expected wrong passphrase to be rejected")
+	}
+
+	// public key
+	bobKey, eveKey := generateX25519Key(), generateX25519Key()
+	if _, err := encryptSwapFile(f, EncryptionOptions{Recipient: "x25519:1234"}); err == nil {
+		t.Fatal("expected invalid public key to be rejected")
+	}
+	ef, err = encryptSwapFile(f, EncryptionOptions{Recipient: bobKey.PublicKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f2, err := decryptSwapFile(ef, nil, bobKey); err != nil {
+		t.Fatal(err)
+	} else if f2.Hash != f.Hash {
+		t.Fatal("swap file changed after round trip")
+	} else if _, err := decryptSwapFile(ef, nil, eveKey); err == nil {
+		t.Fatal("expected wrong key to be rejected")
+	}
+	ef.Ciphertext[0] ^= 1
+	if _, err := decryptSwapFile(ef, nil, bobKey); err == nil {
+		t.Fatal("expected tampered ciphertext to be rejected")
+	}
+	ef.Ciphertext[0] ^= 1
+
+	// encrypted files and text are decrypted transparently
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, ef); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "swap.json")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{path, encodeEncryptedText(ef)} {
+		if f2, err := decodeSwapFile(s, bobKey); err != nil {
+			t.Fatal(err)
+		} else if f2.Hash != f.Hash {
+			t.Fatal("swap file changed after round trip")
+		}
+	}
+
+	// keys persist
+	keyPath := filepath.Join(t.TempDir(), "x25519.key")
+	if k1, err := loadX25519Key(keyPath); err != nil {
+		t.Fatal(err)
+	} else if k2, err := loadX25519Key(keyPath); err != nil {
+		t.Fatal(err)
+	} else if k1 != k2 {
+		t.Fatal("key changed after reload")
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/node/api"
+	"go.sia.tech/siad/types"
+)
+
+// hostd reports its wallet's history as events, which carry v1 transactions
+// encoded like renterd's. Unlike renterd's, a host's transactions also form
+// and renew file contracts, and the IDs of their outputs depend on those
+// contracts, so hostdTransaction adds them. Storage proofs are not converted:
+// a transaction with a storage proof cannot create outputs.
+
+type hostdFileContract struct {
+	Filesize           uint64                 `json:"filesize"`
+	FileMerkleRoot     coreHash               `json:"fileMerkleRoot"`
+	WindowStart        types.BlockHeight      `json:"windowStart"`
+	WindowEnd          types.BlockHeight      `json:"windowEnd"`
+	Payout             types.Currency         `json:"payout"`
+	ValidProofOutputs  []renterdSiacoinOutput `json:"validProofOutputs"`
+	MissedProofOutputs []renterdSiacoinOutput `json:"missedProofOutputs"`
+	UnlockHash         coreHash               `json:"unlockHash"`
+	RevisionNumber     uint64                 `json:"revisionNumber"`
+}
+
+type hostdFileContractRevision struct {
+	ParentID           coreHash                `json:"parentID"`
+	UnlockConditions   renterdUnlockConditions `json:"unlockConditions"`
+	Filesize           uint64                  `json:"filesize"`
+	FileMerkleRoot     coreHash                `json:"fileMerkleRoot"`
+	WindowStart        types.BlockHeight       `json:"windowStart"`
+	WindowEnd          types.BlockHeight       `json:"windowEnd"`
+	ValidProofOutputs  []renterdSiacoinOutput  `json:"validProofOutputs"`
+	MissedProofOutputs []renterdSiacoinOutput  `json:"missedProofOutputs"`
+	UnlockHash         coreHash                `json:"unlockHash"`
+	RevisionNumber     uint64                  `json:"revisionNumber"`
+}
+
+type hostdTransaction struct {
+	renterdTransaction
+	FileContracts         []hostdFileContract         `json:"fileContracts,omitempty"`
+	FileContractRevisions []hostdFileContractRevision `json:"fileContractRevisions,omitempty"`
+}
+
+func siacoinOutputs(rscos []renterdSiacoinOutput) []types.SiacoinOutput {
+	scos := make([]types.SiacoinOutput, len(rscos))
+	for i, sco := range rscos {
+		scos[i] = types.SiacoinOutput{Value: sco.Value, UnlockHash: types.UnlockHash(sco.Address)}
+	}
+	return scos
+}
+
+// transaction converts htxn to a siad transaction.
+func (htxn hostdTransaction) transaction() types.Transaction {
+	txn := htxn.renterdTransaction.transaction()
+	for _, fc := range htxn.FileContracts {
+		txn.FileContracts = append(txn.FileContracts, types.FileContract{
+			FileSize:           fc.Filesize,
+			FileMerkleRoot:     crypto.Hash(fc.FileMerkleRoot),
+			WindowStart:        fc.WindowStart,
+			WindowEnd:          fc.WindowEnd,
+			Payout:             fc.Payout,
+			ValidProofOutputs:  siacoinOutputs(fc.ValidProofOutputs),
+			MissedProofOutputs: siacoinOutputs(fc.MissedProofOutputs),
+			UnlockHash:         types.UnlockHash(fc.UnlockHash),
+			RevisionNumber:     fc.RevisionNumber,
+		})
+	}
+	for _, fcr := range htxn.FileContractRevisions {
+		txn.FileContractRevisions = append(txn.FileContractRevisions, types.FileContractRevision{
+			ParentID:              types.FileContractID(fcr.ParentID),
+			UnlockConditions:      fcr.UnlockConditions.unlockConditions(),
+			NewRevisionNumber:     fcr.RevisionNumber,
+			NewFileSize:           fcr.Filesize,
+			NewFileMerkleRoot:     crypto.Hash(fcr.FileMerkleRoot),
+			NewWindowStart:        fcr.WindowStart,
+			NewWindowEnd:          fcr.WindowEnd,
+			NewValidProofOutputs:  siacoinOutputs(fcr.ValidProofOutputs),
+			NewMissedProofOutputs: siacoinOutputs(fcr.MissedProofOutputs),
+			NewUnlockHash:         types.UnlockHash(fcr.UnlockHash),
+		})
+	}
+	return txn
+}
+
+// hostd event types that embc reads. Payouts, siafund claims, and contract
+// resolutions each create a single siacoin output.
+const (
+	hostdEventV1Transaction = "v1Transaction"
+	hostdEventV2Transaction = "v2Transaction"
+)
+
+// The following types mirror responses of hostd's API.
+
+type hostdSiacoinElement struct {
+	ID             coreSiacoinOutputID  `json:"id"`
+	SiacoinOutput  renterdSiacoinOutput `json:"siacoinOutput"`
+	MaturityHeight types.BlockHeight    `json:"maturityHeight"`
+}
+
+type hostdEvent struct {
+	ID    coreHash `json:"id"`
+	Index struct {
+		Height types.BlockHeight `json:"height"`
+	} `json:"index"`
+	Type string `json:"type"`
+	Data struct {
+		Transaction    hostdTransaction    `json:"transaction"`
+		SiacoinElement hostdSiacoinElement `json:"siacoinElement"`
+	} `json:"data"`
+}
+
+type hostdWalletResponse struct {
+	Address     coreAddress    `json:"address"`
+	Spendable   types.Currency `json:"spendable"`
+	Confirmed   types.Currency `json:"confirmed"`
+	Unconfirmed types.Currency `json:"unconfirmed"`
+	Immature    types.Currency `json:"immature"`
+}
+
+type hostdChainIndex struct {
+	Height types.BlockHeight `json:"height"`
+}
+
+// hostdEventsLimit is the number of events requested from hostd at a time; it
+// is the most hostd returns.
+const hostdEventsLimit = 500
+
+// hostdWallet implements Wallet using the wallet of a hostd node, via its API.
+// hostd's wallet holds a single address, and its API can neither list the
+// wallet's outputs nor sign a transaction built elsewhere. Its outputs are
+// instead found by replaying the wallet's events, and transactions must be
+// signed by an external signer.
+type hostdWallet struct {
+	jsonAPI
+
+	mu sync.Mutex
+	uc *types.UnlockConditions
+}
+
+// hostdBackend pairs a hostdWallet with the Chain of another node, since
+// hostd cannot broadcast a transaction built elsewhere.
+type hostdBackend struct {
+	*hostdWallet
+	Chain
+}
+
+// events returns every confirmed event of the wallet.
+func (w *hostdWallet) events() ([]hostdEvent, error) {
+	var events []hostdEvent
+	for {
+		var page []hostdEvent
+		if err := w.req("GET", fmt.Sprintf("/wallet/events?offset=%d&limit=%d", len(events), hostdEventsLimit), nil, &page); err != nil {
+			return nil, fmt.Errorf("failed to get wallet events: %w", err)
+		}
+		events = append(events, page...)
+		if len(page) < hostdEventsLimit {
+			return events, nil
+		}
+	}
+}
+
+// pending returns the wallet's unconfirmed events.
+func (w *hostdWallet) pending() ([]hostdEvent, error) {
+	var events []hostdEvent
+	if err := w.req("GET", "/wallet/pending", nil, &events); err != nil {
+		return nil, fmt.Errorf("failed to get pending wallet events: %w", err)
+	}
+	return events, nil
+}
+
+// tip returns hostd's current block height.
+func (w *hostdWallet) tip() (types.BlockHeight, error) {
+	var ci hostdChainIndex
+	err := w.req("GET", "/consensus/tip", nil, &ci)
+	return ci.Height, err
+}
+
+// processed returns the wallet's view of the transaction of e, confirmed at
+// height. The event's ID is the transaction's ID.
+func (w *hostdWallet) processed(e hostdEvent, height types.BlockHeight, addr types.UnlockHash) modules.ProcessedTransaction {
+	pt := processedForAddress(e.Data.Transaction.transaction(), height, addr)
+	pt.TransactionID = types.TransactionID(e.ID)
+	return pt
+}
+
+// Address implements Wallet. hostd's wallet has only one address.
+func (w *hostdWallet) Address() (types.UnlockHash, error) {
+	var wr hostdWalletResponse
+	err := w.req("GET", "/wallet", nil, &wr)
+	return types.UnlockHash(wr.Address), err
+}
+
+// Addresses implements Wallet.
+func (w *hostdWallet) Addresses() ([]types.UnlockHash, error) {
+	addr, err := w.Address()
+	if err != nil {
+		return nil, err
+	}
+	return []types.UnlockHash{addr}, nil
+}
+
+// UnspentOutputs implements Wallet. The outputs are those created by the
+// wallet's events and not spent by any of them. Outputs created by pending
+// transactions are reported as unconfirmed; outputs spent by them are left to
+// coin selection, which skips outputs spent in the transaction pool.
+func (w *hostdWallet) UnspentOutputs() ([]modules.UnspentOutput, error) {
+	addr, err := w.Address()
+	if err != nil {
+		return nil, err
+	}
+	height, err := w.tip()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get consensus height: %w", err)
+	}
+	events, err := w.events()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := w.pending()
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []modules.UnspentOutput
+	spent := make(map[types.OutputID]bool)
+	add := func(e hostdEvent, confirmationHeight types.BlockHeight) error {
+		switch e.Type {
+		case hostdEventV1Transaction:
+			txn := e.Data.Transaction.transaction()
+			for _, sci := range txn.SiacoinInputs {
+				spent[types.OutputID(sci.ParentID)] = true
+			}
+			for i, sco := range txn.SiacoinOutputs {
+				if sco.UnlockHash == addr {
+					outputs = append(outputs, modules.UnspentOutput{
+						ID:                 types.OutputID(txn.SiacoinOutputID(uint64(i))),
+						FundType:           types.SpecifierSiacoinOutput,
+						UnlockHash:         addr,
+						Value:              sco.Value,
+						ConfirmationHeight: confirmationHeight,
+					})
+				}
+			}
+		case hostdEventV2Transaction:
+			return errors.New("hostd's wallet has v2 transactions, which embc cannot read")
+		default:
+			se := e.Data.SiacoinElement
+			if se.MaturityHeight <= height && types.UnlockHash(se.SiacoinOutput.Address) == addr {
+				outputs = append(outputs, modules.UnspentOutput{
+					ID:                 types.OutputID(se.ID),
+					FundType:           types.SpecifierSiacoinOutput,
+					UnlockHash:         addr,
+					Value:              se.SiacoinOutput.Value,
+					ConfirmationHeight: confirmationHeight,
+				})
+			}
+		}
+		return nil
+	}
+	for _, e := range events {
+		if err := add(e, e.Index.Height); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range pending {
+		if err := add(e, types.BlockHeight(math.MaxUint64)); err != nil {
+			return nil, err
+		}
+	}
+	unspent := outputs[:0]
+	for _, o := range outputs {
+		if !spent[o.ID] {
+			unspent = append(unspent, o)
+		}
+	}
+	return unspent, nil
+}
+
+// UnconfirmedTransactions implements Wallet.
+func (w *hostdWallet) UnconfirmedTransactions() ([]modules.ProcessedTransaction, error) {
+	addr, err := w.Address()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := w.pending()
+	if err != nil {
+		return nil, err
+	}
+	var pts []modules.ProcessedTransaction
+	for _, e := range pending {
+		if e.Type == hostdEventV1Transaction {
+			pts = append(pts, w.processed(e, types.BlockHeight(math.MaxUint64), addr))
+		}
+	}
+	return pts, nil
+}
+
+// UnlockConditions implements Wallet. Like renterd, hostd does not report the
+// public key of its address, so the unlock conditions are taken from a
+// transaction in the wallet's history that spends from the address.
+func (w *hostdWallet) UnlockConditions(addr types.UnlockHash) (types.UnlockConditions, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.uc != nil && w.uc.UnlockHash() == addr {
+		return *w.uc, nil
+	}
+	pending, err := w.pending()
+	if err != nil {
+		return types.UnlockConditions{}, err
+	}
+	events, err := w.events()
+	if err != nil {
+		return types.UnlockConditions{}, err
+	}
+	for _, e := range append(pending, events...) {
+		for _, sci := range e.Data.Transaction.SiacoinInputs {
+			if uc := sci.UnlockConditions.unlockConditions(); uc.UnlockHash() == addr {
+				w.uc = &uc
+				return uc, nil
+			}
+		}
+	}
+	return types.UnlockConditions{}, fmt.Errorf("hostd's wallet has never spent from %v, so its public key is unknown; send any amount from the wallet, e.g. to itself, and try again", addr)
+}
+
+// SignTransaction implements Wallet. hostd cannot sign a transaction built
+// elsewhere, so the hostd wallet must be used with an external signer.
+func (w *hostdWallet) SignTransaction(txn *types.Transaction, toSign []crypto.Hash) error {
+	return errors.New("hostd cannot sign transactions built by embc; sign them with -signer instead")
+}
+
+// Transaction implements Wallet.
+func (w *hostdWallet) Transaction(id types.TransactionID) (modules.ProcessedTransaction, error) {
+	addr, err := w.Address()
+	if err != nil {
+		return modules.ProcessedTransaction{}, err
+	}
+	pending, err := w.pending()
+	if err != nil {
+		return modules.ProcessedTransaction{}, err
+	}
+	for _, e := range pending {
+		if e.Type == hostdEventV1Transaction && types.TransactionID(e.ID) == id {
+			return w.processed(e, types.BlockHeight(math.MaxUint64), addr), nil
+		}
+	}
+	events, err := w.events()
+	if err != nil {
+		return modules.ProcessedTransaction{}, err
+	}
+	for _, e := range events {
+		if e.Type == hostdEventV1Transaction && types.TransactionID(e.ID) == id {
+			return w.processed(e, e.Index.Height, addr), nil
+		}
+	}
+	return modules.ProcessedTransaction{}, errors.New("could not find transaction")
+}
+
+// Status implements Wallet.
+func (w *hostdWallet) Status() (api.WalletGET, error) {
+	var wr hostdWalletResponse
+	if err := w.req("GET", "/wallet", nil, &wr); err != nil {
+		return api.WalletGET{}, err
+	}
+	height, err := w.tip()
+	if err != nil {
+		return api.WalletGET{}, err
+	}
+	return api.WalletGET{
+		Encrypted:                   true,
+		Unlocked:                    true,
+		Height:                      height,
+		ConfirmedSiacoinBalance:     wr.Confirmed,
+		UnconfirmedIncomingSiacoins: wr.Unconfirmed,
+	}, nil
+}
+
+// newHostdBackend returns a Backend that uses the wallet of the hostd API at
+// addr, e.g. http://localhost:9980/api, and broadcasts through chain.
+func newHostdBackend(addr, password string, chain Chain) hostdBackend {
+	return hostdBackend{
+		hostdWallet: &hostdWallet{
+			jsonAPI: jsonAPI{
+				name:     "hostd",
+				addr:     strings.TrimSuffix(addr, "/"),
+				password: password,
+				client:   http.Client{Timeout: time.Minute},
+			},
+		},
+		Chain: chain,
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+// toHostdTransaction converts txn to hostd's encoding.
+func toHostdTransaction(txn types.Transaction) hostdTransaction {
+	toOutputs := func(scos []types.SiacoinOutput) []renterdSiacoinOutput {
+		rscos := make([]renterdSiacoinOutput, len(scos))
+		for i, sco := range scos {
+			rscos[i] = renterdSiacoinOutput{sco.Value, coreAddress(sco.UnlockHash)}
+		}
+		return rscos
+	}
+	htxn := hostdTransaction{renterdTransaction: toRenterdTransaction(txn)}
+	for _, fc := range txn.FileContracts {
+		htxn.FileContracts = append(htxn.FileContracts, hostdFileContract{
+			Filesize:           fc.FileSize,
+			FileMerkleRoot:     coreHash(fc.FileMerkleRoot),
+			WindowStart:        fc.WindowStart,
+			WindowEnd:          fc.WindowEnd,
+			Payout:             fc.Payout,
+			ValidProofOutputs:  toOutputs(fc.ValidProofOutputs),
+			MissedProofOutputs: toOutputs(fc.MissedProofOutputs),
+			UnlockHash:         coreHash(fc.UnlockHash),
+			RevisionNumber:     fc.RevisionNumber,
+		})
+	}
+	for _, fcr := range txn.FileContractRevisions {
+		htxn.FileContractRevisions = append(htxn.FileContractRevisions, hostdFileContractRevision{
+			ParentID:           coreHash(fcr.ParentID),
+			UnlockConditions:   toRenterdUnlockConditions(fcr.UnlockConditions),
+			Filesize:           fcr.NewFileSize,
+			FileMerkleRoot:     coreHash(fcr.NewFileMerkleRoot),
+			WindowStart:        fcr.NewWindowStart,
+			WindowEnd:          fcr.NewWindowEnd,
+			ValidProofOutputs:  toOutputs(fcr.NewValidProofOutputs),
+			MissedProofOutputs: toOutputs(fcr.NewMissedProofOutputs),
+			UnlockHash:         coreHash(fcr.NewUnlockHash),
+			RevisionNumber:     fcr.NewRevisionNumber,
+		})
+	}
+	return htxn
+}
+
+// newFakeHostd returns a stand-in for hostd's API that serves the first
+// address of w as its wallet. Besides w's transactions, the wallet's history
+// holds a miner payout to the address that has not yet matured.
+func newFakeHostd(w *memWallet, password string) *httptest.Server {
+	addr := w.addrs[0]
+	txnEvent := func(txn types.Transaction, height types.BlockHeight) hostdEvent {
+		var e hostdEvent
+		e.ID = coreHash(txn.ID())
+		e.Index.Height = height
+		e.Type = hostdEventV1Transaction
+		e.Data.Transaction = toHostdTransaction(txn)
+		return e
+	}
+	mux := http.NewServeMux()
+	handle := func(route string, fn func(req *http.Request) (interface{}, error)) {
+		mux.HandleFunc(route, func(rw http.ResponseWriter, req *http.Request) {
+			if _, p, _ := req.BasicAuth(); p != password {
+				http.Error(rw, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			resp, err := fn(req)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(rw).Encode(resp)
+		})
+	}
+
+	handle("/wallet", func(req *http.Request) (interface{}, error) {
+		sc, _ := w.balance()
+		return hostdWalletResponse{
+			Address:   coreAddress(addr),
+			Spendable: sc,
+			Confirmed: sc,
+			Immature:  types.SiacoinPrecision,
+		}, nil
+	})
+	handle("/wallet/events", func(req *http.Request) (interface{}, error) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		var payout hostdEvent
+		payout.ID = coreHash{1}
+		payout.Index.Height = w.height
+		payout.Type = "miner"
+		payout.Data.SiacoinElement = hostdSiacoinElement{
+			ID:             coreSiacoinOutputID{1},
+			SiacoinOutput:  renterdSiacoinOutput{types.SiacoinPrecision, coreAddress(addr)},
+			MaturityHeight: w.height + types.MaturityDelay,
+		}
+		events := []hostdEvent{payout}
+		for _, pt := range w.txns {
+			if pt.ConfirmationHeight != unconfirmedHeight && w.relevant(pt.Transaction) {
+				events = append(events, txnEvent(pt.Transaction, pt.ConfirmationHeight))
+			}
+		}
+		offset, _ := strconv.Atoi(req.FormValue("offset"))
+		limit, _ := strconv.Atoi(req.FormValue("limit"))
+		if offset > len(events) {
+			offset = len(events)
+		}
+		if limit > len(events)-offset {
+			limit = len(events) - offset
+		}
+		return events[offset : offset+limit], nil
+	})
+	handle("/wallet/pending", func(req *http.Request) (interface{}, error) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		events := []hostdEvent{}
+		for _, txn := range w.tpool {
+			if w.relevant(txn) {
+				events = append(events, txnEvent(txn, 0))
+			}
+		}
+		return events, nil
+	})
+	handle("/consensus/tip", func(req *http.Request) (interface{}, error) {
+		cg, err := w.Consensus()
+		return hostdChainIndex{Height: cg.Height}, err
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestHostdEncoding(t *testing.T) {
+	w := newMemWallet(newMemChain(), types.ZeroCurrency, types.ZeroCurrency)
+	addr, _ := w.Address()
+	uc, _ := w.UnlockConditions(addr)
+	outputs := []types.SiacoinOutput{{Value: types.SiacoinPrecision, UnlockHash: addr}}
+	txn := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: types.SiacoinOutputID{1}, UnlockConditions: uc}},
+		SiacoinOutputs: outputs,
+		FileContracts: []types.FileContract{{
+			FileSize:           4096,
+			FileMerkleRoot:     crypto.Hash{2},
+			WindowStart:        100,
+			WindowEnd:          244,
+			Payout:             types.SiacoinPrecision.Mul64(2),
+			ValidProofOutputs:  outputs,
+			MissedProofOutputs: outputs,
+			UnlockHash:         addr,
+			RevisionNumber:     1,
+		}},
+		FileContractRevisions: []types.FileContractRevision{{
+			ParentID:              types.FileContractID{3},
+			UnlockConditions:      uc,
+			NewRevisionNumber:     2,
+			NewFileSize:           8192,
+			NewFileMerkleRoot:     crypto.Hash{4},
+			NewWindowStart:        100,
+			NewWindowEnd:          244,
+			NewValidProofOutputs:  outputs,
+			NewMissedProofOutputs: outputs,
+			NewUnlockHash:         addr,
+		}},
+		MinerFees: []types.Currency{types.SiacoinPrecision},
+	}
+	js, err := json.Marshal(toHostdTransaction(txn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"fileContracts":[{"filesize":4096,"fileMerkleRoot":"h:`, `"fileContractRevisions":[{"parentID":"h:`, `"revisionNumber":2`} {
+		if !strings.Contains(string(js), s) {
+			t.Errorf("expected encoding to contain %s, got %s", s, js)
+		}
+	}
+	var htxn hostdTransaction
+	if err := json.Unmarshal(js, &htxn); err != nil {
+		t.Fatal(err)
+	} else if got := htxn.transaction(); got.ID() != txn.ID() || got.SiacoinOutputID(0) != txn.SiacoinOutputID(0) {
+		t.Fatal("transaction changed after round trip")
+	}
+
+	// a payout, as hostd encodes it
+	var e hostdEvent
+	err = json.Unmarshal([]byte(`{
+		"id": "h:0100000000000000000000000000000000000000000000000000000000000000",
+		"index": {"height": 7, "id": "bid:0200000000000000000000000000000000000000000000000000000000000000"},
+		"type": "miner",
+		"data": {"siacoinElement": {
+			"id": "h:0300000000000000000000000000000000000000000000000000000000000000",
+			"leafIndex": 12,
+			"siacoinOutput": {"value": "1000", "address": "addr:`+addr.String()+`"},
+			"maturityHeight": 151
+		}},
+		"maturityHeight": 151,
+		"timestamp": "2024-08-07T00:00:00Z"
+	}`), &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se := e.Data.SiacoinElement
+	if e.Index.Height != 7 || se.ID != (coreSiacoinOutputID{3}) || types.UnlockHash(se.SiacoinOutput.Address) != addr || !se.SiacoinOutput.Value.Equals64(1000) || se.MaturityHeight != 151 {
+		t.Fatalf("payout decoded incorrectly: %+v", e)
+	}
+}
+
+func TestHostdBackend(t *testing.T) {
+	sc := types.SiacoinPrecision.Mul64
+	c, alice, bob := newTestSwappers()
+	srv := newFakeHostd(alice, "foo")
+	defer srv.Close()
+	if _, err := newHostdBackend(srv.URL, "bar", alice).Status(); err == nil {
+		t.Fatal("expected wrong password to be rejected")
+	}
+	hb := newHostdBackend(srv.URL, "foo", alice)
+
+	// alice's keys are held by a signing service, since hostd cannot sign
+	signer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var sr SignerRequest
+		if err := json.NewDecoder(req.Body).Decode(&sr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var resp SignerResponse
+		if err := alice.SignTransaction(&sr.Transaction, sr.ToSign); err != nil {
+			resp.Error = err.Error()
+		}
+		for _, sig := range sr.Transaction.TransactionSignatures {
+			for _, id := range sr.ToSign {
+				if sig.ParentID == id {
+					resp.Signatures = append(resp.Signatures, sig)
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer signer.Close()
+	b := externalSignerBackend{hb, signer.URL}
+
+	// hostd's public key is unknown until its wallet spends from its address
+	addr := alice.addrs[0]
+	if err := checkWalletKey(b); err == nil {
+		t.Fatal("expected wallet key to be unknown without a spend from the wallet")
+	}
+	outputs, err := alice.UnspentOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uc, _ := alice.UnlockConditions(addr)
+	txn := types.Transaction{MinerFees: []types.Currency{testMinerFee}}
+	for _, o := range outputs {
+		if o.FundType == types.SpecifierSiacoinOutput && o.UnlockHash == addr {
+			txn.SiacoinInputs = []types.SiacoinInput{{ParentID: types.SiacoinOutputID(o.ID), UnlockConditions: uc}}
+			txn.SiacoinOutputs = []types.SiacoinOutput{{UnlockHash: addr, Value: o.Value.Sub(testMinerFee)}}
+			txn.TransactionSignatures = []types.TransactionSignature{{ParentID: crypto.Hash(o.ID), CoveredFields: types.FullCoveredFields}}
+			break
+		}
+	}
+	if err := b.SignTransaction(&txn, []crypto.Hash{txn.TransactionSignatures[0].ParentID}); err != nil {
+		t.Fatal(err)
+	} else if err := b.BroadcastTransaction(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	// the spend's output is unconfirmed until it is mined
+	outputs, err = b.UnspentOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(outputs) != 1 || outputs[0].ID != types.OutputID(txn.SiacoinOutputID(0)) || outputs[0].ConfirmationHeight != unconfirmedHeight {
+		t.Fatalf("expected only the spend's unconfirmed output, got %v", outputs)
+	} else if pts, err := b.UnconfirmedTransactions(); err != nil || len(pts) != 1 || pts[0].TransactionID != txn.ID() {
+		t.Fatalf("expected the spend to be unconfirmed, got %v (%v)", pts, err)
+	}
+	c.mine()
+	if err := checkWalletKey(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// the unmatured payout is not spendable
+	outputs, err = b.UnspentOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(outputs) != 1 || outputs[0].ID != types.OutputID(txn.SiacoinOutputID(0)) || outputs[0].ConfirmationHeight == unconfirmedHeight {
+		t.Fatalf("expected only the spend's confirmed output, got %v", outputs)
+	}
+
+	// hostd cannot sign on its own
+	swap, err := createSwap(b, Basket{SC: sc(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := checkFinish(b, swap, false); err != nil {
+		t.Fatal(err)
+	} else if unsigned := swap; finishSwap(hb, &unsigned) == nil {
+		t.Fatal("expected hostd to refuse to sign")
+	} else if err := finishSwap(b, &swap); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, b, swap, swapTransactionPending)
+	c.mine()
+	checkStatus(t, b, swap, swapTransactionConfirmed)
+	if aliceSC, aliceSF := alice.balance(); !aliceSF.Equals64(2) || aliceSC.Cmp(sc(900)) >= 0 {
+		t.Fatalf("alice has wrong balance after swap: %v SC, %v SF", aliceSC.HumanString(), aliceSF)
+	}
+}
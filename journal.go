@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/encoding"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+)
+
+// Journal stages, in the order a swap progresses through them.
+const (
+	stageCreated   = "created"
+	stageAccepted  = "accepted"
+	stageFinished  = "finished"
+	stageBroadcast = "broadcast"
+	stageConfirmed = "confirmed"
+	stageCancelled = "cancelled"
+	stageClosed    = "closed"
+)
+
+var stageOrder = map[string]int{
+	stageCreated:   0,
+	stageAccepted:  1,
+	stageFinished:  2,
+	stageBroadcast: 3,
+	stageConfirmed: 4,
+	stageCancelled: 5,
+	stageClosed:    5,
+}
+
+// A SwapEvent records when a swap reached a stage.
+type SwapEvent struct {
+	Stage     string              `json:"stage"`
+	TxnID     types.TransactionID `json:"txnID"`
+	Timestamp time.Time           `json:"timestamp"`
+}
+
+// A SwapRecord is a journal entry tracking a swap through each of its stages.
+// Expiry is the height after which the swap file carrying the swap expires,
+// or zero if it never does.
+type SwapRecord struct {
+	ID      string              `json:"id"`
+	TxnID   types.TransactionID `json:"txnID"`
+	Stage   string              `json:"stage"`
+	Label   string              `json:"label"`
+	Notes   string              `json:"notes"`
+	Expiry  types.BlockHeight   `json:"expiry,omitempty"`
+	Swap    SwapTransaction     `json:"swap"`
+	History []SwapEvent         `json:"history"`
+}
+
+// Created returns the time the record was created.
+func (r SwapRecord) Created() time.Time {
+	return r.History[0].Timestamp
+}
+
+// Updated returns the time the record last changed stage.
+func (r SwapRecord) Updated() time.Time {
+	return r.History[len(r.History)-1].Timestamp
+}
+
+// expired reports whether the swap file carrying r's swap expired before
+// height, while the swap had yet to be broadcast. Such a swap can no longer
+// be accepted or finished.
+func (r SwapRecord) expired(height types.BlockHeight) bool {
+	return r.Expiry != 0 && height > r.Expiry && stageOrder[r.Stage] < stageOrder[stageBroadcast]
+}
+
+// reservationPeriod is how long the outputs spent by a pending swap remain
+// reserved after the swap last changed stage.
+const reservationPeriod = 72 * time.Hour
+
+// A journal is a persistent local record of swaps. The inputs of pending swaps
+// in the journal are reserved, so that they are not spent by other swaps.
+//
+// Several embc processes may share a journal, e.g. the web server and the CLI.
+// Every change is made under an exclusive lock on the journal's lock file,
+// after reloading the journal from disk, so that no process overwrites the
+// records or reservations of another.
+type journal struct {
+	fundMu  sync.Mutex // serializes FundSwap
+	mu      sync.Mutex
+	path    string
+	records []SwapRecord
+	loaded  os.FileInfo // of the journal file when last loaded or saved
+}
+
+// lock takes the journal's lock file, blocking until no other process holds
+// it.
+func (j *journal) lock() (unlock func() error, err error) {
+	unlock, err = lockFile(j.path + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock swap journal: %w", err)
+	}
+	return unlock, nil
+}
+
+// load reads the journal from disk, merging in any records in memory that
+// have not been saved.
+func (j *journal) load() error {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var records []SwapRecord
+	if err := json.NewDecoder(f).Decode(&records); err != nil {
+		return fmt.Errorf("failed to decode swap journal: %w", err)
+	}
+	j.records = mergeRecords(records, j.records)
+	j.loaded = stat
+	return nil
+}
+
+// reload reloads the journal if another process has saved it since it was
+// last loaded. Saves replace the file atomically, so no lock is needed.
+func (j *journal) reload() {
+	stat, err := os.Stat(j.path)
+	if err != nil || (j.loaded != nil && os.SameFile(stat, j.loaded) && stat.ModTime().Equal(j.loaded.ModTime()) && stat.Size() == j.loaded.Size()) {
+		return
+	} else if err := j.load(); err != nil {
+		log.Println("Warning: failed to reload swap journal:", err)
+	}
+}
+
+// update reloads the journal under its lock and calls fn to change it,
+// saving it if fn reports a change.
+func (j *journal) update(fn func() (changed bool, err error)) error {
+	unlock, err := j.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.load(); err != nil {
+		return err
+	}
+	changed, err := fn()
+	if err != nil || !changed {
+		return err
+	}
+	return j.save()
+}
+
+// mergeRecords merges the records loaded from disk with those in memory.
+// Records are never removed, so a record missing from disk has yet to be
+// saved; of two versions of a record, the one that has progressed further is
+// kept.
+func mergeRecords(disk, mem []SwapRecord) []SwapRecord {
+	index := make(map[string]int, len(disk))
+	for i, r := range disk {
+		index[r.ID] = i
+	}
+	for _, r := range mem {
+		if i, ok := index[r.ID]; !ok {
+			disk = append(disk, r)
+		} else if len(r.History) > len(disk[i].History) {
+			disk[i] = r
+		}
+	}
+	return disk
+}
+
+// save atomically writes the journal to disk. The caller must hold the
+// journal's lock.
+func (j *journal) save() error {
+	tmp := j.path + "_temp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := encodeJSON(f, j.records); err != nil {
+		return err
+	} else if err := f.Sync(); err != nil {
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	} else if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	if stat, err := os.Stat(j.path); err == nil {
+		j.loaded = stat
+	}
+	return nil
+}
+
+// sharesInputs reports whether two swaps spend any of the same outputs. Each
+// party keeps its inputs as the swap progresses, so this identifies the same
+// swap across stages.
+func sharesInputs(a, b SwapTransaction) bool {
+	ids := make(map[types.OutputID]bool)
+	for _, sci := range a.SiacoinInputs {
+		ids[types.OutputID(sci.ParentID)] = true
+	}
+	for _, sfi := range a.SiafundInputs {
+		ids[types.OutputID(sfi.ParentID)] = true
+	}
+	for _, sci := range b.SiacoinInputs {
+		if ids[types.OutputID(sci.ParentID)] {
+			return true
+		}
+	}
+	for _, sfi := range b.SiafundInputs {
+		if ids[types.OutputID(sfi.ParentID)] {
+			return true
+		}
+	}
+	return false
+}
+
+func newRecordID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Record records that a swap has reached the specified stage, creating a new
+// record if the swap is not already in the journal. Records never move back to
+// an earlier stage.
+func (j *journal) Record(swap SwapTransaction, stage string) (SwapRecord, error) {
+	return j.record(swap, stage, swap.transaction().ID())
+}
+
+// Cancel records that a swap was cancelled by the transaction cancelID.
+func (j *journal) Cancel(swap SwapTransaction, cancelID types.TransactionID) (SwapRecord, error) {
+	return j.record(swap, stageCancelled, cancelID)
+}
+
+// record records that a swap has reached the specified stage, noting txnID in
+// the record's history.
+func (j *journal) record(swap SwapTransaction, stage string, txnID types.TransactionID) (rec SwapRecord, err error) {
+	err = j.update(func() (bool, error) {
+		i := j.find(swap)
+		if i == -1 {
+			j.records = append(j.records, SwapRecord{ID: newRecordID()})
+			i = len(j.records) - 1
+		}
+		r := &j.records[i]
+		if stageOrder[stage] < stageOrder[r.Stage] || (r.Stage == stage && swapEqual(r.Swap, swap)) {
+			rec = *r
+			return false, nil
+		} else if r.Stage != stage {
+			r.History = append(r.History, SwapEvent{
+				Stage:     stage,
+				TxnID:     txnID,
+				Timestamp: time.Now(),
+			})
+		}
+		r.Stage = stage
+		r.Swap = swap
+		r.TxnID = swap.transaction().ID()
+		rec = *r
+		return true, nil
+	})
+	return
+}
+
+// swapEqual reports whether two versions of a swap are identical, including
+// their signatures.
+func swapEqual(a, b SwapTransaction) bool {
+	return bytes.Equal(encoding.Marshal(a), encoding.Marshal(b))
+}
+
+// add creates a new record for a swap. The caller must hold the journal's
+// lock.
+func (j *journal) add(swap SwapTransaction, stage string) (SwapRecord, error) {
+	txnID := swap.transaction().ID()
+	j.records = append(j.records, SwapRecord{
+		ID:    newRecordID(),
+		TxnID: txnID,
+		Stage: stage,
+		Swap:  swap,
+		History: []SwapEvent{{
+			Stage:     stage,
+			TxnID:     txnID,
+			Timestamp: time.Now(),
+		}},
+	})
+	return j.records[len(j.records)-1], j.save()
+}
+
+// find returns the index of the most recent record that shares inputs with
+// swap, or -1 if there is none.
+func (j *journal) find(swap SwapTransaction) int {
+	for i := len(j.records) - 1; i >= 0; i-- {
+		if sharesInputs(j.records[i].Swap, swap) {
+			return i
+		}
+	}
+	return -1
+}
+
+// CheckChangeFee checks that a swap we created still carries the change fee
+// recorded when we created it. The change fee is our dust, added to the miner
+// fee, so a counterparty that raised it could pay part of our proceeds to the
+// miner instead.
+func (j *journal) CheckChangeFee(swap SwapTransaction) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.reload()
+	i := j.find(swap)
+	if i == -1 || j.records[i].Stage != stageCreated {
+		return nil
+	} else if created := j.records[i].Swap.ChangeFee; !swap.ChangeFee.Equals(created) {
+		return fmt.Errorf("swap adds %v of our change to the miner fee, but we created it with %v", swap.ChangeFee.HumanString(), created.HumanString())
+	}
+	return nil
+}
+
+// Annotate sets the label and notes of a record. Empty values are ignored.
+func (j *journal) Annotate(id, label, notes string) (rec SwapRecord, err error) {
+	err = j.update(func() (bool, error) {
+		i, err := j.lookup(id)
+		if err != nil {
+			return false, err
+		}
+		if label != "" {
+			j.records[i].Label = label
+		}
+		if notes != "" {
+			j.records[i].Notes = notes
+		}
+		rec = j.records[i]
+		return true, nil
+	})
+	return
+}
+
+// SetExpiry sets the height after which the swap file carrying a record's
+// swap expires.
+func (j *journal) SetExpiry(id string, expiry types.BlockHeight) (rec SwapRecord, err error) {
+	err = j.update(func() (bool, error) {
+		i, err := j.lookup(id)
+		if err != nil {
+			return false, err
+		}
+		j.records[i].Expiry = expiry
+		rec = j.records[i]
+		return true, nil
+	})
+	return
+}
+
+// lookup returns the index of the record whose ID or transaction ID begins
+// with prefix.
+func (j *journal) lookup(prefix string) (int, error) {
+	match := -1
+	for i, r := range j.records {
+		if strings.HasPrefix(r.ID, prefix) || strings.HasPrefix(r.TxnID.String(), prefix) {
+			if match != -1 {
+				return -1, fmt.Errorf("swap ID %q is ambiguous", prefix)
+			}
+			match = i
+		}
+	}
+	if prefix == "" || match == -1 {
+		return -1, fmt.Errorf("no swap with ID %q", prefix)
+	}
+	return match, nil
+}
+
+// Swap returns the record whose ID or transaction ID begins with prefix.
+func (j *journal) Swap(prefix string) (SwapRecord, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.reload()
+	i, err := j.lookup(prefix)
+	if err != nil {
+		return SwapRecord{}, err
+	}
+	return j.records[i], nil
+}
+
+// Swaps returns every record in the journal, oldest first.
+func (j *journal) Swaps() []SwapRecord {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.reload()
+	return append([]SwapRecord(nil), j.records...)
+}
+
+// reserved returns the set of outputs spent by pending swaps whose
+// reservations have not expired.
+func (j *journal) reserved() map[types.OutputID]bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.reload()
+	return j.reservedLocked()
+}
+
+// reservedLocked returns the outputs reserved by the records in memory.
+func (j *journal) reservedLocked() map[types.OutputID]bool {
+	reserved := make(map[types.OutputID]bool)
+	for _, r := range j.records {
+		switch r.Stage {
+		case stageCreated, stageAccepted, stageFinished:
+		default:
+			continue
+		}
+		if time.Since(r.Updated()) > reservationPeriod {
+			continue
+		}
+		for _, sci := range r.Swap.SiacoinInputs {
+			reserved[types.OutputID(sci.ParentID)] = true
+		}
+		for _, sfi := range r.Swap.SiafundInputs {
+			reserved[types.OutputID(sfi.ParentID)] = true
+		}
+	}
+	return reserved
+}
+
+// A reservingWallet is a Wallet that hides reserved outputs.
+type reservingWallet struct {
+	Wallet
+	reserved map[types.OutputID]bool
+}
+
+// UnspentOutputs implements Wallet.
+func (w reservingWallet) UnspentOutputs() ([]modules.UnspentOutput, error) {
+	outputs, err := w.Wallet.UnspentOutputs()
+	if err != nil {
+		return nil, err
+	}
+	filtered := outputs[:0]
+	for _, o := range outputs {
+		if !w.reserved[o.ID] {
+			filtered = append(filtered, o)
+		}
+	}
+	return filtered, nil
+}
+
+// FundSwap calls fn with a Wallet that hides the outputs reserved by pending
+// swaps or spent in the transaction pool, then adds a new record for the swap
+// that fn returns, reserving its inputs. Calls are serialized, within and
+// across processes, so that concurrent swaps never select the same outputs.
+func (j *journal) FundSwap(b Backend, stage string, fn func(Wallet) (SwapTransaction, error)) (SwapRecord, error) {
+	j.fundMu.Lock()
+	defer j.fundMu.Unlock()
+	unlock, err := j.lock()
+	if err != nil {
+		return SwapRecord{}, err
+	}
+	defer unlock()
+	hidden, err := poolSpent(b)
+	if err != nil {
+		return SwapRecord{}, err
+	}
+	j.mu.Lock()
+	err = j.load()
+	reserved := j.reservedLocked()
+	j.mu.Unlock()
+	if err != nil {
+		return SwapRecord{}, err
+	}
+	for id := range reserved {
+		hidden[id] = true
+	}
+	swap, err := fn(reservingWallet{b, hidden})
+	if err != nil {
+		return SwapRecord{}, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	r, err := j.add(swap, stage)
+	if err != nil {
+		return SwapRecord{}, fmt.Errorf("failed to reserve swap inputs: %w", err)
+	}
+	return r, nil
+}
+
+// Refresh advances swaps that w reports as broadcast, confirmed, or cancelled,
+// and open offers that are no longer available.
+func (j *journal) Refresh(w Wallet) error {
+	for _, r := range j.Swaps() {
+		if stage, ok := refreshedStage(w, r); ok {
+			if _, err := j.Record(r.Swap, stage); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// refreshedStage returns the stage that w reports r to have reached, if it is
+// later than the stage recorded.
+func refreshedStage(w Wallet, r SwapRecord) (string, bool) {
+	switch r.Stage {
+	case stageConfirmed, stageCancelled, stageClosed:
+		return "", false
+	}
+	var stage string
+	switch status(w, r.Swap) {
+	case swapTransactionPending:
+		stage = stageBroadcast
+	case swapTransactionConfirmed:
+		stage = stageConfirmed
+	case swapCancelled:
+		stage = stageCancelled
+	case offerClosed:
+		stage = stageClosed
+	default:
+		return "", false
+	}
+	return stage, stageOrder[stage] > stageOrder[r.Stage]
+}
+
+// recordSwap records a swap in the journal, logging a warning if the journal
+// cannot be updated.
+func recordSwap(j *journal, swap SwapTransaction, stage string) SwapRecord {
+	r, err := j.Record(swap, stage)
+	if err != nil {
+		log.Println("Warning: failed to record swap in journal:", err)
+	}
+	return r
+}
+
+// annotateSwap sets the label and notes of a recorded swap, if either is
+// non-empty, logging a warning if the journal cannot be updated.
+func annotateSwap(j *journal, id, label, notes string) {
+	if label == "" && notes == "" {
+		return
+	} else if _, err := j.Annotate(id, label, notes); err != nil {
+		log.Println("Warning: failed to annotate swap in journal:", err)
+	}
+}
+
+// recordExpiry records the expiry of the swap file f carrying a recorded
+// swap, if it expires, logging a warning if the journal cannot be updated.
+func recordExpiry(j *journal, id string, f SwapFile) {
+	if id == "" || f.Expiry == 0 {
+		return
+	} else if _, err := j.SetExpiry(id, f.Expiry); err != nil {
+		log.Println("Warning: failed to record swap expiry in journal:", err)
+	}
+}
+
+// openJournal loads the journal at path, creating it if it does not exist.
+func openJournal(path string) (*journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	j := &journal{path: path}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// defaultDataDir returns the directory in which embc stores its data.
+func defaultDataDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(dir, "embc")
+}
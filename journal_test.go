@@ -0,0 +1,266 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.sia.tech/siad/types"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "swaps.json")
+	aj, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bj, err := openJournal(filepath.Join(t.TempDir(), "swaps.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, alice, bob := newTestSwappers()
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := aj.Record(swap, stageCreated)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := aj.Annotate(created.ID, "bob", "7 SF for 10 MS"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if _, err := bj.Record(swap, stageAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice will not finish a swap in which bob has moved part of her
+	// proceeds into her change fee
+	inflated := swap
+	inflated.ChangeFee = types.SiacoinPrecision.Div64(2)
+	if err := aj.CheckChangeFee(inflated); err == nil {
+		t.Fatal("expected inflated change fee to be rejected")
+	} else if err := aj.CheckChangeFee(swap); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := finishSwap(alice, &swap); err != nil {
+		t.Fatal(err)
+	}
+	broadcast, err := aj.Record(swap, stageBroadcast)
+	if err != nil {
+		t.Fatal(err)
+	} else if broadcast.ID != created.ID {
+		t.Fatal("finished swap was not matched to its created record")
+	} else if broadcast.Label != "bob" || broadcast.Notes != "7 SF for 10 MS" {
+		t.Fatal("annotations were lost:", broadcast.Label, broadcast.Notes)
+	} else if len(broadcast.History) != 2 {
+		t.Fatalf("expected 2 history events, got %v", len(broadcast.History))
+	}
+
+	// records should never move backwards
+	if r, err := aj.Record(swap, stageCreated); err != nil {
+		t.Fatal(err)
+	} else if r.Stage != stageBroadcast {
+		t.Fatalf("expected stage %q, got %q", stageBroadcast, r.Stage)
+	}
+
+	// recording an unchanged swap should not rewrite the journal
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := aj.Record(swap, stageBroadcast); err != nil {
+		t.Fatal(err)
+	} else if stat2, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if !stat2.ModTime().Equal(stat.ModTime()) || !os.SameFile(stat, stat2) {
+		t.Fatal("unchanged record rewrote the journal")
+	}
+
+	// refreshing should pick up the broadcast and confirmation
+	if err := bj.Refresh(bob); err != nil {
+		t.Fatal(err)
+	} else if r := bj.Swaps()[0]; r.Stage != stageBroadcast {
+		t.Fatalf("expected stage %q, got %q", stageBroadcast, r.Stage)
+	}
+	c.mine()
+	if err := aj.Refresh(alice); err != nil {
+		t.Fatal(err)
+	}
+
+	// reload from disk
+	aj, err = openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := aj.Swap(created.ID[:6])
+	if err != nil {
+		t.Fatal(err)
+	} else if r.Stage != stageConfirmed {
+		t.Fatalf("expected stage %q, got %q", stageConfirmed, r.Stage)
+	} else if r.TxnID != swap.transaction().ID() {
+		t.Fatal("record has wrong transaction ID")
+	}
+	if _, err := aj.Swap(r.TxnID.String()); err != nil {
+		t.Fatal(err)
+	} else if _, err := aj.Swap("zz"); err == nil {
+		t.Fatal("expected lookup of unknown swap to fail")
+	}
+}
+
+func TestReservations(t *testing.T) {
+	j, err := openJournal(filepath.Join(t.TempDir(), "swaps.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, alice, bob := newTestSwappers()
+	for i := 0; i < 3; i++ {
+		addr, _ := alice.Address()
+		c.fund(addr, types.SiacoinPrecision.Mul64(1000), types.ZeroCurrency)
+	}
+
+	// create four swaps concurrently; each needs its own output
+	create := func() (SwapRecord, error) {
+		return j.FundSwap(alice, stageCreated, func(w Wallet) (SwapTransaction, error) {
+			return createSwap(w, Basket{SC: types.SiacoinPrecision.Mul64(900)}, Basket{SF: types.NewCurrency64(1)}, testMinerFee, feePayerSC, fundingOptions{})
+		})
+	}
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			_, err := create()
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	records := j.Swaps()
+	for i := range records {
+		for k := range records[:i] {
+			if sharesInputs(records[i].Swap, records[k].Swap) {
+				t.Fatal("concurrent swaps selected the same outputs")
+			}
+		}
+	}
+	if _, err := create(); err == nil {
+		t.Fatal("expected create to fail with every output reserved")
+	}
+
+	// reservations should be released once they expire
+	j.update(func() (bool, error) {
+		j.records[0].History[0].Timestamp = j.records[0].History[0].Timestamp.Add(-reservationPeriod - time.Minute)
+		return true, nil
+	})
+	r, err := create()
+	if err != nil {
+		t.Fatal(err)
+	} else if !sharesInputs(r.Swap, records[0].Swap) {
+		t.Fatal("expected new swap to reuse expired reservation")
+	}
+
+	// reservations should survive a restart
+	j, err = openJournal(j.path)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := create(); err == nil {
+		t.Fatal("expected reservations to persist")
+	}
+
+	// once a swap is broadcast, its outputs are spent and no longer reserved
+	swap := records[1].Swap
+	if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(alice, &swap); err != nil {
+		t.Fatal(err)
+	}
+	recordSwap(j, swap, stageBroadcast)
+	if reserved := j.reserved(); reserved[types.OutputID(swap.SiacoinInputs[0].ParentID)] {
+		t.Fatal("broadcast swap should not reserve its inputs")
+	}
+
+	// cancelled swaps are detected on refresh and release their reservations
+	cancelled := records[2].Swap
+	if _, err := cancelSwap(alice, cancelled); err != nil {
+		t.Fatal(err)
+	} else if err := j.Refresh(alice); err != nil {
+		t.Fatal(err)
+	} else if r, err := j.Swap(records[2].ID); err != nil {
+		t.Fatal(err)
+	} else if r.Stage != stageCancelled {
+		t.Fatalf("expected stage %q, got %q", stageCancelled, r.Stage)
+	} else if j.reserved()[types.OutputID(cancelled.SiacoinInputs[0].ParentID)] {
+		t.Fatal("cancelled swap should not reserve its inputs")
+	}
+}
+
+func TestSharedJournal(t *testing.T) {
+	// two journals on the same file stand in for two embc processes, e.g.
+	// the web server and the CLI
+	path := filepath.Join(t.TempDir(), "swaps.json")
+	aj, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bj, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, alice, _ := newTestSwappers()
+	for i := 0; i < 3; i++ {
+		addr, _ := alice.Address()
+		c.fund(addr, types.SiacoinPrecision.Mul64(1000), types.ZeroCurrency)
+	}
+
+	// concurrent swaps in either process never select the same outputs
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		j := aj
+		if i%2 == 1 {
+			j = bj
+		}
+		go func() {
+			_, err := j.FundSwap(alice, stageCreated, func(w Wallet) (SwapTransaction, error) {
+				return createSwap(w, Basket{SC: types.SiacoinPrecision.Mul64(900)}, Basket{SF: types.NewCurrency64(1)}, testMinerFee, feePayerSC, fundingOptions{})
+			})
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// neither process loses the other's records
+	records := aj.Swaps()
+	if len(records) != 4 || len(bj.Swaps()) != 4 {
+		t.Fatalf("expected 4 records in each journal, got %v and %v", len(records), len(bj.Swaps()))
+	}
+	for i := range records {
+		for k := range records[:i] {
+			if sharesInputs(records[i].Swap, records[k].Swap) {
+				t.Fatal("concurrent swaps selected the same outputs")
+			}
+		}
+	}
+	if _, err := aj.Annotate(records[0].ID, "foo", ""); err != nil {
+		t.Fatal(err)
+	} else if _, err := bj.Annotate(records[1].ID, "bar", ""); err != nil {
+		t.Fatal(err)
+	}
+	j, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	} else if r, _ := j.Swap(records[0].ID); r.Label != "foo" {
+		t.Fatal("annotation was lost")
+	} else if r, _ := j.Swap(records[1].ID); r.Label != "bar" {
+		t.Fatal("annotation was lost")
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"log"
+	"os"
+	"path/filepath"
 
-	"go.sia.tech/siad/node/api/client"
+	"go.sia.tech/siad/types"
 	"lukechampine.com/flagg"
 )
 
@@ -14,13 +16,48 @@ var (
 Run 'embc' with no arguments to open a web UI in your browser.
 Alternatively, use the actions below to conduct a swap via the CLI.
 
+By default, embc uses the wallet of the siad node given by -siad. With
+-wallet seed, embc instead derives keys from a wallet seed, read from
+-seed-file or prompted for, and signs locally; siad is then used only for its
+consensus set and transaction pool, so its own wallet may be locked or empty.
+The first run with a seed scans the blockchain for the seed's outputs.
+
+With -wallet renterd, embc uses the wallet of the renterd node whose bus API
+is given by -renterd, reading its API password from RENTERD_API_PASSWORD.
+renterd's wallet holds only siacoins, and must have spent from its address
+at least once, since embc learns the wallet's public key from that
+transaction.
+
+With -wallet hostd, embc uses the wallet of the hostd node whose API is given
+by -hostd, reading its API password from HOSTD_API_PASSWORD. hostd cannot sign
+or broadcast transactions built by embc, so -signer is required, and swaps are
+broadcast through the siad node given by -siad. Like renterd's, hostd's wallet
+holds only siacoins and must have spent from its address at least once.
+
+To keep keys out of embc entirely, pass -signer. Transactions are then signed
+by an external signer: either a command, run with a JSON signing request on
+its stdin, or an HTTP endpoint that the request is POSTed to. The endpoint must
+be on a loopback address or a unix socket (unix:///path/to/socket); a signer
+elsewhere sees every transaction you sign, so it is only accepted with
+-signer-remote. The signer replies with the signatures, which embc checks
+before using.
+
 Actions:
 	create        create a swap transaction
 	accept        accept a swap transaction
 	finish        sign + broadcast a swap transaction
+	sign          sign a signing request on an offline machine
+	import        import offline signatures to complete a swap step
+	cancel        cancel an outstanding swap transaction
+	batch         conduct a swap between more than two parties
+	whitelist     manage addresses that may receive swap proceeds
+	key           print your public key for encrypted swap files
+	relay         run a mailbox server through which swaps are exchanged
+	list          list recorded swaps
+	show          show a recorded swap
 `
 	createUsage = `Usage:
-embc create [ours] [theirs]
+embc create [flags] [ours] [theirs]
 
 Creates a transaction that swaps SC for SF, or vice versa. For example:
 
@@ -30,22 +67,267 @@ creates a transaction that swaps your 7 MS for the counterparty's 2 SF.
 The transaction is unsigned, and only contains inputs from your wallet.
 The counterparty must add their own inputs with 'embc accept' before the
 transaction can be signed and broadcast.
+
+Either side of the swap may combine SC and SF with '+'. For example:
+
+	embc create 2SF+1KS 15MS
+
+swaps your 2 SF and 1 KS for the counterparty's 15 MS.
+
+The miner fee is recorded in the transaction. If no fee is specified, it is
+estimated from siad's transaction pool. By default the party sending SC pays
+the fee; use -fee-payer to have the party sending SF pay it ('sf') or to split
+it evenly ('split'). When both parties send SC, the party sending more SC is
+the SC party. A party's share of the fee is deducted from the SC they
+receive, if any.
+
+Use -coin-selection to choose which of your outputs fund the swap, and -dust to
+add small amounts of siacoin change to the miner fee rather than creating a
+change output. Outputs that are unconfirmed or already being spent in the
+transaction pool are never used unless -allow-unconfirmed is set, which chains
+the swap off of unconfirmed outputs such as the change from a previous swap.
+
+Use -to to pay what you receive to specific addresses instead of a new wallet
+address, e.g. -to <addr>=1SF,<addr>=1SF. Any remainder is paid to your wallet.
+Each address must belong to your wallet or be whitelisted with
+'embc whitelist'.
+
+With -open, the swap is signed immediately as an open offer, covering only your
+inputs, your proceeds, and the miner fee. Anyone with the file can then fill
+the offer with 'embc accept', which signs and broadcasts it in one step. Add
+-offline to export the offer's signatures as a signing request for 'embc sign'
+instead of signing with siad's wallet.
+
+The swap file records the network and miner fee of the swap, along with a
+memo set by -memo. It can no longer be accepted or finished once -expiry
+blocks have passed. The swap is also printed as a compact line of text that can
+be pasted in place of the file, and with -qr, as a QR code.
+
+The swap file exposes your addresses, outputs, and amounts to anyone who sees
+it. To keep it private, use -encrypt passphrase to encrypt it with a
+passphrase, which is prompted for and must be shared with the counterparty
+separately, or -encrypt <key> to encrypt it with the public key that the
+counterparty prints with 'embc key'.
+
+With -relay, the swap is posted to a new mailbox on the given relay (see
+'embc relay') instead of being written to a file. The counterparty passes the
+mailbox URL to 'embc accept', which posts their response back to the same
+mailbox, and 'embc finish' with the URL waits for that response.
+
+With -share and -relay, a short code such as 7-crossbow-tunic is printed
+instead. Tell it to the counterparty, who runs 'embc accept -relay <url>
+<code>'. The two sides derive a shared key from the code and exchange every
+stage of the swap through the relay, encrypted with that key, so that the
+swap is finished without further steps and the relay cannot read it. A code
+can be used only once; if anyone else tries it, the pairing fails. If the
+counterparty does not enter the code within 15 minutes, it is discarded.
 `
 	acceptUsage = `Usage:
 embc accept [file_path]
+embc accept -from [url]
+embc accept -relay [url] [code]
 
-Displays a proposed swap transaction. If you accept the proposal, your inputs
-will be added to complete the swap. The resulting transaction must be returned
-to the original party and countersigned with 'embc finish' before it is valid
-and ready for broadcasting.
+Displays a proposed swap transaction, read from a file, from the text
+printed alongside it (beginning with 'embc:'), or from a relay or peer mailbox.
+If you accept the proposal, your inputs will be added to complete the swap.
+The resulting transaction must be returned to the original party and
+countersigned with 'embc finish' before it is valid and ready for
+broadcasting. Given a mailbox URL, the result is posted back to the mailbox.
+
+If the proposal is an open offer, accepting it fills the offer: your inputs
+are added and signed, and the transaction is broadcast immediately.
+
+Use -to to pay what you receive to specific addresses, as with 'embc create'.
+
+Given a code printed by 'embc create -share', such as 7-crossbow-tunic, and
+the relay it was created with, you are paired with the creator through the
+relay, and the swap is received, accepted, and returned to them encrypted.
+The command then waits for the creator to broadcast the swap.
+
+Encrypted swap files are decrypted with your key, or with a passphrase that is
+prompted for. Use -encrypt to encrypt the accepted swap, as with
+'embc create'.
+
+With -offline, your inputs are added but not signed. Instead, a signing request
+is exported for 'embc sign', and the accepted swap is written once the
+signatures are brought back with 'embc import'.
 `
 
 	finishUsage = `Usage:
 embc finish [file_path]
+embc finish -from [url]
+
+Displays a proposed swap transaction, read from a file, from the text
+printed alongside it (beginning with 'embc:'), or from a relay or peer mailbox,
+decrypting it if necessary. Given a mailbox URL, finish waits for the
+counterparty to post their accepted swap.
+If you accept the proposal, your signatures will be added, finalizing the
+transaction. The transaction is then broadcast.
+
+With -offline, a signing request is exported for 'embc sign' instead, and the
+transaction is broadcast once the signatures are brought back with
+'embc import'.
+`
+
+	signUsage = `Usage:
+embc sign [file_path]
+
+Signs a signing request exported with -offline by 'embc create', 'embc accept',
+or 'embc finish'. This command is intended to run on an air-gapped machine: it
+needs no siad node, only the seed of the wallet that owns the swap's inputs,
+which is read from -seed-file or prompted for. The request is checked against
+its transaction before anything is signed, and the signatures are written to a
+file that must be returned to the online machine.
+`
+
+	importUsage = `Usage:
+embc import [request_file] [signatures_file]
+
+Imports the signatures produced by 'embc sign' into the swap of a signing
+request, verifying each one, and completes the step that exported it: the swap
+is either written to a file for the counterparty or broadcast.
+`
+
+	whitelistUsage = `Usage:
+embc whitelist [addr...]
+
+Adds addresses to the whitelist, or lists the whitelist if none are given.
+Swap proceeds may only be paid to addresses that belong to your wallet or that
+are whitelisted, such as cold storage addresses. When checking a swap, embc
+counts outputs to whitelisted addresses toward what you receive; they are
+never spent from or used for change. Use -remove to remove addresses from
+the whitelist.
+
+The web UI's API can change the whitelist only if EMBC_API_PASSWORD is set,
+and only for requests that supply it as their HTTP basic auth password.
+`
+
+	coinSelectionUsage    = "coin selection strategy: 'first', 'largest', 'smallest', 'exact', or 'privacy'"
+	dustUsage             = "siacoin change below this amount, e.g. 10mS, is added to the miner fee (at most 1SC)"
+	allowUnconfirmedUsage = "fund the swap with unconfirmed outputs, e.g. the change from a previous swap"
+	toUsage               = "pay proceeds to these addresses, e.g. <addr>=1SF,<addr>=10KS"
+	offlineUsage          = "export a signing request for 'embc sign' instead of signing"
+	qrUsage               = "also print the swap as a QR code"
+	fromUsage             = "URL of a peer or relay mailbox to pull the swap from and post the result back to"
+	encryptUsage          = "encrypt the swap file: 'passphrase', or the counterparty's public key from 'embc key'"
+
+	relayUsage = `Usage:
+embc relay
+
+Runs a mailbox server that stores swap files, so that the parties to a swap
+can exchange its stages without passing files back and forth. 'embc create
+-relay <url>' creates a mailbox under a random ID and posts the swap to it;
+'embc accept' and 'embc finish' take the mailbox URL in place of a file path,
+pulling the latest stage from it and posting their result back. The relay
+needs no wallet or siad node. Mailboxes are kept in memory for 24 hours. To
+bound its memory, the relay stores at most 64 MiB in total and 64 KiB per
+message, and limits how quickly each client may create and post to
+mailboxes.
+
+Anyone who knows a mailbox URL can read its contents, so use -encrypt when
+exchanging swaps through a relay you do not control. Alternatively, 'embc
+create -share' pairs the parties with a short code through a nameplate on the
+relay, and encrypts every stage with a key derived from the code.
+
+The embc web server can also serve a relay, so when the counterparty runs it,
+swaps can be exchanged with them directly. Started with -peer-addr, the server
+serves mailboxes under /api/peer on that address, and nothing else; the web UI
+and wallet API stay on -addr, which must be a loopback address. Then
+
+	embc create -relay http://peer:9988/api/peer 7MS 2SF
+
+posts the swap to a mailbox on the peer's server, where they can accept it,
+and 'embc finish -from <mailbox-url>' pulls their response. Set -peer-url if
+peers reach -peer-addr under another URL, e.g. through a proxy.
+`
+
+	keyUsage = `Usage:
+embc key
+
+Prints your X25519 public key. Counterparties can pass it to -encrypt so that
+only you can read the swap files they send you. The private key is stored in
+the data directory, and is generated the first time it is needed.
+`
+
+	cancelUsage = `Usage:
+embc cancel [file_path]
+
+Cancels an outstanding swap by spending your inputs to it back to your own
+wallet. Once this transaction confirms, the swap transaction is invalid and can
+never be broadcast, even if the counterparty has already signed it. If your
+inputs do not cover the miner fee, additional siacoins are added from your
+wallet. A swap cannot be cancelled after it has been broadcast.
+
+After broadcasting the cancellation, embc waits up to three hours for it to
+confirm, stopping early if the swap transaction confirms first or the
+cancellation leaves the transaction pool.
+`
+
+	batchUsage = `Usage:
+embc batch [action]
+
+Conducts a swap between any number of participants in a single transaction.
+Each participant agrees to send and receive fixed amounts; the siacoins sent,
+less the siacoins received, pay the miner fee.
+
+Actions:
+	create        create a batch swap from each participant's terms
+	join          add your inputs and outputs to a batch swap
+	sign          sign a batch swap that every participant has joined
+	merge         combine the signatures of several copies of a batch swap
+`
+	batchCreateUsage = `Usage:
+embc batch create [terms...]
+
+Creates a batch swap. Each participant's terms are written as
+name=send:receive. For example:
+
+	embc batch create alice=7MS:2SF bob=1SF:3MS carol=1SF:3.999MS
+
+creates a batch in which alice sends 7 MS for 2 SF, and bob and carol each
+send 1 SF for 3 MS and 3.999 MS respectively, leaving 1 KS as the miner fee.
+Either amount may be empty, and amounts of SC and SF may be combined with '+',
+e.g. dave=1SF+2MS:.
+
+The batch file must then be passed to each participant in turn to join.
+`
+	batchJoinUsage = `Usage:
+embc batch join [name] [file_path]
+
+Joins a batch swap as the named participant, adding inputs from your wallet to
+fund what you send and outputs paying what you receive, plus any change. Dust
+is never added to the miner fee, which is fixed by the participants' terms.
+Use -to to pay what you receive to specific addresses, as with 'embc create'.
+`
+	batchSignUsage = `Usage:
+embc batch sign [name] [file_path]
 
-Displays a proposed swap transaction. If you accept the proposal, your
-signatures will be added, finalizing the transaction. The transaction is then
-broadcast.
+Signs your inputs to a batch swap after checking that, across the entire
+transaction, your wallet sends and receives exactly what the named
+participant's terms specify. Once every input is signed, the transaction is
+broadcast. Participants may sign the same file in turn, or sign separate
+copies that are combined with 'embc batch merge'.
+`
+	batchMergeUsage = `Usage:
+embc batch merge [file_path...]
+
+Combines the signatures of several copies of the same batch swap. If the
+result is fully signed, it is broadcast.
+`
+
+	listUsage = `Usage:
+embc list
+
+Lists every swap recorded in the local swap journal, along with its current
+stage. Swaps are recorded each time they are created, accepted, or finished.
+`
+
+	showUsage = `Usage:
+embc show [id]
+
+Displays the details and history of a recorded swap. The ID may be a swap ID
+from 'embc list' or a transaction ID, and may be abbreviated to any unique
+prefix.
 `
 )
 
@@ -54,13 +336,72 @@ func main() {
 
 	rootCmd := flagg.Root
 	rootCmd.Usage = flagg.SimpleUsage(rootCmd, rootUsage)
-	webAddr := rootCmd.String("addr", "localhost:8080", "HTTP service address")
+	webAddr := rootCmd.String("addr", "localhost:8080", "HTTP service address for the web UI and wallet API; must be a loopback address")
+	peerAddr := rootCmd.String("peer-addr", "", "address on which to serve mailboxes for exchanging swaps with peers directly (disabled if empty)")
 	siadAddr := rootCmd.String("siad", "localhost:9980", "host:port that the siad API is running on")
 	dev := rootCmd.Bool("dev", false, "run in dev mode")
+	walletType := rootCmd.String("wallet", "siad", "wallet to use: 'siad', 'seed', 'renterd', or 'hostd'")
+	renterdAddr := rootCmd.String("renterd", "http://localhost:9980/api/bus", "URL of the renterd bus API, for -wallet renterd")
+	hostdAddr := rootCmd.String("hostd", "http://localhost:9980/api", "URL of the hostd API, for -wallet hostd")
+	seedFile := rootCmd.String("seed-file", "", "file containing the wallet seed, for -wallet seed (prompted for if not set)")
+	signer := rootCmd.String("signer", "", "external signer to sign with: a command, the URL of a local HTTP endpoint, or unix:///path/to/socket")
+	signerRemote := rootCmd.Bool("signer-remote", false, "allow -signer to be an HTTP endpoint on another host")
+	peerURL := rootCmd.String("peer-url", "", "URL at which peers can reach -peer-addr (defaults to http://<peer-addr>)")
+	dataDir := rootCmd.String("dir", defaultDataDir(), "directory in which to store the swap journal, address whitelist, and seed wallet")
 
 	createCmd := flagg.New("create", createUsage)
+	createFee := createCmd.String("fee", "", "miner fee, e.g. 500mS (defaults to the transaction pool's estimate)")
+	createFeePayer := createCmd.String("fee-payer", feePayerSC, "which party pays the miner fee: 'sc', 'sf', or 'split'")
+	createStrategy := createCmd.String("coin-selection", selectFirst, coinSelectionUsage)
+	createDust := createCmd.String("dust", "", dustUsage)
+	createUnconfirmed := createCmd.Bool("allow-unconfirmed", false, allowUnconfirmedUsage)
+	createTo := createCmd.String("to", "", toUsage)
+	createOpen := createCmd.Bool("open", false, "sign the swap as an open offer that anyone can fill")
+	createOffline := createCmd.Bool("offline", false, offlineUsage)
+	createExpiry := createCmd.Uint64("expiry", defaultSwapExpiry, "number of blocks for which the swap file is valid, or 0 for no expiry")
+	createMemo := createCmd.String("memo", "", "memo to include in the swap file for the counterparty")
+	createLabel := createCmd.String("label", "", "label to record with the swap")
+	createNotes := createCmd.String("notes", "", "notes to record with the swap")
+	createQR := createCmd.Bool("qr", false, qrUsage)
+	createEncrypt := createCmd.String("encrypt", "", encryptUsage)
+	createRelay := createCmd.String("relay", "", "URL of a relay to post the swap to, instead of writing a file")
+	createShare := createCmd.Bool("share", false, "pair with the counterparty through -relay using a short code, and conduct the swap automatically")
 	acceptCmd := flagg.New("accept", acceptUsage)
+	acceptStrategy := acceptCmd.String("coin-selection", selectFirst, coinSelectionUsage)
+	acceptDust := acceptCmd.String("dust", "", dustUsage)
+	acceptUnconfirmed := acceptCmd.Bool("allow-unconfirmed", false, allowUnconfirmedUsage)
+	acceptTo := acceptCmd.String("to", "", toUsage)
+	acceptOffline := acceptCmd.Bool("offline", false, offlineUsage)
+	acceptLabel := acceptCmd.String("label", "", "label to record with the swap")
+	acceptNotes := acceptCmd.String("notes", "", "notes to record with the swap")
+	acceptQR := acceptCmd.Bool("qr", false, qrUsage)
+	acceptEncrypt := acceptCmd.String("encrypt", "", encryptUsage)
+	acceptFrom := acceptCmd.String("from", "", fromUsage)
+	acceptRelay := acceptCmd.String("relay", "", "URL of the relay to pair through, when accepting a swap by its code")
 	finishCmd := flagg.New("finish", finishUsage)
+	finishOffline := finishCmd.Bool("offline", false, offlineUsage)
+	finishQR := finishCmd.Bool("qr", false, qrUsage)
+	finishFrom := finishCmd.String("from", "", fromUsage)
+	signCmd := flagg.New("sign", signUsage)
+	signSeedFile := signCmd.String("seed-file", "", "file containing the wallet seed (prompted for if not set)")
+	importCmd := flagg.New("import", importUsage)
+	importQR := importCmd.Bool("qr", false, qrUsage)
+	cancelCmd := flagg.New("cancel", cancelUsage)
+	batchCmd := flagg.New("batch", batchUsage)
+	batchCreateCmd := flagg.New("create", batchCreateUsage)
+	batchJoinCmd := flagg.New("join", batchJoinUsage)
+	batchStrategy := batchJoinCmd.String("coin-selection", selectFirst, coinSelectionUsage)
+	batchUnconfirmed := batchJoinCmd.Bool("allow-unconfirmed", false, allowUnconfirmedUsage)
+	batchTo := batchJoinCmd.String("to", "", toUsage)
+	batchSignCmd := flagg.New("sign", batchSignUsage)
+	batchMergeCmd := flagg.New("merge", batchMergeUsage)
+	whitelistCmd := flagg.New("whitelist", whitelistUsage)
+	whitelistRemove := whitelistCmd.Bool("remove", false, "remove the addresses from the whitelist")
+	keyCmd := flagg.New("key", keyUsage)
+	relayCmd := flagg.New("relay", relayUsage)
+	relayAddr := relayCmd.String("addr", ":8081", "address on which the relay listens")
+	listCmd := flagg.New("list", listUsage)
+	showCmd := flagg.New("show", showUsage)
 
 	cmd := flagg.Parse(flagg.Tree{
 		Cmd: rootCmd,
@@ -68,35 +409,219 @@ func main() {
 			{Cmd: createCmd},
 			{Cmd: acceptCmd},
 			{Cmd: finishCmd},
+			{Cmd: signCmd},
+			{Cmd: importCmd},
+			{Cmd: cancelCmd},
+			{
+				Cmd: batchCmd,
+				Sub: []flagg.Tree{
+					{Cmd: batchCreateCmd},
+					{Cmd: batchJoinCmd},
+					{Cmd: batchSignCmd},
+					{Cmd: batchMergeCmd},
+				},
+			},
+			{Cmd: whitelistCmd},
+			{Cmd: keyCmd},
+			{Cmd: relayCmd},
+			{Cmd: listCmd},
+			{Cmd: showCmd},
 		},
 	})
 	args := cmd.Args()
 
-	// initialize client
-	opts, _ := client.DefaultOptions()
-	opts.Address = *siadAddr
-	siad = client.New(opts)
+	// each command loads only what it needs, so that offline commands such
+	// as sign neither touch the data directory nor contact a node
+	loadWhitelist := func() *whitelist {
+		wl, err := openWhitelist(filepath.Join(*dataDir, "whitelist.json"))
+		if err != nil {
+			log.Fatal("Failed to open address whitelist: ", err)
+		}
+		return wl
+	}
+	loadBackend := func() whitelistBackend {
+		siad := newSiadBackend(*siadAddr)
+		var wallet Backend = siad
+		switch *walletType {
+		case "siad":
+		case "seed":
+			log.Println("Scanning siad's consensus set for the seed's outputs...")
+			var err error
+			wallet, err = openSeedBackend(siad, readSeed(*seedFile), *dataDir)
+			if err != nil {
+				log.Fatal("Failed to open seed wallet: ", err)
+			}
+		case "renterd":
+			wallet = newRenterdBackend(*renterdAddr, os.Getenv("RENTERD_API_PASSWORD"))
+		case "hostd":
+			if *signer == "" {
+				log.Fatal("hostd cannot sign transactions built by embc; pass -signer to sign them with an external signer")
+			}
+			wallet = newHostdBackend(*hostdAddr, os.Getenv("HOSTD_API_PASSWORD"), siad)
+		default:
+			log.Fatalf("Unknown wallet %q; must be 'siad', 'seed', 'renterd', or 'hostd'", *walletType)
+		}
+		wallet, err := signerBackend(wallet, *signer, *signerRemote)
+		if err != nil {
+			log.Fatal("Invalid external signer: ", err)
+		}
+		// renterd and hostd do not report their wallet's public key, which
+		// every input added to a swap needs, so check that it is known before
+		// building a swap rather than partway through
+		if *walletType == "renterd" || *walletType == "hostd" {
+			switch cmd {
+			case rootCmd:
+				if err := checkWalletKey(wallet); err != nil {
+					log.Println("Warning: swaps cannot be funded from this wallet:", err)
+				}
+			case createCmd, acceptCmd, batchJoinCmd:
+				if err := checkWalletKey(wallet); err != nil {
+					log.Fatal("Cannot fund a swap from this wallet: ", err)
+				}
+			}
+		}
+		return whitelistBackend{wallet, loadWhitelist()}
+	}
+	loadJournal := func() *journal {
+		j, err := openJournal(filepath.Join(*dataDir, "swaps.json"))
+		if err != nil {
+			log.Fatal("Failed to open swap journal: ", err)
+		}
+		return j
+	}
+	loadKey := func() x25519Key {
+		key, err := loadX25519Key(filepath.Join(*dataDir, "x25519.key"))
+		if err != nil {
+			log.Fatal("Failed to load X25519 key: ", err)
+		}
+		return key
+	}
 
 	switch cmd {
 	case rootCmd:
-		serve(*webAddr, *dev)
+		b := loadBackend()
+		serve(b, loadJournal(), b.whitelist, os.Getenv("EMBC_API_PASSWORD"), loadKey(), *webAddr, *peerAddr, *peerURL, *dev)
 	case createCmd:
 		if len(args) != 2 {
 			cmd.Usage()
 			return
 		}
-		createCLI(args[0], args[1])
+		createCLI(loadBackend(), loadJournal(), args[0], args[1], createOptions{
+			fee:              *createFee,
+			feePayer:         *createFeePayer,
+			strategy:         *createStrategy,
+			dust:             *createDust,
+			allowUnconfirmed: *createUnconfirmed,
+			to:               *createTo,
+			open:             *createOpen,
+			offline:          *createOffline,
+			expiry:           types.BlockHeight(*createExpiry),
+			memo:             *createMemo,
+			label:            *createLabel,
+			notes:            *createNotes,
+			encrypt:          *createEncrypt,
+			relay:            *createRelay,
+			share:            *createShare,
+			qr:               *createQR,
+		})
 	case acceptCmd:
+		if *acceptFrom != "" && len(args) == 0 {
+			args = []string{*acceptFrom}
+		}
 		if len(args) != 1 {
 			cmd.Usage()
 			return
 		}
-		acceptCLI(args[0])
+		acceptCLI(loadBackend(), loadJournal(), loadKey(), args[0], acceptOptions{
+			strategy:         *acceptStrategy,
+			dust:             *acceptDust,
+			allowUnconfirmed: *acceptUnconfirmed,
+			to:               *acceptTo,
+			offline:          *acceptOffline,
+			label:            *acceptLabel,
+			notes:            *acceptNotes,
+			encrypt:          *acceptEncrypt,
+			relay:            *acceptRelay,
+			qr:               *acceptQR,
+		})
 	case finishCmd:
+		if *finishFrom != "" && len(args) == 0 {
+			args = []string{*finishFrom}
+		}
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		finishCLI(loadBackend(), loadJournal(), loadKey(), args[0], *finishOffline, *finishQR)
+	case signCmd:
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		signCLI(args[0], *signSeedFile)
+	case importCmd:
+		if len(args) != 2 {
+			cmd.Usage()
+			return
+		}
+		importCLI(loadBackend(), loadJournal(), args[0], args[1], *importQR)
+	case cancelCmd:
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		cancelCLI(loadBackend(), loadJournal(), loadKey(), args[0])
+	case batchCmd:
+		cmd.Usage()
+	case batchCreateCmd:
+		if len(args) < 2 {
+			cmd.Usage()
+			return
+		}
+		batchCreateCLI(loadBackend(), args)
+	case batchJoinCmd:
+		if len(args) != 2 {
+			cmd.Usage()
+			return
+		}
+		batchJoinCLI(loadBackend(), loadJournal(), args[0], args[1], *batchStrategy, "", *batchUnconfirmed, *batchTo)
+	case batchSignCmd:
+		if len(args) != 2 {
+			cmd.Usage()
+			return
+		}
+		batchSignCLI(loadBackend(), loadJournal(), args[0], args[1])
+	case batchMergeCmd:
+		if len(args) == 0 {
+			cmd.Usage()
+			return
+		}
+		batchMergeCLI(loadBackend(), loadJournal(), args)
+	case whitelistCmd:
+		whitelistCLI(loadWhitelist(), args, *whitelistRemove)
+	case keyCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		keyCLI(loadKey())
+	case relayCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		runRelay(*relayAddr)
+	case listCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		listCLI(loadBackend(), loadJournal())
+	case showCmd:
 		if len(args) != 1 {
 			cmd.Usage()
 			return
 		}
-		finishCLI(args[0])
+		showCLI(loadBackend(), loadJournal(), args[0])
 	}
 }
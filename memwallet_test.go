@@ -0,0 +1,517 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/node/api"
+	"go.sia.tech/siad/types"
+)
+
+var errLocked = errors.New("wallet must be unlocked before it can be used")
+
+const unconfirmedHeight = types.BlockHeight(math.MaxUint64)
+
+// memChain is an in-memory blockchain and transaction pool shared by one or
+// more memWallets.
+type memChain struct {
+	mu        sync.Mutex
+	height    types.BlockHeight
+	scOutputs map[types.SiacoinOutputID]types.SiacoinOutput
+	sfOutputs map[types.SiafundOutputID]types.SiafundOutput
+	created   map[types.OutputID]types.BlockHeight
+	txns      map[types.TransactionID]modules.ProcessedTransaction
+	tpool     []types.Transaction
+	nonce     uint64
+	feeRate   types.Currency
+
+	// rejectBroadcast, if set, is returned by BroadcastTransaction.
+	rejectBroadcast error
+}
+
+// spentInPool returns the set of outputs spent by transactions in the pool.
+func (c *memChain) spentInPool() map[types.OutputID]bool {
+	spent := make(map[types.OutputID]bool)
+	for _, txn := range c.tpool {
+		for _, sci := range txn.SiacoinInputs {
+			spent[types.OutputID(sci.ParentID)] = true
+		}
+		for _, sfi := range txn.SiafundInputs {
+			spent[types.OutputID(sfi.ParentID)] = true
+		}
+	}
+	return spent
+}
+
+// poolOutputs returns the outputs created by transactions in the pool.
+func (c *memChain) poolOutputs() (map[types.SiacoinOutputID]types.SiacoinOutput, map[types.SiafundOutputID]types.SiafundOutput) {
+	scos := make(map[types.SiacoinOutputID]types.SiacoinOutput)
+	sfos := make(map[types.SiafundOutputID]types.SiafundOutput)
+	for _, txn := range c.tpool {
+		for i, sco := range txn.SiacoinOutputs {
+			scos[txn.SiacoinOutputID(uint64(i))] = sco
+		}
+		for i, sfo := range txn.SiafundOutputs {
+			sfos[txn.SiafundOutputID(uint64(i))] = sfo
+		}
+	}
+	return scos, sfos
+}
+
+// validate checks that txn could be included in the next block, possibly
+// after its parents in the pool.
+func (c *memChain) validate(txn types.Transaction) error {
+	if err := txn.StandaloneValid(c.height); err != nil {
+		return err
+	}
+	spent := c.spentInPool()
+	poolSC, poolSF := c.poolOutputs()
+	var scIn, scOut, sfIn, sfOut types.Currency
+	for _, sci := range txn.SiacoinInputs {
+		sco, ok := c.scOutputs[sci.ParentID]
+		if !ok {
+			sco, ok = poolSC[sci.ParentID]
+		}
+		if !ok {
+			return errors.New("transaction spends a nonexisting siacoin output")
+		} else if spent[types.OutputID(sci.ParentID)] {
+			return errors.New("transaction spends a siacoin output already spent in the transaction pool")
+		} else if sci.UnlockConditions.UnlockHash() != sco.UnlockHash {
+			return errors.New("transaction has invalid unlock conditions")
+		}
+		scIn = scIn.Add(sco.Value)
+	}
+	for _, sfi := range txn.SiafundInputs {
+		sfo, ok := c.sfOutputs[sfi.ParentID]
+		if !ok {
+			sfo, ok = poolSF[sfi.ParentID]
+		}
+		if !ok {
+			return errors.New("transaction spends a nonexisting siafund output")
+		} else if spent[types.OutputID(sfi.ParentID)] {
+			return errors.New("transaction spends a siafund output already spent in the transaction pool")
+		} else if sfi.UnlockConditions.UnlockHash() != sfo.UnlockHash {
+			return errors.New("transaction has invalid unlock conditions")
+		}
+		sfIn = sfIn.Add(sfo.Value)
+	}
+	for _, sco := range txn.SiacoinOutputs {
+		scOut = scOut.Add(sco.Value)
+	}
+	for _, fee := range txn.MinerFees {
+		scOut = scOut.Add(fee)
+	}
+	for _, sfo := range txn.SiafundOutputs {
+		sfOut = sfOut.Add(sfo.Value)
+	}
+	if !scIn.Equals(scOut) {
+		return errors.New("siacoin inputs do not equal siacoin outputs for transaction")
+	} else if !sfIn.Equals(sfOut) {
+		return errors.New("siafund inputs do not equal siafund outputs for transaction")
+	}
+	return nil
+}
+
+// BroadcastTransaction implements Chain.
+func (c *memChain) BroadcastTransaction(txn types.Transaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rejectBroadcast != nil {
+		return c.rejectBroadcast
+	} else if _, ok := c.txns[txn.ID()]; ok {
+		return modules.ErrDuplicateTransactionSet
+	} else if err := c.validate(txn); err != nil {
+		return err
+	}
+	c.tpool = append(c.tpool, txn)
+	c.txns[txn.ID()] = modules.ProcessedTransaction{
+		Transaction:        txn,
+		TransactionID:      txn.ID(),
+		ConfirmationHeight: unconfirmedHeight,
+	}
+	return nil
+}
+
+// Consensus implements Chain.
+func (c *memChain) Consensus() (api.ConsensusGET, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return api.ConsensusGET{
+		Synced: true,
+		Height: c.height,
+	}, nil
+}
+
+// PoolTransactions implements Chain.
+func (c *memChain) PoolTransactions() ([]types.Transaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]types.Transaction(nil), c.tpool...), nil
+}
+
+// FeeEstimate implements Chain.
+func (c *memChain) FeeEstimate() (min, max types.Currency, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.feeRate.Div64(3), c.feeRate, nil
+}
+
+// apply adds the outputs of txn to the chain and removes its inputs.
+func (c *memChain) apply(txn types.Transaction) {
+	for _, sci := range txn.SiacoinInputs {
+		delete(c.scOutputs, sci.ParentID)
+	}
+	for _, sfi := range txn.SiafundInputs {
+		delete(c.sfOutputs, sfi.ParentID)
+	}
+	for i, sco := range txn.SiacoinOutputs {
+		id := txn.SiacoinOutputID(uint64(i))
+		c.scOutputs[id] = sco
+		c.created[types.OutputID(id)] = c.height
+	}
+	for i, sfo := range txn.SiafundOutputs {
+		id := txn.SiafundOutputID(uint64(i))
+		c.sfOutputs[id] = sfo
+		c.created[types.OutputID(id)] = c.height
+	}
+	c.txns[txn.ID()] = modules.ProcessedTransaction{
+		Transaction:        txn,
+		TransactionID:      txn.ID(),
+		ConfirmationHeight: c.height,
+	}
+}
+
+// mine mines a block containing every transaction in the pool.
+func (c *memChain) mine() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.height++
+	for _, txn := range c.tpool {
+		c.apply(txn)
+	}
+	c.tpool = nil
+}
+
+// fund mines a block that creates new outputs paying sc and sf to addr.
+func (c *memChain) fund(addr types.UnlockHash, sc, sf types.Currency) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nonce++
+	txn := types.Transaction{
+		ArbitraryData: [][]byte{encodeNonce(c.nonce)},
+	}
+	if !sc.IsZero() {
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{Value: sc, UnlockHash: addr})
+	}
+	if !sf.IsZero() {
+		txn.SiafundOutputs = append(txn.SiafundOutputs, types.SiafundOutput{Value: sf, UnlockHash: addr})
+	}
+	c.height++
+	c.apply(txn)
+}
+
+// spend removes an output from the chain, simulating it being spent by a
+// transaction that the wallet does not know about.
+func (c *memChain) spend(id types.OutputID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.scOutputs, types.SiacoinOutputID(id))
+	delete(c.sfOutputs, types.SiafundOutputID(id))
+}
+
+func encodeNonce(n uint64) []byte {
+	b := make([]byte, 8)
+	for i := range b {
+		b[i] = byte(n >> (8 * i))
+	}
+	return b
+}
+
+func newMemChain() *memChain {
+	return &memChain{
+		height:    1,
+		feeRate:   types.SiacoinPrecision.Div64(1000),
+		scOutputs: make(map[types.SiacoinOutputID]types.SiacoinOutput),
+		sfOutputs: make(map[types.SiafundOutputID]types.SiafundOutput),
+		created:   make(map[types.OutputID]types.BlockHeight),
+		txns:      make(map[types.TransactionID]modules.ProcessedTransaction),
+	}
+}
+
+type memKey struct {
+	uc types.UnlockConditions
+	sk crypto.SecretKey
+}
+
+// memWallet is an in-memory wallet that implements Backend on top of a
+// memChain.
+type memWallet struct {
+	*memChain
+	keys   map[types.UnlockHash]memKey
+	addrs  []types.UnlockHash
+	locked bool
+
+	// unaware, if set, simulates a wallet that has not seen the transactions
+	// in the pool, e.g. because they were broadcast by another wallet using
+	// the same seed. Outputs spent in the pool are reported as unspent, and
+	// no unconfirmed transactions are reported.
+	unaware bool
+}
+
+// Address implements Wallet.
+func (w *memWallet) Address() (types.UnlockHash, error) {
+	if w.locked {
+		return types.UnlockHash{}, errLocked
+	}
+	sk, pk := crypto.GenerateKeyPair()
+	uc := types.UnlockConditions{
+		PublicKeys:         []types.SiaPublicKey{types.Ed25519PublicKey(pk)},
+		SignaturesRequired: 1,
+	}
+	addr := uc.UnlockHash()
+	w.keys[addr] = memKey{uc: uc, sk: sk}
+	w.addrs = append(w.addrs, addr)
+	return addr, nil
+}
+
+// Addresses implements Wallet.
+func (w *memWallet) Addresses() ([]types.UnlockHash, error) {
+	if w.locked {
+		return nil, errLocked
+	}
+	return append([]types.UnlockHash(nil), w.addrs...), nil
+}
+
+// UnspentOutputs implements Wallet.
+func (w *memWallet) UnspentOutputs() ([]modules.UnspentOutput, error) {
+	if w.locked {
+		return nil, errLocked
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	spent := w.spentInPool()
+	if w.unaware {
+		spent = nil
+	}
+	var outputs []modules.UnspentOutput
+	addSC := func(id types.SiacoinOutputID, sco types.SiacoinOutput, height types.BlockHeight) {
+		if _, ok := w.keys[sco.UnlockHash]; ok && !spent[types.OutputID(id)] {
+			outputs = append(outputs, modules.UnspentOutput{
+				ID:                 types.OutputID(id),
+				FundType:           types.SpecifierSiacoinOutput,
+				UnlockHash:         sco.UnlockHash,
+				Value:              sco.Value,
+				ConfirmationHeight: height,
+			})
+		}
+	}
+	addSF := func(id types.SiafundOutputID, sfo types.SiafundOutput, height types.BlockHeight) {
+		if _, ok := w.keys[sfo.UnlockHash]; ok && !spent[types.OutputID(id)] {
+			outputs = append(outputs, modules.UnspentOutput{
+				ID:                 types.OutputID(id),
+				FundType:           types.SpecifierSiafundOutput,
+				UnlockHash:         sfo.UnlockHash,
+				Value:              sfo.Value,
+				ConfirmationHeight: height,
+			})
+		}
+	}
+	for id, sco := range w.scOutputs {
+		addSC(id, sco, w.created[types.OutputID(id)])
+	}
+	for id, sfo := range w.sfOutputs {
+		addSF(id, sfo, w.created[types.OutputID(id)])
+	}
+	if !w.unaware {
+		poolSC, poolSF := w.poolOutputs()
+		for id, sco := range poolSC {
+			addSC(id, sco, unconfirmedHeight)
+		}
+		for id, sfo := range poolSF {
+			addSF(id, sfo, unconfirmedHeight)
+		}
+	}
+	return outputs, nil
+}
+
+// UnconfirmedTransactions implements Wallet.
+func (w *memWallet) UnconfirmedTransactions() ([]modules.ProcessedTransaction, error) {
+	if w.locked {
+		return nil, errLocked
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.unaware {
+		return nil, nil
+	}
+	var pts []modules.ProcessedTransaction
+	for _, txn := range w.tpool {
+		if !w.relevant(txn) {
+			continue
+		}
+		pt := modules.ProcessedTransaction{
+			Transaction:        txn,
+			TransactionID:      txn.ID(),
+			ConfirmationHeight: unconfirmedHeight,
+		}
+		for _, sci := range txn.SiacoinInputs {
+			_, ours := w.keys[sci.UnlockConditions.UnlockHash()]
+			pt.Inputs = append(pt.Inputs, modules.ProcessedInput{
+				ParentID:       types.OutputID(sci.ParentID),
+				FundType:       types.SpecifierSiacoinInput,
+				WalletAddress:  ours,
+				RelatedAddress: sci.UnlockConditions.UnlockHash(),
+			})
+		}
+		for _, sfi := range txn.SiafundInputs {
+			_, ours := w.keys[sfi.UnlockConditions.UnlockHash()]
+			pt.Inputs = append(pt.Inputs, modules.ProcessedInput{
+				ParentID:       types.OutputID(sfi.ParentID),
+				FundType:       types.SpecifierSiafundInput,
+				WalletAddress:  ours,
+				RelatedAddress: sfi.UnlockConditions.UnlockHash(),
+			})
+		}
+		pts = append(pts, pt)
+	}
+	return pts, nil
+}
+
+// UnlockConditions implements Wallet.
+func (w *memWallet) UnlockConditions(addr types.UnlockHash) (types.UnlockConditions, error) {
+	if w.locked {
+		return types.UnlockConditions{}, errLocked
+	}
+	key, ok := w.keys[addr]
+	if !ok {
+		return types.UnlockConditions{}, errors.New("no record of UnlockConditions for that UnlockHash")
+	}
+	return key.uc, nil
+}
+
+// SignTransaction implements Wallet.
+func (w *memWallet) SignTransaction(txn *types.Transaction, toSign []crypto.Hash) error {
+	if w.locked {
+		return errLocked
+	}
+	w.mu.Lock()
+	height := w.height
+	w.mu.Unlock()
+
+	ucs := make(map[crypto.Hash]types.UnlockConditions)
+	for _, sci := range txn.SiacoinInputs {
+		ucs[crypto.Hash(sci.ParentID)] = sci.UnlockConditions
+	}
+	for _, sfi := range txn.SiafundInputs {
+		ucs[crypto.Hash(sfi.ParentID)] = sfi.UnlockConditions
+	}
+	for _, id := range toSign {
+		key, ok := w.keys[ucs[id].UnlockHash()]
+		if !ok {
+			return errors.New("could not locate signing key for input")
+		}
+		for i, sig := range txn.TransactionSignatures {
+			if sig.ParentID == id {
+				sig := crypto.SignHash(txn.SigHash(i, height), key.sk)
+				txn.TransactionSignatures[i].Signature = sig[:]
+			}
+		}
+	}
+	return nil
+}
+
+// Transaction implements Wallet.
+func (w *memWallet) Transaction(id types.TransactionID) (modules.ProcessedTransaction, error) {
+	if w.locked {
+		return modules.ProcessedTransaction{}, errLocked
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pt, ok := w.txns[id]
+	if !ok || !w.relevant(pt.Transaction) {
+		return modules.ProcessedTransaction{}, errors.New("could not find transaction")
+	}
+	return pt, nil
+}
+
+// relevant reports whether txn spends from or pays to the wallet.
+func (w *memWallet) relevant(txn types.Transaction) bool {
+	for _, sci := range txn.SiacoinInputs {
+		if _, ok := w.keys[sci.UnlockConditions.UnlockHash()]; ok {
+			return true
+		}
+	}
+	for _, sfi := range txn.SiafundInputs {
+		if _, ok := w.keys[sfi.UnlockConditions.UnlockHash()]; ok {
+			return true
+		}
+	}
+	for _, sco := range txn.SiacoinOutputs {
+		if _, ok := w.keys[sco.UnlockHash]; ok {
+			return true
+		}
+	}
+	for _, sfo := range txn.SiafundOutputs {
+		if _, ok := w.keys[sfo.UnlockHash]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Status implements Wallet.
+func (w *memWallet) Status() (api.WalletGET, error) {
+	sc, sf := w.balance()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return api.WalletGET{
+		Encrypted:               true,
+		Unlocked:                !w.locked,
+		Height:                  w.height,
+		ConfirmedSiacoinBalance: sc,
+		SiafundBalance:          sf,
+	}, nil
+}
+
+// balance returns the confirmed siacoin and siafund balance of the wallet.
+func (w *memWallet) balance() (sc, sf types.Currency) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, sco := range w.scOutputs {
+		if _, ok := w.keys[sco.UnlockHash]; ok {
+			sc = sc.Add(sco.Value)
+		}
+	}
+	for _, sfo := range w.sfOutputs {
+		if _, ok := w.keys[sfo.UnlockHash]; ok {
+			sf = sf.Add(sfo.Value)
+		}
+	}
+	return
+}
+
+// newMemWallet returns a wallet on c funded with sc and sf.
+func newMemWallet(c *memChain, sc, sf types.Currency) *memWallet {
+	w := &memWallet{
+		memChain: c,
+		keys:     make(map[types.UnlockHash]memKey),
+	}
+	addr, _ := w.Address()
+	c.fund(addr, sc, sf)
+	return w
+}
+
+// newMemSeedWallet returns a wallet on c whose funds, sc and sf, are held by
+// the first address derived from seed, as siad's wallet would derive it.
+func newMemSeedWallet(c *memChain, seed modules.Seed, sc, sf types.Currency) *memWallet {
+	w := newMemWallet(c, types.ZeroCurrency, types.ZeroCurrency)
+	key := deriveSeedKey(seed, 0)
+	addr := key.uc.UnlockHash()
+	w.keys[addr] = memKey{uc: key.uc, sk: key.sk}
+	w.addrs = append(w.addrs, addr)
+	c.fund(addr, sc, sf)
+	return w
+}
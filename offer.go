@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+// An open offer is a swap that anyone can fill. Rather than signing last, the
+// creator signs first, covering only their own inputs, the outputs they
+// demand, and the miner fee. The output paying the taker is left uncovered,
+// so that any taker can fill in their address, add their own inputs and
+// outputs, sign, and broadcast the swap in a single step.
+//
+// Partial signatures cover fields by index, so a taker must only append to
+// the transaction, never reorder it.
+
+// isOpenOffer reports whether the swap has been signed as an open offer.
+func isOpenOffer(swap SwapTransaction) bool {
+	for _, sig := range swap.Signatures {
+		if !sig.CoveredFields.WholeTransaction {
+			return true
+		}
+	}
+	return false
+}
+
+// indices returns the indices [start, end).
+func indices(start, end int) []uint64 {
+	var is []uint64
+	for i := start; i < end; i++ {
+		is = append(is, uint64(i))
+	}
+	return is
+}
+
+// equalIndices reports whether two lists of covered indices are the same.
+func equalIndices(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// offerCoveredFields returns the fields that the creator of an open offer
+// signs: every input and output in the offer except the outputs paying the
+// taker, plus the miner fee.
+func offerCoveredFields(swap SwapTransaction) types.CoveredFields {
+	cf := types.CoveredFields{
+		SiacoinInputs: indices(0, len(swap.SiacoinInputs)),
+		SiafundInputs: indices(0, len(swap.SiafundInputs)),
+		MinerFees:     []uint64{0},
+	}
+	for i, sco := range swap.SiacoinOutputs {
+		if sco.UnlockHash != (types.UnlockHash{}) {
+			cf.SiacoinOutputs = append(cf.SiacoinOutputs, uint64(i))
+		}
+	}
+	for i, sfo := range swap.SiafundOutputs {
+		if sfo.UnlockHash != (types.UnlockHash{}) {
+			cf.SiafundOutputs = append(cf.SiafundOutputs, uint64(i))
+		}
+	}
+	return cf
+}
+
+// signOffer signs the creator's inputs of a swap as an open offer.
+func signOffer(w Wallet, swap *SwapTransaction) error {
+	if len(swap.Signatures) > 0 {
+		return errors.New("swap is already signed")
+	}
+	cf := offerCoveredFields(*swap)
+	var toSign []crypto.Hash
+	for _, sci := range swap.SiacoinInputs {
+		toSign = append(toSign, crypto.Hash(sci.ParentID))
+	}
+	for _, sfi := range swap.SiafundInputs {
+		toSign = append(toSign, crypto.Hash(sfi.ParentID))
+	}
+	for _, id := range toSign {
+		swap.Signatures = append(swap.Signatures, types.TransactionSignature{
+			ParentID:       id,
+			PublicKeyIndex: 0,
+			CoveredFields:  cf,
+		})
+	}
+	txn := swap.transaction()
+	if err := w.SignTransaction(&txn, toSign); err != nil {
+		return err
+	}
+	swap.Signatures = txn.TransactionSignatures
+	return nil
+}
+
+// createOffer creates a swap as in createSwap and signs it as an open offer.
+func createOffer(w Wallet, send, receive Basket, minerFee types.Currency, feePayer string, opts fundingOptions) (SwapTransaction, error) {
+	swap, err := createSwap(w, send, receive, minerFee, feePayer, opts)
+	if err != nil {
+		return SwapTransaction{}, err
+	} else if err := signOffer(w, &swap); err != nil {
+		return SwapTransaction{}, fmt.Errorf("failed to sign offer: %w", err)
+	}
+	return swap, nil
+}
+
+// checkOfferSignatures checks the creator's signatures on an open offer,
+// which may since have been filled. The creator must sign each of their
+// inputs, and nothing else, with the same covered fields. Those must include
+// every creator input, the miner fee, and outputs worth at least the
+// creator's proceeds, but not the outputs left for the taker.
+func checkOfferSignatures(swap SwapTransaction) error {
+	cf := swap.Signatures[0].CoveredFields
+	var sc, sf int
+	for _, sig := range swap.Signatures {
+		if sig.CoveredFields.WholeTransaction {
+			continue
+		} else if !equalIndices(sig.CoveredFields.SiacoinInputs, cf.SiacoinInputs) ||
+			!equalIndices(sig.CoveredFields.SiafundInputs, cf.SiafundInputs) ||
+			!equalIndices(sig.CoveredFields.SiacoinOutputs, cf.SiacoinOutputs) ||
+			!equalIndices(sig.CoveredFields.SiafundOutputs, cf.SiafundOutputs) ||
+			!equalIndices(sig.CoveredFields.MinerFees, cf.MinerFees) {
+			return errors.New("offer signatures cover different fields")
+		}
+		switch {
+		case sc < len(cf.SiacoinInputs) && swap.SiacoinInputs[cf.SiacoinInputs[sc]].ParentID == types.SiacoinOutputID(sig.ParentID):
+			sc++
+		case sf < len(cf.SiafundInputs) && swap.SiafundInputs[cf.SiafundInputs[sf]].ParentID == types.SiafundOutputID(sig.ParentID):
+			sf++
+		default:
+			return errors.New("offer signature does not match a covered input")
+		}
+	}
+	if cf.WholeTransaction {
+		return errors.New("offer is not signed as an open offer")
+	} else if len(cf.FileContracts) > 0 || len(cf.FileContractRevisions) > 0 || len(cf.StorageProofs) > 0 || len(cf.ArbitraryData) > 0 || len(cf.TransactionSignatures) > 0 {
+		return errors.New("offer signatures cover unexpected fields")
+	} else if sc != len(cf.SiacoinInputs) || sf != len(cf.SiafundInputs) {
+		return errors.New("offer is missing signatures for its inputs")
+	} else if !equalIndices(cf.MinerFees, []uint64{0}) {
+		return errors.New("offer signatures do not cover the miner fee")
+	}
+	for i := range cf.SiacoinInputs {
+		if cf.SiacoinInputs[i] != uint64(i) {
+			return errors.New("offer inputs must precede the taker's inputs")
+		}
+	}
+	for i := range cf.SiafundInputs {
+		if cf.SiafundInputs[i] != uint64(i) {
+			return errors.New("offer inputs must precede the taker's inputs")
+		}
+	}
+	// the creator's proceeds must be covered, and the taker's must not be
+	var coveredSC, coveredSF types.Currency
+	for _, i := range cf.SiacoinOutputs {
+		if i >= uint64(len(swap.SiacoinOutputs)) {
+			return errors.New("offer signatures cover a nonexistent output")
+		} else if swap.SiacoinOutputs[i].UnlockHash == (types.UnlockHash{}) {
+			return errors.New("offer signatures cover the taker's output")
+		}
+		coveredSC = coveredSC.Add(swap.SiacoinOutputs[i].Value)
+	}
+	for _, i := range cf.SiafundOutputs {
+		if i >= uint64(len(swap.SiafundOutputs)) {
+			return errors.New("offer signatures cover a nonexistent output")
+		} else if swap.SiafundOutputs[i].UnlockHash == (types.UnlockHash{}) {
+			return errors.New("offer signatures cover the taker's output")
+		}
+		coveredSF = coveredSF.Add(swap.SiafundOutputs[i].Value)
+	}
+	if creator, _ := swap.parties(); coveredSC.Cmp(creator.proceedsSC()) < 0 || coveredSF.Cmp(creator.receive.SF) < 0 {
+		return errors.New("offer signatures do not cover the creator's output")
+	}
+	return nil
+}
+
+// checkOffer checks that an unfilled open offer is valid.
+func checkOffer(swap SwapTransaction) error {
+	if err := checkOfferSignatures(swap); err != nil {
+		return err
+	} else if len(swap.Signatures) != len(swap.SiacoinInputs)+len(swap.SiafundInputs) {
+		return errors.New("offer has already been filled")
+	}
+	want, cf := offerCoveredFields(swap), swap.Signatures[0].CoveredFields
+	if !equalIndices(cf.SiacoinOutputs, want.SiacoinOutputs) || !equalIndices(cf.SiafundOutputs, want.SiafundOutputs) {
+		return errors.New("offer signatures do not cover the creator's outputs")
+	}
+	return nil
+}
+
+// fillOffer fills an open offer with inputs from w and signs it.
+func fillOffer(w Wallet, swap *SwapTransaction, opts fundingOptions) error {
+	if err := checkOffer(*swap); err != nil {
+		return err
+	}
+	// the creator's signatures cover the miner fee, so change cannot be
+	// added to it
+	opts.dustThreshold = types.ZeroCurrency
+	if err := acceptSwap(w, swap, opts); err != nil {
+		return err
+	}
+	return checkFinish(w, *swap, false)
+}
+
+// takeOffer fills an open offer with inputs from w, signs it, and broadcasts
+// it to c.
+func takeOffer(w Wallet, c Chain, swap *SwapTransaction, opts fundingOptions) error {
+	if err := fillOffer(w, swap, opts); err != nil {
+		return err
+	}
+	return c.BroadcastTransaction(swap.transaction())
+}
+
+// fillsOffer reports whether txn fills the open offer: it spends the offer's
+// inputs and contains the outputs covered by the creator's signatures.
+func fillsOffer(offer SwapTransaction, txn types.Transaction) bool {
+	cf := offer.Signatures[0].CoveredFields
+	for _, i := range cf.SiacoinInputs {
+		if int(i) >= len(txn.SiacoinInputs) || txn.SiacoinInputs[i].ParentID != offer.SiacoinInputs[i].ParentID {
+			return false
+		}
+	}
+	for _, i := range cf.SiafundInputs {
+		if int(i) >= len(txn.SiafundInputs) || txn.SiafundInputs[i].ParentID != offer.SiafundInputs[i].ParentID {
+			return false
+		}
+	}
+	for _, i := range cf.SiacoinOutputs {
+		if int(i) >= len(txn.SiacoinOutputs) || txn.SiacoinOutputs[i].UnlockHash != offer.SiacoinOutputs[i].UnlockHash || !txn.SiacoinOutputs[i].Value.Equals(offer.SiacoinOutputs[i].Value) {
+			return false
+		}
+	}
+	for _, i := range cf.SiafundOutputs {
+		if int(i) >= len(txn.SiafundOutputs) || txn.SiafundOutputs[i].UnlockHash != offer.SiafundOutputs[i].UnlockHash || !txn.SiafundOutputs[i].Value.Equals(offer.SiafundOutputs[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// offerStatus checks whether one of our open offers has been filled. Once the
+// offer's inputs are spent, a pending transaction that fills the offer can be
+// recognized, but a confirmed one cannot be distinguished from a cancellation.
+func offerStatus(w Wallet, swap SwapTransaction) string {
+	if !isOpenOffer(swap) || checkOfferSignatures(swap) != nil || cancelStatus(w, swap) == "" {
+		return ""
+	}
+	pts, err := w.UnconfirmedTransactions()
+	if err != nil {
+		return ""
+	}
+	for _, pt := range pts {
+		if sharesInputs(swap, SwapTransaction{SiacoinInputs: pt.Transaction.SiacoinInputs, SiafundInputs: pt.Transaction.SiafundInputs}) {
+			if fillsOffer(swap, pt.Transaction) {
+				return swapTransactionPending
+			}
+			return swapCancelled
+		}
+	}
+	return offerClosed
+}
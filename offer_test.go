@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+
+	"go.sia.tech/siad/types"
+)
+
+func TestOpenOffer(t *testing.T) {
+	scAmount := types.SiacoinPrecision.Mul64(100)
+	sfAmount := types.NewCurrency64(2)
+
+	for _, offeringSF := range []bool{false, true} {
+		c, alice, bob := newTestSwappers()
+		maker, taker := alice, bob
+		send, receive := Basket{SC: scAmount}, Basket{SF: sfAmount}
+		if offeringSF {
+			maker, taker = bob, alice
+			send, receive = receive, send
+		}
+
+		offer, err := createOffer(maker, send, receive, testMinerFee, feePayerSplit, fundingOptions{})
+		if err != nil {
+			t.Fatal(err)
+		} else if !isOpenOffer(offer) {
+			t.Fatal("expected swap to be an open offer")
+		} else if err := checkAccept(offer); err != nil {
+			t.Fatal(err)
+		}
+		checkStatus(t, maker, offer, waitingForCounterpartyToAccept)
+		checkStatus(t, taker, offer, waitingForYouToAccept)
+
+		// the taker fills the offer in one step
+		filled := offer
+		if err := takeOffer(taker, taker, &filled, fundingOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		checkStatus(t, taker, filled, swapTransactionPending)
+		checkStatus(t, maker, offer, swapTransactionPending)
+		c.mine()
+		checkStatus(t, taker, filled, swapTransactionConfirmed)
+		checkStatus(t, maker, offer, offerClosed)
+
+		fee := testMinerFee.Div64(2)
+		aliceSC, aliceSF := alice.balance()
+		bobSC, bobSF := bob.balance()
+		if !aliceSC.Equals(types.SiacoinPrecision.Mul64(1000).Sub(scAmount).Sub(fee)) || !aliceSF.Equals(sfAmount) {
+			t.Fatalf("alice has wrong balance after swap: %v SC, %v SF", aliceSC.HumanString(), aliceSF)
+		} else if !bobSC.Equals(scAmount.Sub(fee)) || !bobSF.Equals(types.NewCurrency64(8)) {
+			t.Fatalf("bob has wrong balance after swap: %v SC, %v SF", bobSC.HumanString(), bobSF)
+		}
+	}
+}
+
+func TestBasketOffer(t *testing.T) {
+	sc := types.SiacoinPrecision.Mul64
+	c := newMemChain()
+	alice := newMemWallet(c, sc(1000), types.ZeroCurrency)
+	bob := newMemWallet(c, sc(50), types.NewCurrency64(10))
+
+	offer, err := createOffer(bob, Basket{SC: sc(10), SF: types.NewCurrency64(2)}, Basket{SC: sc(100)}, sc(1), feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := checkAccept(offer); err != nil {
+		t.Fatal(err)
+	}
+	filled := offer
+	if err := takeOffer(alice, alice, &filled, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, bob, offer, offerClosed)
+	if aliceSC, aliceSF := alice.balance(); !aliceSC.Equals(sc(909)) || !aliceSF.Equals(types.NewCurrency64(2)) {
+		t.Fatalf("alice has wrong balance after swap: %v SC, %v SF", aliceSC.HumanString(), aliceSF)
+	} else if bobSC, bobSF := bob.balance(); !bobSC.Equals(sc(140)) || !bobSF.Equals(types.NewCurrency64(8)) {
+		t.Fatalf("bob has wrong balance after swap: %v SC, %v SF", bobSC.HumanString(), bobSF)
+	}
+}
+
+func TestOpenOfferTampering(t *testing.T) {
+	_, alice, bob := newTestSwappers()
+	offer, err := createOffer(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the creator's signatures must cover their proceeds but not the taker's
+	tampered := offer
+	tampered.Signatures = append([]types.TransactionSignature(nil), offer.Signatures...)
+	tampered.Signatures[0].CoveredFields.SiafundOutputs = nil
+	if err := checkAccept(tampered); err == nil {
+		t.Fatal("expected checkAccept to reject an offer that does not cover the creator's output")
+	}
+	tampered.Signatures[0].CoveredFields = offer.Signatures[0].CoveredFields
+	tampered.Signatures[0].CoveredFields.SiacoinOutputs = []uint64{0}
+	if err := checkAccept(tampered); err == nil {
+		t.Fatal("expected checkAccept to reject an offer that covers the taker's output")
+	}
+
+	// changing a covered field invalidates the creator's signatures
+	c := bob.memChain
+	tampered = offer
+	tampered.SiafundOutputs = append([]types.SiafundOutput(nil), offer.SiafundOutputs...)
+	tampered.SiafundOutputs[0].Value = types.NewCurrency64(1)
+	if err := takeOffer(bob, bob, &tampered, fundingOptions{}); err == nil {
+		t.Fatal("expected broadcast of a tampered offer to fail")
+	} else if len(c.tpool) != 0 {
+		t.Fatal("tampered offer should not be in the pool")
+	}
+
+	// dust cannot be folded into the fee, which the creator has signed
+	filled := offer
+	if err := takeOffer(bob, bob, &filled, fundingOptions{dustThreshold: types.SiacoinPrecision}); err != nil {
+		t.Fatal(err)
+	} else if !filled.ChangeFee.IsZero() {
+		t.Fatal("taker should not change the signed miner fee")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	mnemonics "gitlab.com/NebulousLabs/entropy-mnemonics"
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/modules/wallet"
+	"go.sia.tech/siad/types"
+)
+
+// Offline signing splits a swap step between two machines. An online node,
+// whose wallet need not hold any keys, builds and checks the swap as usual,
+// but leaves its signatures blank and exports them as a SigningRequest. An
+// air-gapped machine holding the wallet seed signs the request, and the
+// signatures are imported back into the online node, which completes the
+// step.
+
+// A SigningInput identifies a signature that the offline signer must fill in,
+// along with everything needed to produce it.
+type SigningInput struct {
+	ParentID         crypto.Hash            `json:"parentID"`
+	SignatureIndex   int                    `json:"signatureIndex"`
+	UnlockConditions types.UnlockConditions `json:"unlockConditions"`
+	PublicKeyIndex   uint64                 `json:"publicKeyIndex"`
+	CoveredFields    types.CoveredFields    `json:"coveredFields"`
+	SigHash          crypto.Hash            `json:"sigHash"`
+}
+
+// A SigningRequest asks an offline signer to sign our inputs to a swap. Stage
+// is the journal stage that the swap reaches once the signatures are
+// imported. File is the swap file the swap was exported from, so that its
+// memo, expiry, network, and creation height survive the round trip.
+type SigningRequest struct {
+	Swap   SwapTransaction   `json:"swap"`
+	Stage  string            `json:"stage"`
+	Height types.BlockHeight `json:"height"`
+	Inputs []SigningInput    `json:"inputs"`
+	File   *SwapFile         `json:"file,omitempty"`
+}
+
+// A watchOnlyBackend is a Backend that never signs. Signatures added by
+// signInputs and signOffer are left blank, to be filled in by an offline
+// signer.
+type watchOnlyBackend struct {
+	Backend
+}
+
+// SignTransaction implements Wallet.
+func (watchOnlyBackend) SignTransaction(txn *types.Transaction, toSign []crypto.Hash) error {
+	return nil
+}
+
+// newSigningRequest returns a request to sign each blank signature of the
+// swap at the current height of c.
+func newSigningRequest(c Chain, swap SwapTransaction, stage string) (SigningRequest, error) {
+	cg, err := c.Consensus()
+	if err != nil {
+		return SigningRequest{}, fmt.Errorf("failed to get consensus height: %w", err)
+	}
+	ucs := make(map[crypto.Hash]types.UnlockConditions)
+	for _, sci := range swap.SiacoinInputs {
+		ucs[crypto.Hash(sci.ParentID)] = sci.UnlockConditions
+	}
+	for _, sfi := range swap.SiafundInputs {
+		ucs[crypto.Hash(sfi.ParentID)] = sfi.UnlockConditions
+	}
+	req := SigningRequest{
+		Swap:   swap,
+		Stage:  stage,
+		Height: cg.Height,
+	}
+	txn := swap.transaction()
+	for i, sig := range swap.Signatures {
+		if len(sig.Signature) > 0 {
+			continue
+		}
+		uc, ok := ucs[sig.ParentID]
+		if !ok {
+			return SigningRequest{}, errors.New("signature does not match an input")
+		}
+		req.Inputs = append(req.Inputs, SigningInput{
+			ParentID:         sig.ParentID,
+			SignatureIndex:   i,
+			UnlockConditions: uc,
+			PublicKeyIndex:   sig.PublicKeyIndex,
+			CoveredFields:    sig.CoveredFields,
+			SigHash:          txn.SigHash(i, cg.Height),
+		})
+	}
+	if len(req.Inputs) == 0 {
+		return SigningRequest{}, errors.New("swap has nothing to sign")
+	}
+	return req, nil
+}
+
+// checkSigningRequest checks that each input of the request refers to a blank
+// signature of the swap, and that its sighash matches the one we compute
+// ourselves, so that a signer never signs a hash it has not verified.
+func checkSigningRequest(req SigningRequest) error {
+	txn := req.Swap.transaction()
+	addrs := make(map[crypto.Hash]types.UnlockHash)
+	for _, sci := range txn.SiacoinInputs {
+		addrs[crypto.Hash(sci.ParentID)] = sci.UnlockConditions.UnlockHash()
+	}
+	for _, sfi := range txn.SiafundInputs {
+		addrs[crypto.Hash(sfi.ParentID)] = sfi.UnlockConditions.UnlockHash()
+	}
+	for _, in := range req.Inputs {
+		if in.SignatureIndex < 0 || in.SignatureIndex >= len(txn.TransactionSignatures) {
+			return errors.New("signing request refers to a nonexistent signature")
+		}
+		sig := txn.TransactionSignatures[in.SignatureIndex]
+		if sig.ParentID != in.ParentID || sig.PublicKeyIndex != in.PublicKeyIndex || len(sig.Signature) > 0 {
+			return errors.New("signing request does not match the swap's signatures")
+		} else if addr, ok := addrs[in.ParentID]; !ok || addr != in.UnlockConditions.UnlockHash() {
+			return errors.New("signing request does not match the swap's inputs")
+		} else if in.PublicKeyIndex >= uint64(len(in.UnlockConditions.PublicKeys)) {
+			return errors.New("signing request refers to a nonexistent public key")
+		} else if txn.SigHash(in.SignatureIndex, req.Height) != in.SigHash {
+			return errors.New("signing request has an incorrect sighash")
+		}
+	}
+	return nil
+}
+
+// parseSeed parses a wallet seed phrase.
+func parseSeed(phrase string) (modules.Seed, error) {
+	seed, err := modules.StringToSeed(phrase, mnemonics.English)
+	if err != nil {
+		return modules.Seed{}, fmt.Errorf("invalid seed: %w", err)
+	}
+	return seed, nil
+}
+
+// signRequest signs a SigningRequest with keys derived from seed, returning
+// the requested signatures.
+func signRequest(req SigningRequest, seed modules.Seed) ([]types.TransactionSignature, error) {
+	if err := checkSigningRequest(req); err != nil {
+		return nil, err
+	}
+	txn := req.Swap.transaction()
+	txn.TransactionSignatures = append([]types.TransactionSignature(nil), txn.TransactionSignatures...)
+	var toSign []crypto.Hash
+	for _, in := range req.Inputs {
+		toSign = append(toSign, in.ParentID)
+	}
+	if err := wallet.SignTransaction(&txn, seed, toSign, req.Height); err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+	var sigs []types.TransactionSignature
+	for _, in := range req.Inputs {
+		sigs = append(sigs, txn.TransactionSignatures[in.SignatureIndex])
+	}
+	return sigs, nil
+}
+
+// verifySignature checks that sig is a valid signature of sigHash by the
+// public key at pkIndex in uc.
+func verifySignature(uc types.UnlockConditions, pkIndex uint64, sigHash crypto.Hash, sig []byte) error {
+	if pkIndex >= uint64(len(uc.PublicKeys)) {
+		return errors.New("public key index out of range")
+	}
+	pk := uc.PublicKeys[pkIndex]
+	var cpk crypto.PublicKey
+	var csig crypto.Signature
+	if pk.Algorithm != types.SignatureEd25519 || len(pk.Key) != len(cpk) {
+		return errors.New("unsupported public key")
+	} else if len(sig) != len(csig) {
+		return errors.New("malformed signature")
+	}
+	copy(cpk[:], pk.Key)
+	copy(csig[:], sig)
+	return crypto.VerifyHash(sigHash, cpk, csig)
+}
+
+// applySignatures fills in the blank signatures of a SigningRequest's swap,
+// checking each signature against the sighash and public key it should
+// cover.
+func applySignatures(req SigningRequest, sigs []types.TransactionSignature) (SwapTransaction, error) {
+	if err := checkSigningRequest(req); err != nil {
+		return SwapTransaction{}, err
+	}
+	swap := req.Swap
+	swap.Signatures = append([]types.TransactionSignature(nil), swap.Signatures...)
+	for _, in := range req.Inputs {
+		var found bool
+		for _, sig := range sigs {
+			if sig.ParentID != in.ParentID {
+				continue
+			}
+			if err := verifySignature(in.UnlockConditions, in.PublicKeyIndex, in.SigHash, sig.Signature); err != nil {
+				return SwapTransaction{}, fmt.Errorf("signature for input %v is invalid: %w", in.ParentID, err)
+			}
+			swap.Signatures[in.SignatureIndex].Signature = sig.Signature
+			found = true
+			break
+		}
+		if !found {
+			return SwapTransaction{}, fmt.Errorf("missing signature for input %v", in.ParentID)
+		}
+	}
+	return swap, nil
+}
+
+// signingBackend returns b, or, if offline is set, a watchOnlyBackend that
+// leaves signing to an offline signer.
+func signingBackend(b Backend, offline bool) Backend {
+	if offline {
+		return watchOnlyBackend{b}
+	}
+	return b
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+)
+
+func TestOfflineFinish(t *testing.T) {
+	c := newMemChain()
+	seed := modules.Seed{1, 2, 3}
+	alice := newMemSeedWallet(c, seed, types.SiacoinPrecision.Mul64(1000), types.ZeroCurrency)
+	bob := newMemWallet(c, types.ZeroCurrency, types.NewCurrency64(10))
+
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := checkFinish(alice, swap, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice's online node leaves her signatures blank and exports them
+	if err := signInputs(watchOnlyBackend{alice}, &swap); err != nil {
+		t.Fatal(err)
+	}
+	req, err := newSigningRequest(alice, swap, stageBroadcast)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(req.Inputs) != len(swap.SiacoinInputs) {
+		t.Fatalf("expected %v inputs to sign, got %v", len(swap.SiacoinInputs), len(req.Inputs))
+	}
+
+	// the signer refuses a request whose transaction has been changed
+	tampered := req
+	tampered.Swap.SiafundOutputs = append([]types.SiafundOutput(nil), req.Swap.SiafundOutputs...)
+	tampered.Swap.SiafundOutputs[0].UnlockHash = types.UnlockHash{1}
+	if _, err := signRequest(tampered, seed); err == nil {
+		t.Fatal("expected signRequest to reject a tampered request")
+	}
+
+	// forged signatures are rejected on import
+	sigs, err := signRequest(req, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := append([]types.TransactionSignature(nil), sigs...)
+	forged[0].Signature = append([]byte(nil), sigs[0].Signature...)
+	forged[0].Signature[0] ^= 1
+	if _, err := applySignatures(req, forged); err == nil {
+		t.Fatal("expected applySignatures to reject an invalid signature")
+	}
+
+	swap, err = applySignatures(req, sigs)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := checkFinish(alice, swap, false); err != nil {
+		t.Fatal(err)
+	} else if err := alice.BroadcastTransaction(swap.transaction()); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, bob, swap, swapTransactionConfirmed)
+}
+
+func TestSigningRequestFile(t *testing.T) {
+	c := newMemChain()
+	alice := newMemWallet(c, types.SiacoinPrecision.Mul64(1000), types.ZeroCurrency)
+	swap, err := createOffer(watchOnlyBackend{alice}, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newSwapFile(c, swap, 50, "for the boat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := newSigningRequest(c, swap, stageCreated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.File = &f
+
+	// the swap file survives the trip to the signer and back
+	js, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SigningRequest
+	if err := json.Unmarshal(js, &decoded); err != nil {
+		t.Fatal(err)
+	} else if decoded.File == nil {
+		t.Fatal("expected request to carry its swap file")
+	}
+	restored := decoded.File.withSwap(decoded.Swap)
+	if restored.Memo != f.Memo || restored.Expiry != f.Expiry || restored.Height != f.Height || restored.Network != f.Network {
+		t.Fatalf("swap file not restored: %+v", restored)
+	} else if err := validateSwapFile(restored); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOfflineOffer(t *testing.T) {
+	c := newMemChain()
+	seed := modules.Seed{1, 2, 3}
+	alice := newMemSeedWallet(c, seed, types.SiacoinPrecision.Mul64(1000), types.ZeroCurrency)
+	bob := newMemWallet(c, types.ZeroCurrency, types.NewCurrency64(10))
+
+	// alice signs an open offer offline; its signatures cover only part of
+	// the transaction
+	offer, err := createOffer(watchOnlyBackend{alice}, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := newSigningRequest(alice, offer, stageCreated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs, err := signRequest(req, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, err = applySignatures(req, sigs)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := takeOffer(bob, bob, &offer, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, alice, offer, swapTransactionConfirmed)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gtank/ristretto255"
+	mnemonics "gitlab.com/NebulousLabs/entropy-mnemonics"
+	"go.sia.tech/siad/crypto"
+)
+
+// Pairing lets the parties to a swap exchange its stages through a relay
+// using only a short code, such as "7-crossbow-tunic", in the manner of
+// magic-wormhole. The number is a nameplate on the relay, which stands for a
+// mailbox; the words are a password. Both parties run SPAKE2 over the
+// mailbox with the code as the password, deriving a key that is strong even
+// though the code is short. A relay, or anyone else, that tries to guess the
+// code gets one guess per nameplate, and a wrong guess is detected by both
+// parties. Every stage of the swap is then encrypted with the derived key.
+
+const (
+	// codeWords is the number of words in a code.
+	codeWords = 2
+	// pairTimeout is how long the parties wait for each other to enter a
+	// code. The creator then deletes the mailbox, freeing the nameplate.
+	pairTimeout = 15 * time.Minute
+)
+
+// Pairing stages, posted to the mailbox alongside the swap's stages.
+const (
+	stagePAKECreator    = "pake-creator"
+	stagePAKEJoiner     = "pake-joiner"
+	stageConfirmCreator = "confirm-creator"
+	stageConfirmJoiner  = "confirm-joiner"
+)
+
+// spakeM and spakeN are the SPAKE2 blinding elements. No one knows their
+// discrete logarithms, since they are derived by hashing.
+var (
+	spakeM = hashToElement("embc SPAKE2 M")
+	spakeN = hashToElement("embc SPAKE2 N")
+)
+
+func hashToElement(s string) *ristretto255.Element {
+	h := sha512.Sum512([]byte(s))
+	return ristretto255.NewElement().FromUniformBytes(h[:])
+}
+
+// A spake2 is one side of a SPAKE2 exchange over ristretto255.
+type spake2 struct {
+	creator bool
+	w, x    *ristretto255.Scalar
+	msg     []byte
+}
+
+// newSPAKE2 starts a SPAKE2 exchange with password.
+func newSPAKE2(password string, creator bool) *spake2 {
+	h := sha512.Sum512([]byte("embc SPAKE2 password:" + password))
+	w := ristretto255.NewScalar().FromUniformBytes(h[:])
+	var r [64]byte
+	if _, err := rand.Read(r[:]); err != nil {
+		panic(err)
+	}
+	x := ristretto255.NewScalar().FromUniformBytes(r[:])
+	blind := spakeN
+	if creator {
+		blind = spakeM
+	}
+	// T = x*G + w*blind
+	t := ristretto255.NewElement().ScalarBaseMult(x)
+	t.Add(t, ristretto255.NewElement().ScalarMult(w, blind))
+	return &spake2{creator: creator, w: w, x: x, msg: t.Encode(nil)}
+}
+
+// finish completes the exchange with the counterparty's message, returning
+// the shared key. If the parties used different passwords, their keys differ.
+func (s *spake2) finish(peerMsg []byte) ([]byte, error) {
+	peer := ristretto255.NewElement()
+	if err := peer.Decode(peerMsg); err != nil {
+		return nil, errors.New("invalid SPAKE2 message")
+	}
+	blind, msgA, msgB := spakeM, peerMsg, s.msg
+	if s.creator {
+		blind, msgA, msgB = spakeN, s.msg, peerMsg
+	}
+	// Z = x*(peer - w*blind)
+	z := ristretto255.NewElement().ScalarMult(s.w, blind)
+	z.Subtract(peer, z)
+	z.ScalarMult(s.x, z)
+	key := crypto.HashAll("embc SPAKE2 key", msgA, msgB, z.Encode(nil), s.w.Encode(nil))
+	return key[:], nil
+}
+
+// generateCode returns a new code for a nameplate.
+func generateCode(nameplate int) string {
+	words := []string{strconv.Itoa(nameplate)}
+	dict := mnemonics.EnglishDictionary
+	// redraw values past the last multiple of the dictionary's length, so
+	// that every word is equally likely
+	limit := 1 << 16 / len(dict) * len(dict)
+	for len(words) <= codeWords {
+		var b [2]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		if n := int(b[0])<<8 | int(b[1]); n < limit {
+			words = append(words, dict[n%len(dict)])
+		}
+	}
+	return strings.Join(words, "-")
+}
+
+// parseCode parses a code, returning its nameplate.
+func parseCode(code string) (int, error) {
+	parts := strings.Split(code, "-")
+	if len(parts) != codeWords+1 {
+		return 0, fmt.Errorf("invalid code %q; codes look like 7-crossbow-tunic", code)
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid code %q; codes begin with a number", code)
+	}
+	return n, nil
+}
+
+// isCode reports whether s looks like a code rather than a file path or swap
+// text.
+func isCode(s string) bool {
+	if _, err := parseCode(s); err != nil {
+		return false
+	} else if _, err := os.Stat(s); err == nil {
+		return false
+	}
+	return true
+}
+
+// A pairing is a session with a counterparty over a relay mailbox, in which
+// swap stages are encrypted with a key derived from a code.
+type pairing struct {
+	mailbox string
+	key     []byte
+}
+
+// pair runs SPAKE2 with the counterparty over a mailbox, using code as the
+// password, and confirms that both parties derived the same key. It waits up
+// to timeout for the counterparty to join. If the creator fails to pair, it
+// deletes the mailbox, so that the code cannot be used later.
+func pair(mailbox, code string, creator bool, timeout time.Duration) (p *pairing, err error) {
+	if creator {
+		defer func() {
+			if err != nil {
+				deleteMailbox(mailbox)
+			}
+		}()
+	}
+	deadline := time.Now().Add(timeout)
+	ourPAKE, theirPAKE := stagePAKEJoiner, stagePAKECreator
+	ourConfirm, theirConfirm := stageConfirmJoiner, stageConfirmCreator
+	if creator {
+		ourPAKE, theirPAKE = theirPAKE, ourPAKE
+		ourConfirm, theirConfirm = theirConfirm, ourConfirm
+	}
+	s := newSPAKE2(strings.ToLower(code), creator)
+	if err := postMailbox(mailbox, ourPAKE, s.msg); err != nil {
+		return nil, err
+	}
+	msg, err := pullMailboxUntil(mailbox, theirPAKE, true, deadline)
+	if err != nil {
+		return nil, err
+	}
+	var peerMsg []byte
+	if err := json.Unmarshal(msg.Envelope, &peerMsg); err != nil {
+		return nil, fmt.Errorf("invalid SPAKE2 message: %w", err)
+	}
+	key, err := s.finish(peerMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	// each party proves knowledge of the key, so that a wrong code is
+	// detected before any swap is sent
+	confirm := func(stage string) []byte {
+		h := hmac.New(sha512.New, key)
+		h.Write([]byte(stage))
+		return h.Sum(nil)
+	}
+	if err := postMailbox(mailbox, ourConfirm, confirm(ourConfirm)); err != nil {
+		return nil, err
+	}
+	msg, err = pullMailboxUntil(mailbox, theirConfirm, true, deadline)
+	if err != nil {
+		return nil, err
+	}
+	var mac []byte
+	if err := json.Unmarshal(msg.Envelope, &mac); err != nil || !hmac.Equal(mac, confirm(theirConfirm)) {
+		return nil, errors.New("pairing failed: the code is wrong, or someone else tried to use it")
+	}
+	return &pairing{mailbox: mailbox, key: key}, nil
+}
+
+// send posts f, which reached stage, to the counterparty.
+func (p *pairing) send(stage string, f SwapFile) error {
+	ef := EncryptedSwapFile{Scheme: schemeSPAKE2}
+	if err := sealSwapFileWithKey(&ef, p.key, f, stage); err != nil {
+		return err
+	}
+	return postMailbox(p.mailbox, stage, ef)
+}
+
+// receive waits for the counterparty to post a swap at stage. The stage is
+// authenticated, so the relay cannot replay a swap under another stage.
+func (p *pairing) receive(stage string) (SwapFile, error) {
+	msg, err := pullMailbox(p.mailbox, stage, true)
+	if err != nil {
+		return SwapFile{}, err
+	}
+	ef, encrypted, err := parseEncryptedSwapFile(msg.Envelope)
+	if err != nil {
+		return SwapFile{}, err
+	} else if !encrypted || ef.Scheme != schemeSPAKE2 {
+		return SwapFile{}, errors.New("counterparty sent an unencrypted swap")
+	}
+	f, err := openSwapFileWithKey(ef, p.key, stage)
+	if errors.Is(err, errDecrypt) {
+		return SwapFile{}, fmt.Errorf("counterparty's swap was not sent for stage %q", stage)
+	}
+	return f, err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.sia.tech/siad/types"
+)
+
+func TestSPAKE2(t *testing.T) {
+	exchange := func(a, b string) ([]byte, []byte) {
+		creator, joiner := newSPAKE2(a, true), newSPAKE2(b, false)
+		k1, err := creator.finish(joiner.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k2, err := joiner.finish(creator.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k1, k2
+	}
+	if k1, k2 := exchange("crossbow-tunic", "crossbow-tunic"); !bytes.Equal(k1, k2) {
+		t.Fatal("keys differ with the same password")
+	} else if k1, k2 := exchange("crossbow-tunic", "crossbow-tulip"); bytes.Equal(k1, k2) {
+		t.Fatal("keys match with different passwords")
+	} else if _, err := newSPAKE2("a", true).finish([]byte("foo")); err == nil {
+		t.Fatal("expected invalid message to be rejected")
+	}
+}
+
+func TestCode(t *testing.T) {
+	code := generateCode(7)
+	if n, err := parseCode(code); err != nil {
+		t.Fatal(err)
+	} else if n != 7 {
+		t.Fatalf("expected nameplate 7, got %v", n)
+	} else if !isCode(code) {
+		t.Fatalf("%q is not recognized as a code", code)
+	}
+	for _, s := range []string{"crossbow-tunic", "7-crossbow", "x-crossbow-tunic", "0-crossbow-tunic", "embc:AAAA"} {
+		if isCode(s) {
+			t.Errorf("%q should not be recognized as a code", s)
+		}
+	}
+}
+
+func TestPairing(t *testing.T) {
+	srv := httptest.NewServer(newRelayHandler())
+	defer srv.Close()
+
+	type result struct {
+		p   *pairing
+		err error
+	}
+	pairBoth := func(creatorCode, joinerCode string, n int) (result, result) {
+		mailbox, err := claimNameplate(srv.URL, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan result)
+		go func() {
+			p, err := pair(mailbox, joinerCode, false, pairTimeout)
+			done <- result{p, err}
+		}()
+		p, err := pair(mailbox, creatorCode, true, pairTimeout)
+		return result{p, err}, <-done
+	}
+
+	// a wrong code fails for both parties
+	n, _, err := allocateNameplate(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := generateCode(n)
+	if creator, joiner := pairBoth(code, generateCode(n), n); creator.err == nil || joiner.err == nil {
+		t.Fatal("expected pairing with a wrong code to fail")
+	}
+
+	// the nameplate was freed, so it can only be claimed once
+	if _, err := claimNameplate(srv.URL, n); err == nil {
+		t.Fatal("expected claimed nameplate to be rejected")
+	}
+
+	// a creator whose counterparty never arrives gives up, freeing the
+	// nameplate
+	n, mailbox, err := allocateNameplate(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := pair(mailbox, generateCode(n), true, time.Second); !errors.Is(err, errMailboxTimeout) {
+		t.Fatal("expected pairing to time out, got", err)
+	} else if _, err := claimNameplate(srv.URL, n); err == nil {
+		t.Fatal("expected abandoned nameplate to be freed")
+	} else if _, err := pollMailbox(mailbox, 0, 0); err == nil {
+		t.Fatal("expected abandoned mailbox to be deleted")
+	}
+
+	n, _, err = allocateNameplate(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code = generateCode(n)
+	creator, joiner := pairBoth(code, code, n)
+	if creator.err != nil {
+		t.Fatal(creator.err)
+	} else if joiner.err != nil {
+		t.Fatal(joiner.err)
+	}
+
+	_, alice, _ := newTestSwappers()
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newSwapFile(alice, swap, defaultSwapExpiry, "")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := creator.p.send(stageCreated, f); err != nil {
+		t.Fatal(err)
+	}
+	if f2, err := joiner.p.receive(stageCreated); err != nil {
+		t.Fatal(err)
+	} else if f2.Hash != f.Hash {
+		t.Fatal("swap changed in transit")
+	}
+
+	// the relay sees only ciphertext
+	msg, err := pullMailbox(creator.p.mailbox, stageCreated, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := decodeSwapEnvelope(msg.Envelope, nil, x25519Key{}); err == nil {
+		t.Fatal("expected relay to be unable to read the swap")
+	}
+
+	// nor can it replay the swap under another stage
+	if err := postMailbox(creator.p.mailbox, stageAccepted, msg.Envelope); err != nil {
+		t.Fatal(err)
+	} else if _, err := creator.p.receive(stageAccepted); err == nil {
+		t.Fatal("expected replayed swap to be rejected")
+	}
+}
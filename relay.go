@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// A relay is a mailbox server through which the parties to a swap exchange
+// its stages, in place of passing files back and forth. Each swap gets a
+// mailbox under a random ID; each party posts the swap file for the stage
+// they completed, and polls the mailbox for the counterparty's response. The
+// relay never interprets what it stores, so swap files may be encrypted.
+
+const (
+	// relayMailboxLifetime is how long a mailbox is kept after it is
+	// created. A swap is usually exchanged within minutes; a day leaves room
+	// for a counterparty in another time zone.
+	relayMailboxLifetime = 24 * time.Hour
+	// maxRelayMailboxes is the number of mailboxes a relay stores at once.
+	maxRelayMailboxes = 10000
+	// maxRelayMessages is the number of messages a mailbox holds.
+	maxRelayMessages = 16
+	// maxRelayMessageSize is the maximum size of a message's envelope. A swap
+	// stage is a few KiB, even encrypted.
+	maxRelayMessageSize = 64 << 10
+	// maxRelayBytes is the total size of the envelopes a relay stores at
+	// once, across all mailboxes.
+	maxRelayBytes = 64 << 20
+	// maxRelayWait is the longest a relay holds a poll open.
+	maxRelayWait = time.Minute
+
+	// relayClientBurst is the number of requests that change a relay's
+	// contents (creating, posting to, claiming, or deleting a mailbox) that
+	// a client may make at once; thereafter, it may make one every
+	// relayClientInterval.
+	relayClientBurst    = 30
+	relayClientInterval = 2 * time.Second
+)
+
+// A RelayNameplate is a nameplate and the ID of the mailbox it stands for.
+type RelayNameplate struct {
+	Nameplate int    `json:"nameplate"`
+	Mailbox   string `json:"mailbox"`
+}
+
+// A RelayMessage is a swap file posted to a mailbox, tagged with the journal
+// stage the swap reached.
+type RelayMessage struct {
+	Index    int             `json:"index"`
+	Stage    string          `json:"stage"`
+	Envelope json.RawMessage `json:"envelope"`
+}
+
+type relayMailbox struct {
+	messages []RelayMessage
+	size     int // of the messages' envelopes
+	expires  time.Time
+	// updated is closed and replaced whenever a message is posted.
+	updated chan struct{}
+}
+
+// A relayServer stores mailboxes in memory. It also assigns nameplates,
+// small numbers that stand in for a mailbox ID until the counterparty claims
+// them, so that a mailbox can be named by a short code (see pair.go).
+type relayServer struct {
+	mu         sync.Mutex
+	mailboxes  map[string]*relayMailbox
+	nameplates map[int]string
+	size       int // of every mailbox
+	maxSize    int
+	clients    map[string]*relayClient
+}
+
+// A relayClient tracks the requests of one client address, which are
+// limited by a token bucket.
+type relayClient struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRelayServer() *relayServer {
+	return &relayServer{
+		mailboxes:  make(map[string]*relayMailbox),
+		nameplates: make(map[int]string),
+		maxSize:    maxRelayBytes,
+		clients:    make(map[string]*relayClient),
+	}
+}
+
+func newMailboxID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// prune removes expired mailboxes and their nameplates. It must be called
+// with s.mu held.
+func (s *relayServer) prune() {
+	for id, mb := range s.mailboxes {
+		if time.Now().After(mb.expires) {
+			s.size -= mb.size
+			delete(s.mailboxes, id)
+		}
+	}
+	for n, id := range s.nameplates {
+		if _, ok := s.mailboxes[id]; !ok {
+			delete(s.nameplates, n)
+		}
+	}
+}
+
+// allow reports whether the client at addr may make another request that
+// changes the relay's contents, consuming one of its tokens if so.
+func (s *relayServer) allow(addr string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for a, c := range s.clients {
+		if now.Sub(c.last) > relayClientBurst*relayClientInterval {
+			delete(s.clients, a) // its bucket has refilled
+		}
+	}
+	c, ok := s.clients[addr]
+	if !ok {
+		c = &relayClient{tokens: relayClientBurst, last: now}
+		s.clients[addr] = c
+	}
+	c.tokens += float64(now.Sub(c.last)) / float64(relayClientInterval)
+	if c.tokens > relayClientBurst {
+		c.tokens = relayClientBurst
+	}
+	c.last = now
+	if c.tokens < 1 {
+		return false
+	}
+	c.tokens--
+	return true
+}
+
+// limit wraps h, rejecting requests from clients that exceed their rate.
+func (s *relayServer) limit(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if !s.allow(host) {
+			writeError(w, "too many requests; try again later", http.StatusTooManyRequests)
+			return
+		}
+		h(w, r, ps)
+	}
+}
+
+var (
+	// errNoMailbox is returned for a mailbox that does not exist or has
+	// expired.
+	errNoMailbox = errors.New("no such mailbox")
+	// errRelayFull is returned when a relay cannot store any more.
+	errRelayFull = errors.New("relay is full")
+)
+
+// create creates a new mailbox and returns its ID.
+func (s *relayServer) create() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createLocked()
+}
+
+func (s *relayServer) createLocked() (string, error) {
+	s.prune()
+	if len(s.mailboxes) >= maxRelayMailboxes {
+		return "", errRelayFull
+	}
+	id := newMailboxID()
+	s.mailboxes[id] = &relayMailbox{
+		expires: time.Now().Add(relayMailboxLifetime),
+		updated: make(chan struct{}),
+	}
+	return id, nil
+}
+
+// allocateNameplate creates a new mailbox and assigns it the smallest free
+// nameplate.
+func (s *relayServer) allocateNameplate() (RelayNameplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, err := s.createLocked()
+	if err != nil {
+		return RelayNameplate{}, err
+	}
+	n := 1
+	for s.nameplates[n] != "" {
+		n++
+	}
+	s.nameplates[n] = id
+	return RelayNameplate{Nameplate: n, Mailbox: id}, nil
+}
+
+// claimNameplate returns the ID of the mailbox assigned a nameplate, and
+// frees the nameplate, so that it can only be claimed once.
+func (s *relayServer) claimNameplate(n int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	id, ok := s.nameplates[n]
+	if !ok {
+		return "", errors.New("no such nameplate; it may have already been claimed")
+	}
+	delete(s.nameplates, n)
+	return id, nil
+}
+
+// post appends msg to a mailbox, returning its index.
+func (s *relayServer) post(id string, msg RelayMessage) (int, error) {
+	if len(msg.Envelope) == 0 || len(msg.Envelope) > maxRelayMessageSize {
+		return 0, errors.New("envelope is empty or too large")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	mb, ok := s.mailboxes[id]
+	if !ok {
+		return 0, errNoMailbox
+	} else if len(mb.messages) >= maxRelayMessages {
+		return 0, errors.New("mailbox is full")
+	} else if s.size+len(msg.Envelope) > s.maxSize {
+		return 0, errRelayFull
+	}
+	msg.Index = len(mb.messages)
+	mb.messages = append(mb.messages, msg)
+	mb.size += len(msg.Envelope)
+	s.size += len(msg.Envelope)
+	close(mb.updated)
+	mb.updated = make(chan struct{})
+	return msg.Index, nil
+}
+
+// remove deletes a mailbox, freeing its nameplate if it has not been claimed.
+func (s *relayServer) remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mb, ok := s.mailboxes[id]
+	if !ok {
+		return errNoMailbox
+	}
+	s.size -= mb.size
+	delete(s.mailboxes, id)
+	s.prune()
+	return nil
+}
+
+// messages returns the messages in a mailbox from index after onwards, along
+// with a channel that is closed when the next message is posted.
+func (s *relayServer) messages(id string, after int) ([]RelayMessage, <-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mb, ok := s.mailboxes[id]
+	if !ok || time.Now().After(mb.expires) {
+		return nil, nil, errNoMailbox
+	}
+	msgs := []RelayMessage{}
+	if after < len(mb.messages) {
+		msgs = append(msgs, mb.messages[after:]...)
+	}
+	return msgs, mb.updated, nil
+}
+
+func (s *relayServer) createHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	id, err := s.create()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, id)
+}
+
+func (s *relayServer) allocateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	np, err := s.allocateNameplate()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, np)
+}
+
+func (s *relayServer) claimHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	n, err := strconv.Atoi(ps.ByName("n"))
+	if err != nil {
+		writeError(w, "invalid nameplate", http.StatusBadRequest)
+		return
+	}
+	id, err := s.claimNameplate(n)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, id)
+}
+
+func (s *relayServer) postHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var msg RelayMessage
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRelayMessageSize+1024)).Decode(&msg); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	index, err := s.post(ps.ByName("id"), msg)
+	if errors.Is(err, errNoMailbox) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if errors.Is(err, errRelayFull) {
+		writeError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, index)
+}
+
+func (s *relayServer) deleteHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := s.remove(ps.ByName("id")); err != nil {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, true)
+}
+
+// pollHandler returns the messages in a mailbox from index 'after' onwards.
+// If there are none and 'wait' is set, it waits up to that many seconds for
+// one to be posted.
+func (s *relayServer) pollHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	after, _ := strconv.Atoi(r.FormValue("after"))
+	waitSecs, _ := strconv.Atoi(r.FormValue("wait"))
+	wait := time.Duration(waitSecs) * time.Second
+	if after < 0 {
+		after = 0
+	}
+	if wait < 0 {
+		wait = 0
+	} else if wait > maxRelayWait {
+		wait = maxRelayWait
+	}
+	timeout := time.After(wait)
+	for {
+		msgs, updated, err := s.messages(ps.ByName("id"), after)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		} else if len(msgs) > 0 || wait == 0 {
+			writeJSON(w, msgs)
+			return
+		}
+		select {
+		case <-updated:
+		case <-timeout:
+			wait = 0
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// register adds the relay's routes to router under prefix.
+func (s *relayServer) register(router *httprouter.Router, prefix string) {
+	router.POST(prefix+"/mailbox", s.limit(s.createHandler))
+	router.POST(prefix+"/mailbox/:id", s.limit(s.postHandler))
+	router.GET(prefix+"/mailbox/:id", s.pollHandler)
+	router.DELETE(prefix+"/mailbox/:id", s.limit(s.deleteHandler))
+	router.POST(prefix+"/nameplate", s.limit(s.allocateHandler))
+	router.POST(prefix+"/nameplate/:n", s.limit(s.claimHandler))
+}
+
+// newRelayHandler returns an http.Handler that serves a relay.
+func newRelayHandler() http.Handler {
+	router := httprouter.New()
+	newRelayServer().register(router, "")
+	return router
+}
+
+// runRelay runs a relay on addr until it fails.
+func runRelay(addr string) {
+	log.Printf("Relay listening on %v...", addr)
+	log.Fatal(http.ListenAndServe(addr, newRelayHandler()))
+}
+
+// isMailboxURL reports whether s is the URL of a relay mailbox, rather than a
+// file path or swap text.
+func isMailboxURL(s string) bool {
+	return (strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")) && strings.Contains(s, "/mailbox/")
+}
+
+func relayRequest(method, url string, req, resp interface{}) error {
+	var body io.Reader
+	if req != nil {
+		js, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(js)
+	}
+	httpReq, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	r, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to contact relay: %w", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		return fmt.Errorf("relay returned %v: %s", r.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+// createMailbox creates a mailbox on the relay at relayURL and returns its
+// URL.
+func createMailbox(relayURL string) (string, error) {
+	var id string
+	relayURL = strings.TrimSuffix(relayURL, "/")
+	if err := relayRequest(http.MethodPost, relayURL+"/mailbox", nil, &id); err != nil {
+		return "", fmt.Errorf("failed to create mailbox: %w", err)
+	}
+	return relayURL + "/mailbox/" + id, nil
+}
+
+// allocateNameplate allocates a nameplate on the relay at relayURL, returning
+// the nameplate and the URL of the mailbox it stands for.
+func allocateNameplate(relayURL string) (int, string, error) {
+	var np RelayNameplate
+	relayURL = strings.TrimSuffix(relayURL, "/")
+	if err := relayRequest(http.MethodPost, relayURL+"/nameplate", nil, &np); err != nil {
+		return 0, "", fmt.Errorf("failed to allocate nameplate: %w", err)
+	}
+	return np.Nameplate, relayURL + "/mailbox/" + np.Mailbox, nil
+}
+
+// claimNameplate claims a nameplate on the relay at relayURL, returning the
+// URL of the mailbox it stands for.
+func claimNameplate(relayURL string, n int) (string, error) {
+	var id string
+	relayURL = strings.TrimSuffix(relayURL, "/")
+	if err := relayRequest(http.MethodPost, fmt.Sprintf("%v/nameplate/%d", relayURL, n), nil, &id); err != nil {
+		return "", fmt.Errorf("failed to claim nameplate: %w", err)
+	}
+	return relayURL + "/mailbox/" + id, nil
+}
+
+// postMailbox posts envelope, a swap file that reached stage, to a mailbox.
+func postMailbox(mailbox, stage string, envelope interface{}) error {
+	js, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	var index int
+	if err := relayRequest(http.MethodPost, mailbox, RelayMessage{Stage: stage, Envelope: js}, &index); err != nil {
+		return fmt.Errorf("failed to post to mailbox: %w", err)
+	}
+	return nil
+}
+
+// deleteMailbox deletes a mailbox, freeing its nameplate, if any.
+func deleteMailbox(mailbox string) error {
+	var ok bool
+	if err := relayRequest(http.MethodDelete, mailbox, nil, &ok); err != nil {
+		return fmt.Errorf("failed to delete mailbox: %w", err)
+	}
+	return nil
+}
+
+// pollMailbox returns the messages in a mailbox from index after onwards,
+// waiting up to wait for one to be posted if there are none.
+func pollMailbox(mailbox string, after int, wait time.Duration) ([]RelayMessage, error) {
+	q := url.Values{
+		"after": {strconv.Itoa(after)},
+		"wait":  {strconv.Itoa(int(wait / time.Second))},
+	}
+	var msgs []RelayMessage
+	if err := relayRequest(http.MethodGet, mailbox+"?"+q.Encode(), nil, &msgs); err != nil {
+		return nil, fmt.Errorf("failed to poll mailbox: %w", err)
+	}
+	return msgs, nil
+}
+
+// errNoSwapPosted is returned by pullMailbox when a mailbox holds no swap at
+// the requested stage.
+var errNoSwapPosted = errors.New("no swap has been posted to the mailbox at that stage yet")
+
+// latestMessage returns the latest message in msgs at stage, or at any stage
+// if stage is empty.
+func latestMessage(msgs []RelayMessage, stage string) (RelayMessage, bool) {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if stage == "" || msgs[i].Stage == stage {
+			return msgs[i], true
+		}
+	}
+	return RelayMessage{}, false
+}
+
+// errMailboxTimeout is returned by pullMailboxUntil when its deadline passes.
+var errMailboxTimeout = errors.New("timed out waiting for the counterparty")
+
+// pullMailbox returns the latest message in a mailbox at stage. If wait is
+// set, it waits for such a message to be posted; otherwise, it returns an
+// error if there is none.
+func pullMailbox(mailbox, stage string, wait bool) (RelayMessage, error) {
+	return pullMailboxUntil(mailbox, stage, wait, time.Time{})
+}
+
+// pullMailboxUntil is like pullMailbox, but if deadline is non-zero, it
+// stops waiting at deadline.
+func pullMailboxUntil(mailbox, stage string, wait bool, deadline time.Time) (RelayMessage, error) {
+	msgs, err := pollMailbox(mailbox, 0, 0)
+	if err != nil {
+		return RelayMessage{}, err
+	}
+	for {
+		if msg, ok := latestMessage(msgs, stage); ok {
+			return msg, nil
+		} else if !wait {
+			return RelayMessage{}, errNoSwapPosted
+		}
+		timeout := maxRelayWait
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return RelayMessage{}, errMailboxTimeout
+			} else if left < timeout {
+				// the relay waits in whole seconds
+				timeout = left.Truncate(time.Second) + time.Second
+			}
+		}
+		more, err := pollMailbox(mailbox, len(msgs), timeout)
+		if err != nil {
+			return RelayMessage{}, err
+		}
+		msgs = append(msgs, more...)
+	}
+}
+
+// swapStage returns the journal stage that the swap summarized by s has
+// reached, as posted to a mailbox.
+func swapStage(s SwapSummary) string {
+	if userStepsComplete(s) {
+		return stageBroadcast
+	} else if acceptStepsComplete(s) {
+		return stageAccepted
+	}
+	return stageCreated
+}
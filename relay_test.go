@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.sia.tech/siad/types"
+)
+
+func TestRelay(t *testing.T) {
+	srv := httptest.NewServer(newRelayHandler())
+	defer srv.Close()
+	c, alice, bob := newTestSwappers()
+	bobKey := generateX25519Key()
+
+	mailbox, err := createMailbox(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	} else if !isMailboxURL(mailbox) {
+		t.Fatalf("%q is not a mailbox URL", mailbox)
+	} else if _, err := pullMailbox(mailbox, stageCreated, false); err != errNoSwapPosted {
+		t.Fatal("expected empty mailbox, got", err)
+	} else if _, err := pollMailbox(srv.URL+"/mailbox/foo", 0, 0); err == nil {
+		t.Fatal("expected unknown mailbox to be rejected")
+	}
+
+	// alice posts the created swap, encrypted for bob
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newSwapFile(alice, swap, defaultSwapExpiry, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, _, err := sealSwapFile(f, EncryptionOptions{Recipient: bobKey.PublicKey()})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := postMailbox(mailbox, stageCreated, envelope); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice waits for bob's response while bob accepts
+	accepted := make(chan RelayMessage)
+	go func() {
+		msg, err := pullMailbox(mailbox, stageAccepted, true)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- msg
+	}()
+	msg, err := pullMailbox(mailbox, stageCreated, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bf, err := decodeSwapEnvelope(msg.Envelope, nil, bobKey)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &bf.Swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := postMailbox(mailbox, stageAccepted, bf.withSwap(bf.Swap)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg = <-accepted:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for accepted swap")
+	}
+	af, err := decodeSwapEnvelope(msg.Envelope, nil, x25519Key{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(alice, &af.Swap); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, alice, af.Swap, swapTransactionConfirmed)
+
+	// both messages remain in the mailbox
+	if msgs, err := pollMailbox(mailbox, 0, 0); err != nil {
+		t.Fatal(err)
+	} else if len(msgs) != 2 || msgs[0].Stage != stageCreated || msgs[1].Index != 1 {
+		t.Fatalf("unexpected mailbox contents: %+v", msgs)
+	}
+}
+
+func TestRelayLimits(t *testing.T) {
+	rs := newRelayServer()
+	rs.maxSize = 3 * 1000
+	router := httprouter.New()
+	rs.register(router, "")
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	// oversized messages are rejected outright
+	mailbox, err := createMailbox(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := postMailbox(mailbox, stageCreated, bytes.Repeat([]byte{'a'}, maxRelayMessageSize)); err == nil {
+		t.Fatal("expected oversized message to be rejected")
+	}
+
+	// the relay stores only so many bytes across every mailbox
+	msg := string(bytes.Repeat([]byte{'a'}, 998)) // 1000 bytes, quoted
+	for i := 0; i < 3; i++ {
+		if mailbox, err = createMailbox(srv.URL); err != nil {
+			t.Fatal(err)
+		} else if err := postMailbox(mailbox, stageCreated, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := postMailbox(mailbox, stageAccepted, msg); err == nil {
+		t.Fatal("expected post to a full relay to be rejected")
+	} else if err := deleteMailbox(mailbox); err != nil {
+		t.Fatal(err)
+	} else if mailbox, err = createMailbox(srv.URL); err != nil {
+		t.Fatal(err)
+	} else if err := postMailbox(mailbox, stageCreated, msg); err != nil {
+		t.Fatal("expected deleting a mailbox to free space:", err)
+	}
+
+	// each client may make only so many requests at once
+	var limited bool
+	for i := 0; i < relayClientBurst && !limited; i++ {
+		_, err := createMailbox(srv.URL)
+		limited = err != nil
+	}
+	if !limited {
+		t.Fatal("expected client to be rate limited")
+	} else if _, err := pollMailbox(mailbox, 0, 0); err != nil {
+		t.Fatal("expected polling to be unaffected by the rate limit:", err)
+	}
+}
+
+func TestPeerExchange(t *testing.T) {
+	c, alice, bob := newTestSwappers()
+	j, err := openJournal(filepath.Join(t.TempDir(), "swaps.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bob runs the web server; alice pushes her swap to it directly
+	s := &server{backend: bob, journal: j, key: generateX25519Key(), peer: newRelayServer()}
+	router := httprouter.New()
+	router.POST("/api/accept", s.acceptHandler)
+	s.peer.register(router, "/api/peer")
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	s.peerURL = srv.URL + "/api/peer"
+
+	mailbox, err := createMailbox(srv.URL + "/api/peer")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, ok := s.localMailbox(mailbox); !ok {
+		t.Fatal("expected mailbox to be held by the server")
+	}
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newSwapFile(alice, swap, defaultSwapExpiry, "")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := postMailbox(mailbox, stageCreated, f); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob accepts the swap from his mailbox, posting the result back to it
+	js, _ := json.Marshal(acceptRequest{Mailbox: mailbox})
+	resp, err := http.Post(srv.URL+"/api/accept", "application/json", bytes.NewReader(js))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("accept failed:", resp.Status)
+	}
+
+	// alice pulls bob's response and finishes the swap
+	msg, err := pullMailbox(mailbox, stageAccepted, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	af, err := decodeSwapEnvelope(msg.Envelope, nil, x25519Key{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(alice, &af.Swap); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, bob, af.Swap, swapTransactionConfirmed)
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	for _, addr := range []string{"localhost:8080", "127.0.0.1:8080", "[::1]:8080"} {
+		if !isLoopbackAddr(addr) {
+			t.Errorf("expected %q to be a loopback address", addr)
+		}
+	}
+	for _, addr := range []string{":8080", "0.0.0.0:8080", "192.168.1.2:8080", "example.com:8080", "localhost"} {
+		if isLoopbackAddr(addr) {
+			t.Errorf("expected %q not to be a loopback address", addr)
+		}
+	}
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/node/api"
+	"go.sia.tech/siad/types"
+)
+
+// renterd encodes transactions with go.sia.tech/core types. Their JSON mostly
+// matches siad's, since encoding/json matches field names case-insensitively,
+// but IDs, addresses, and public keys are hex strings with a type prefix
+// (e.g. "scoid:" or "addr:"), outputs, siafund claims, and signatures are
+// named differently, and siafund values are plain integers. These types
+// mirror the fields that differ.
+
+// marshalCoreHex encodes b as a hex string with the given prefix.
+func marshalCoreHex(prefix string, b []byte) ([]byte, error) {
+	return json.Marshal(prefix + ":" + hex.EncodeToString(b))
+}
+
+// unmarshalCoreHex decodes a hex string into dst, ignoring any prefix.
+func unmarshalCoreHex(dst []byte, js []byte) error {
+	var s string
+	if err := json.Unmarshal(js, &s); err != nil {
+		return err
+	}
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		s = s[i+1:]
+	}
+	if hex.DecodedLen(len(s)) != len(dst) {
+		return fmt.Errorf("wrong length for hex string %q", s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+type coreHash crypto.Hash
+
+func (h coreHash) MarshalJSON() ([]byte, error)  { return marshalCoreHex("h", h[:]) }
+func (h *coreHash) UnmarshalJSON(b []byte) error { return unmarshalCoreHex(h[:], b) }
+
+type coreSiacoinOutputID types.SiacoinOutputID
+
+func (id coreSiacoinOutputID) MarshalJSON() ([]byte, error)  { return marshalCoreHex("scoid", id[:]) }
+func (id *coreSiacoinOutputID) UnmarshalJSON(b []byte) error { return unmarshalCoreHex(id[:], b) }
+
+type coreSiafundOutputID types.SiafundOutputID
+
+func (id coreSiafundOutputID) MarshalJSON() ([]byte, error)  { return marshalCoreHex("sfoid", id[:]) }
+func (id *coreSiafundOutputID) UnmarshalJSON(b []byte) error { return unmarshalCoreHex(id[:], b) }
+
+// A coreAddress is an address, followed by the same checksum as siad's.
+type coreAddress types.UnlockHash
+
+func (a coreAddress) MarshalJSON() ([]byte, error) {
+	return json.Marshal("addr:" + types.UnlockHash(a).String())
+}
+
+func (a *coreAddress) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return (*types.UnlockHash)(a).LoadString(strings.TrimPrefix(s, "addr:"))
+}
+
+type renterdPublicKey types.SiaPublicKey
+
+func (pk renterdPublicKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(types.SiaPublicKey(pk).String())
+}
+
+func (pk *renterdPublicKey) UnmarshalJSON(b []byte) error {
+	return (*types.SiaPublicKey)(pk).UnmarshalJSON(b)
+}
+
+type renterdUnlockConditions struct {
+	Timelock           types.BlockHeight  `json:"timelock"`
+	PublicKeys         []renterdPublicKey `json:"publicKeys"`
+	SignaturesRequired uint64             `json:"signaturesRequired"`
+}
+
+func toRenterdUnlockConditions(uc types.UnlockConditions) renterdUnlockConditions {
+	ruc := renterdUnlockConditions{
+		Timelock:           uc.Timelock,
+		SignaturesRequired: uc.SignaturesRequired,
+	}
+	for _, pk := range uc.PublicKeys {
+		ruc.PublicKeys = append(ruc.PublicKeys, renterdPublicKey(pk))
+	}
+	return ruc
+}
+
+func (ruc renterdUnlockConditions) unlockConditions() types.UnlockConditions {
+	uc := types.UnlockConditions{
+		Timelock:           ruc.Timelock,
+		SignaturesRequired: ruc.SignaturesRequired,
+	}
+	for _, pk := range ruc.PublicKeys {
+		uc.PublicKeys = append(uc.PublicKeys, types.SiaPublicKey(pk))
+	}
+	return uc
+}
+
+type renterdSiacoinInput struct {
+	ParentID         coreSiacoinOutputID     `json:"parentID"`
+	UnlockConditions renterdUnlockConditions `json:"unlockConditions"`
+}
+
+type renterdSiacoinOutput struct {
+	Value   types.Currency `json:"value"`
+	Address coreAddress    `json:"address"`
+}
+
+type renterdSiafundInput struct {
+	ParentID         coreSiafundOutputID     `json:"parentID"`
+	UnlockConditions renterdUnlockConditions `json:"unlockConditions"`
+	ClaimAddress     coreAddress             `json:"claimAddress"`
+}
+
+type renterdSiafundOutput struct {
+	Value   uint64      `json:"value"`
+	Address coreAddress `json:"address"`
+}
+
+type renterdSignature struct {
+	ParentID       coreHash            `json:"parentID"`
+	PublicKeyIndex uint64              `json:"publicKeyIndex"`
+	Timelock       types.BlockHeight   `json:"timelock,omitempty"`
+	CoveredFields  types.CoveredFields `json:"coveredFields"`
+	Signature      []byte              `json:"signature"`
+}
+
+type renterdTransaction struct {
+	SiacoinInputs  []renterdSiacoinInput  `json:"siacoinInputs,omitempty"`
+	SiacoinOutputs []renterdSiacoinOutput `json:"siacoinOutputs,omitempty"`
+	SiafundInputs  []renterdSiafundInput  `json:"siafundInputs,omitempty"`
+	SiafundOutputs []renterdSiafundOutput `json:"siafundOutputs,omitempty"`
+	MinerFees      []types.Currency       `json:"minerFees,omitempty"`
+	ArbitraryData  [][]byte               `json:"arbitraryData,omitempty"`
+	Signatures     []renterdSignature     `json:"signatures,omitempty"`
+}
+
+// toRenterdTransaction converts txn to renterd's encoding. embc never builds
+// transactions with file contracts, so they are not converted.
+func toRenterdTransaction(txn types.Transaction) renterdTransaction {
+	rtxn := renterdTransaction{
+		MinerFees:     txn.MinerFees,
+		ArbitraryData: txn.ArbitraryData,
+	}
+	for _, sci := range txn.SiacoinInputs {
+		rtxn.SiacoinInputs = append(rtxn.SiacoinInputs, renterdSiacoinInput{coreSiacoinOutputID(sci.ParentID), toRenterdUnlockConditions(sci.UnlockConditions)})
+	}
+	for _, sco := range txn.SiacoinOutputs {
+		rtxn.SiacoinOutputs = append(rtxn.SiacoinOutputs, renterdSiacoinOutput{sco.Value, coreAddress(sco.UnlockHash)})
+	}
+	for _, sfi := range txn.SiafundInputs {
+		rtxn.SiafundInputs = append(rtxn.SiafundInputs, renterdSiafundInput{coreSiafundOutputID(sfi.ParentID), toRenterdUnlockConditions(sfi.UnlockConditions), coreAddress(sfi.ClaimUnlockHash)})
+	}
+	for _, sfo := range txn.SiafundOutputs {
+		rtxn.SiafundOutputs = append(rtxn.SiafundOutputs, renterdSiafundOutput{sfo.Value.Big().Uint64(), coreAddress(sfo.UnlockHash)})
+	}
+	for _, ts := range txn.TransactionSignatures {
+		rtxn.Signatures = append(rtxn.Signatures, renterdSignature{coreHash(ts.ParentID), ts.PublicKeyIndex, ts.Timelock, ts.CoveredFields, ts.Signature})
+	}
+	return rtxn
+}
+
+// transaction converts rtxn to a siad transaction.
+func (rtxn renterdTransaction) transaction() types.Transaction {
+	txn := types.Transaction{
+		MinerFees:     rtxn.MinerFees,
+		ArbitraryData: rtxn.ArbitraryData,
+	}
+	for _, sci := range rtxn.SiacoinInputs {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{ParentID: types.SiacoinOutputID(sci.ParentID), UnlockConditions: sci.UnlockConditions.unlockConditions()})
+	}
+	for _, sco := range rtxn.SiacoinOutputs {
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{Value: sco.Value, UnlockHash: types.UnlockHash(sco.Address)})
+	}
+	for _, sfi := range rtxn.SiafundInputs {
+		txn.SiafundInputs = append(txn.SiafundInputs, types.SiafundInput{ParentID: types.SiafundOutputID(sfi.ParentID), UnlockConditions: sfi.UnlockConditions.unlockConditions(), ClaimUnlockHash: types.UnlockHash(sfi.ClaimAddress)})
+	}
+	for _, sfo := range rtxn.SiafundOutputs {
+		txn.SiafundOutputs = append(txn.SiafundOutputs, types.SiafundOutput{Value: types.NewCurrency64(sfo.Value), UnlockHash: types.UnlockHash(sfo.Address)})
+	}
+	for _, sig := range rtxn.Signatures {
+		txn.TransactionSignatures = append(txn.TransactionSignatures, types.TransactionSignature{
+			ParentID:       crypto.Hash(sig.ParentID),
+			PublicKeyIndex: sig.PublicKeyIndex,
+			Timelock:       sig.Timelock,
+			CoveredFields:  sig.CoveredFields,
+			Signature:      sig.Signature,
+		})
+	}
+	return txn
+}
+
+// The following types mirror requests and responses of renterd's bus API.
+
+type renterdWalletResponse struct {
+	ScanHeight  uint64         `json:"scanHeight"`
+	Address     coreAddress    `json:"address"`
+	Spendable   types.Currency `json:"spendable"`
+	Confirmed   types.Currency `json:"confirmed"`
+	Unconfirmed types.Currency `json:"unconfirmed"`
+}
+
+type renterdSiacoinElement struct {
+	ID             coreHash       `json:"id"`
+	Value          types.Currency `json:"value"`
+	Address        coreAddress    `json:"address"`
+	MaturityHeight uint64         `json:"maturityHeight"`
+}
+
+type renterdWalletTransaction struct {
+	Raw   renterdTransaction `json:"raw"`
+	Index struct {
+		Height uint64 `json:"height"`
+	} `json:"index"`
+}
+
+type renterdSignRequest struct {
+	Transaction   renterdTransaction  `json:"transaction"`
+	ToSign        []coreHash          `json:"toSign"`
+	CoveredFields types.CoveredFields `json:"coveredFields"`
+}
+
+type renterdConsensusState struct {
+	BlockHeight uint64 `json:"blockHeight"`
+	Synced      bool   `json:"synced"`
+}
+
+// A jsonAPI is a client of a JSON API protected by a basic auth password, such
+// as those of renterd and hostd.
+type jsonAPI struct {
+	name     string
+	addr     string
+	password string
+	client   http.Client
+}
+
+// req makes an API request, decoding the response into resp if it is non-nil.
+func (a *jsonAPI) req(method, route string, body, resp interface{}) error {
+	var r io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(js)
+	}
+	req, err := http.NewRequest(method, a.addr+route, r)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("", a.password)
+	res, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%v returned %v: %s", a.name, res.Status, strings.TrimSpace(string(msg)))
+	} else if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// renterdBackend implements Backend using the wallet of a renterd node, via
+// its bus API. renterd's wallet holds a single address and no siafunds.
+type renterdBackend struct {
+	jsonAPI
+
+	mu sync.Mutex
+	uc *types.UnlockConditions
+}
+
+// processedForAddress returns the view of txn, confirmed at height, of a
+// wallet whose only address is addr.
+func processedForAddress(txn types.Transaction, height types.BlockHeight, addr types.UnlockHash) modules.ProcessedTransaction {
+	pt := modules.ProcessedTransaction{
+		Transaction:        txn,
+		TransactionID:      txn.ID(),
+		ConfirmationHeight: height,
+	}
+	for _, sci := range txn.SiacoinInputs {
+		pt.Inputs = append(pt.Inputs, modules.ProcessedInput{
+			ParentID:       types.OutputID(sci.ParentID),
+			FundType:       types.SpecifierSiacoinInput,
+			WalletAddress:  sci.UnlockConditions.UnlockHash() == addr,
+			RelatedAddress: sci.UnlockConditions.UnlockHash(),
+		})
+	}
+	return pt
+}
+
+// Address implements Wallet. renterd's wallet has only one address.
+func (b *renterdBackend) Address() (types.UnlockHash, error) {
+	var wr renterdWalletResponse
+	err := b.req("GET", "/wallet", nil, &wr)
+	return types.UnlockHash(wr.Address), err
+}
+
+// Addresses implements Wallet.
+func (b *renterdBackend) Addresses() ([]types.UnlockHash, error) {
+	addr, err := b.Address()
+	if err != nil {
+		return nil, err
+	}
+	return []types.UnlockHash{addr}, nil
+}
+
+// UnspentOutputs implements Wallet. renterd reports only confirmed outputs.
+func (b *renterdBackend) UnspentOutputs() ([]modules.UnspentOutput, error) {
+	cg, err := b.Consensus()
+	if err != nil {
+		return nil, err
+	}
+	var elems []renterdSiacoinElement
+	if err := b.req("GET", "/wallet/outputs", nil, &elems); err != nil {
+		return nil, err
+	}
+	var outputs []modules.UnspentOutput
+	for _, e := range elems {
+		if e.MaturityHeight > uint64(cg.Height) {
+			continue
+		}
+		outputs = append(outputs, modules.UnspentOutput{
+			ID:         types.OutputID(e.ID),
+			FundType:   types.SpecifierSiacoinOutput,
+			UnlockHash: types.UnlockHash(e.Address),
+			Value:      e.Value,
+		})
+	}
+	return outputs, nil
+}
+
+// UnconfirmedTransactions implements Wallet.
+func (b *renterdBackend) UnconfirmedTransactions() ([]modules.ProcessedTransaction, error) {
+	addr, err := b.Address()
+	if err != nil {
+		return nil, err
+	}
+	var pending []renterdTransaction
+	if err := b.req("GET", "/wallet/pending", nil, &pending); err != nil {
+		return nil, err
+	}
+	var pts []modules.ProcessedTransaction
+	for _, rtxn := range pending {
+		pts = append(pts, processedForAddress(rtxn.transaction(), types.BlockHeight(math.MaxUint64), addr))
+	}
+	return pts, nil
+}
+
+// UnlockConditions implements Wallet. renterd does not report the public key
+// of its address, but any transaction that spends from the address reveals
+// it, so the unlock conditions are taken from the wallet's history.
+func (b *renterdBackend) UnlockConditions(addr types.UnlockHash) (types.UnlockConditions, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.uc == nil || b.uc.UnlockHash() != addr {
+		uc, err := b.historicalUnlockConditions(addr)
+		if err != nil {
+			return types.UnlockConditions{}, err
+		}
+		b.uc = &uc
+	}
+	return *b.uc, nil
+}
+
+// historicalUnlockConditions returns the unlock conditions of addr from a
+// pending or confirmed wallet transaction that spends from it.
+func (b *renterdBackend) historicalUnlockConditions(addr types.UnlockHash) (types.UnlockConditions, error) {
+	var pending []renterdTransaction
+	if err := b.req("GET", "/wallet/pending", nil, &pending); err != nil {
+		return types.UnlockConditions{}, fmt.Errorf("failed to get pending transactions: %w", err)
+	}
+	var wts []renterdWalletTransaction
+	if err := b.req("GET", "/wallet/transactions", nil, &wts); err != nil {
+		return types.UnlockConditions{}, fmt.Errorf("failed to get wallet transactions: %w", err)
+	}
+	for _, wt := range wts {
+		pending = append(pending, wt.Raw)
+	}
+	for _, rtxn := range pending {
+		for _, sci := range rtxn.SiacoinInputs {
+			if uc := sci.UnlockConditions.unlockConditions(); uc.UnlockHash() == addr {
+				return uc, nil
+			}
+		}
+	}
+	return types.UnlockConditions{}, fmt.Errorf("renterd's wallet has never spent from %v, so its public key is unknown; send any amount from the wallet, e.g. to itself, and try again", addr)
+}
+
+// SignTransaction implements Wallet. Rather than filling in existing
+// signatures, renterd appends a new signature for each input it signs, with
+// the same covered fields for every input. So the blank signatures are
+// removed, signed in groups sharing the same covered fields, and the
+// resulting signatures moved back into place.
+func (b *renterdBackend) SignTransaction(txn *types.Transaction, toSign []crypto.Hash) error {
+	cg, err := b.Consensus()
+	if err != nil {
+		return fmt.Errorf("failed to get consensus height: %w", err)
+	}
+	sign := make(map[crypto.Hash]bool)
+	for _, id := range toSign {
+		sign[id] = true
+	}
+	unsigned := *txn
+	unsigned.TransactionSignatures = nil
+	var groups []types.CoveredFields
+	ids := make(map[string][]coreHash)
+	for _, sig := range txn.TransactionSignatures {
+		if !sign[sig.ParentID] {
+			unsigned.TransactionSignatures = append(unsigned.TransactionSignatures, sig)
+			continue
+		} else if sig.PublicKeyIndex != 0 || sig.Timelock != 0 {
+			return fmt.Errorf("renterd cannot sign input %v", sig.ParentID)
+		}
+		key := fmt.Sprint(sig.CoveredFields)
+		if _, ok := ids[key]; !ok {
+			groups = append(groups, sig.CoveredFields)
+		}
+		ids[key] = append(ids[key], coreHash(sig.ParentID))
+	}
+
+	signed := make(map[crypto.Hash][]byte)
+	for _, cf := range groups {
+		var resp renterdTransaction
+		err := b.req("POST", "/wallet/sign", renterdSignRequest{
+			Transaction:   toRenterdTransaction(unsigned),
+			ToSign:        ids[fmt.Sprint(cf)],
+			CoveredFields: cf,
+		}, &resp)
+		if err != nil {
+			return fmt.Errorf("failed to sign transaction: %w", err)
+		} else if len(resp.Signatures) < len(unsigned.TransactionSignatures) {
+			return errors.New("renterd removed signatures from the transaction")
+		}
+		for _, sig := range resp.Signatures[len(unsigned.TransactionSignatures):] {
+			signed[crypto.Hash(sig.ParentID)] = sig.Signature
+		}
+	}
+
+	ucs := make(map[crypto.Hash]types.UnlockConditions)
+	for _, sci := range txn.SiacoinInputs {
+		ucs[crypto.Hash(sci.ParentID)] = sci.UnlockConditions
+	}
+	for _, sfi := range txn.SiafundInputs {
+		ucs[crypto.Hash(sfi.ParentID)] = sfi.UnlockConditions
+	}
+	sigs := append([]types.TransactionSignature(nil), txn.TransactionSignatures...)
+	for i, ts := range sigs {
+		if !sign[ts.ParentID] {
+			continue
+		}
+		sig, ok := signed[ts.ParentID]
+		if !ok {
+			return fmt.Errorf("renterd did not sign input %v", ts.ParentID)
+		} else if err := verifySignature(ucs[ts.ParentID], ts.PublicKeyIndex, txn.SigHash(i, cg.Height), sig); err != nil {
+			return fmt.Errorf("renterd returned an invalid signature for input %v: %w", ts.ParentID, err)
+		}
+		sigs[i].Signature = sig
+	}
+	txn.TransactionSignatures = sigs
+	return nil
+}
+
+// Transaction implements Wallet.
+func (b *renterdBackend) Transaction(id types.TransactionID) (modules.ProcessedTransaction, error) {
+	pts, err := b.UnconfirmedTransactions()
+	if err != nil {
+		return modules.ProcessedTransaction{}, err
+	}
+	for _, pt := range pts {
+		if pt.TransactionID == id {
+			return pt, nil
+		}
+	}
+	addr, err := b.Address()
+	if err != nil {
+		return modules.ProcessedTransaction{}, err
+	}
+	var wts []renterdWalletTransaction
+	if err := b.req("GET", "/wallet/transactions", nil, &wts); err != nil {
+		return modules.ProcessedTransaction{}, err
+	}
+	for _, wt := range wts {
+		if txn := wt.Raw.transaction(); txn.ID() == id {
+			return processedForAddress(txn, types.BlockHeight(wt.Index.Height), addr), nil
+		}
+	}
+	return modules.ProcessedTransaction{}, errors.New("could not find transaction")
+}
+
+// Status implements Wallet.
+func (b *renterdBackend) Status() (api.WalletGET, error) {
+	var wr renterdWalletResponse
+	if err := b.req("GET", "/wallet", nil, &wr); err != nil {
+		return api.WalletGET{}, err
+	}
+	return api.WalletGET{
+		Encrypted:                   true,
+		Unlocked:                    true,
+		Height:                      types.BlockHeight(wr.ScanHeight),
+		ConfirmedSiacoinBalance:     wr.Confirmed,
+		UnconfirmedIncomingSiacoins: wr.Unconfirmed,
+	}, nil
+}
+
+// BroadcastTransaction implements Chain.
+func (b *renterdBackend) BroadcastTransaction(txn types.Transaction) error {
+	return b.req("POST", "/txpool/broadcast", []renterdTransaction{toRenterdTransaction(txn)}, nil)
+}
+
+// PoolTransactions implements Chain.
+func (b *renterdBackend) PoolTransactions() ([]types.Transaction, error) {
+	var rtxns []renterdTransaction
+	if err := b.req("GET", "/txpool/transactions", nil, &rtxns); err != nil {
+		return nil, err
+	}
+	txns := make([]types.Transaction, len(rtxns))
+	for i, rtxn := range rtxns {
+		txns[i] = rtxn.transaction()
+	}
+	return txns, nil
+}
+
+// Consensus implements Chain.
+func (b *renterdBackend) Consensus() (api.ConsensusGET, error) {
+	var cs renterdConsensusState
+	if err := b.req("GET", "/consensus/state", nil, &cs); err != nil {
+		return api.ConsensusGET{}, err
+	}
+	return api.ConsensusGET{
+		Synced: cs.Synced,
+		Height: types.BlockHeight(cs.BlockHeight),
+	}, nil
+}
+
+// FeeEstimate implements Chain. renterd recommends a single fee per byte.
+func (b *renterdBackend) FeeEstimate() (min, max types.Currency, err error) {
+	var fee types.Currency
+	err = b.req("GET", "/txpool/recommendedfee", nil, &fee)
+	return fee, fee, err
+}
+
+// newRenterdBackend returns a Backend that talks to the renterd bus API at
+// addr, e.g. http://localhost:9980/api/bus.
+func newRenterdBackend(addr, password string) *renterdBackend {
+	return &renterdBackend{
+		jsonAPI: jsonAPI{
+			name:     "renterd",
+			addr:     strings.TrimSuffix(addr, "/"),
+			password: password,
+			client:   http.Client{Timeout: time.Minute},
+		},
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+// newFakeRenterd returns a stand-in for renterd's bus API that serves the
+// first address of w as its wallet.
+func newFakeRenterd(w *memWallet, password string) *httptest.Server {
+	addr := w.addrs[0]
+	mux := http.NewServeMux()
+	handle := func(route string, fn func(req *http.Request) (interface{}, error)) {
+		mux.HandleFunc(route, func(rw http.ResponseWriter, req *http.Request) {
+			if _, p, _ := req.BasicAuth(); p != password {
+				http.Error(rw, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			resp, err := fn(req)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(rw).Encode(resp)
+		})
+	}
+
+	handle("/wallet", func(req *http.Request) (interface{}, error) {
+		sc, _ := w.balance()
+		cg, err := w.Consensus()
+		return renterdWalletResponse{
+			ScanHeight: uint64(cg.Height),
+			Address:    coreAddress(addr),
+			Spendable:  sc,
+			Confirmed:  sc,
+		}, err
+	})
+	handle("/wallet/outputs", func(req *http.Request) (interface{}, error) {
+		outputs, err := w.UnspentOutputs()
+		var elems []renterdSiacoinElement
+		for _, o := range outputs {
+			if o.FundType == types.SpecifierSiacoinOutput && o.UnlockHash == addr && o.ConfirmationHeight != unconfirmedHeight {
+				elems = append(elems, renterdSiacoinElement{
+					ID:      coreHash(o.ID),
+					Value:   o.Value,
+					Address: coreAddress(o.UnlockHash),
+				})
+			}
+		}
+		return elems, err
+	})
+	handle("/wallet/pending", func(req *http.Request) (interface{}, error) {
+		pts, err := w.UnconfirmedTransactions()
+		var pending []renterdTransaction
+		for _, pt := range pts {
+			pending = append(pending, toRenterdTransaction(pt.Transaction))
+		}
+		return pending, err
+	})
+	handle("/wallet/transactions", func(req *http.Request) (interface{}, error) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		var wts []renterdWalletTransaction
+		for _, pt := range w.txns {
+			if w.relevant(pt.Transaction) {
+				wt := renterdWalletTransaction{Raw: toRenterdTransaction(pt.Transaction)}
+				wt.Index.Height = uint64(pt.ConfirmationHeight)
+				wts = append(wts, wt)
+			}
+		}
+		return wts, nil
+	})
+	handle("/wallet/sign", func(req *http.Request) (interface{}, error) {
+		var sr renterdSignRequest
+		if err := json.NewDecoder(req.Body).Decode(&sr); err != nil {
+			return nil, err
+		}
+		// like renterd, append a new signature for each input
+		txn := sr.Transaction.transaction()
+		var toSign []crypto.Hash
+		for _, id := range sr.ToSign {
+			txn.TransactionSignatures = append(txn.TransactionSignatures, types.TransactionSignature{
+				ParentID:      crypto.Hash(id),
+				CoveredFields: sr.CoveredFields,
+			})
+			toSign = append(toSign, crypto.Hash(id))
+		}
+		if err := w.SignTransaction(&txn, toSign); err != nil {
+			return nil, err
+		}
+		return toRenterdTransaction(txn), nil
+	})
+	handle("/txpool/broadcast", func(req *http.Request) (interface{}, error) {
+		var rtxns []renterdTransaction
+		if err := json.NewDecoder(req.Body).Decode(&rtxns); err != nil {
+			return nil, err
+		}
+		for _, rtxn := range rtxns {
+			if err := w.BroadcastTransaction(rtxn.transaction()); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	handle("/txpool/transactions", func(req *http.Request) (interface{}, error) {
+		txns, err := w.PoolTransactions()
+		var rtxns []renterdTransaction
+		for _, txn := range txns {
+			rtxns = append(rtxns, toRenterdTransaction(txn))
+		}
+		return rtxns, err
+	})
+	handle("/txpool/recommendedfee", func(req *http.Request) (interface{}, error) {
+		_, max, err := w.FeeEstimate()
+		return max, err
+	})
+	handle("/consensus/state", func(req *http.Request) (interface{}, error) {
+		cg, err := w.Consensus()
+		return renterdConsensusState{BlockHeight: uint64(cg.Height), Synced: cg.Synced}, err
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRenterdEncoding(t *testing.T) {
+	w := newMemWallet(newMemChain(), types.ZeroCurrency, types.ZeroCurrency)
+	addr, _ := w.Address()
+	uc, _ := w.UnlockConditions(addr)
+	txn := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: types.SiacoinOutputID{1}, UnlockConditions: uc}},
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.SiacoinPrecision, UnlockHash: addr}},
+		SiafundInputs:  []types.SiafundInput{{ParentID: types.SiafundOutputID{2}, UnlockConditions: uc, ClaimUnlockHash: addr}},
+		SiafundOutputs: []types.SiafundOutput{{Value: types.NewCurrency64(7), UnlockHash: addr}},
+		MinerFees:      []types.Currency{types.SiacoinPrecision},
+		TransactionSignatures: []types.TransactionSignature{{
+			ParentID:      crypto.Hash{1},
+			CoveredFields: types.FullCoveredFields,
+			Signature:     []byte{1, 2, 3},
+		}},
+	}
+	js, err := json.Marshal(toRenterdTransaction(txn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"parentID":"scoid:`, `"publicKeys":["ed25519:`, `"address":"addr:` + addr.String(), `"claimAddress"`, `"value":7`, `"signatures":[{"parentID":"h:`} {
+		if !strings.Contains(string(js), s) {
+			t.Errorf("expected encoding to contain %s, got %s", s, js)
+		}
+	}
+	var rtxn renterdTransaction
+	if err := json.Unmarshal(js, &rtxn); err != nil {
+		t.Fatal(err)
+	} else if rtxn.transaction().ID() != txn.ID() {
+		t.Fatal("transaction changed after round trip")
+	}
+}
+
+func TestRenterdBackend(t *testing.T) {
+	sc := types.SiacoinPrecision.Mul64
+	c, alice, bob := newTestSwappers()
+	srv := newFakeRenterd(alice, "foo")
+	defer srv.Close()
+	if _, err := newRenterdBackend(srv.URL, "bar").Status(); err == nil {
+		t.Fatal("expected wrong password to be rejected")
+	}
+	b := newRenterdBackend(srv.URL, "foo")
+
+	// renterd's public key is unknown until its wallet spends from its
+	// address
+	addr := alice.addrs[0]
+	if err := checkWalletKey(b); err == nil {
+		t.Fatal("expected wallet key to be unknown without a spend from the wallet")
+	} else if _, err := createSwap(b, Basket{SC: sc(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{}); err == nil {
+		t.Fatal("expected createSwap to fail without a spend from the wallet")
+	}
+	outputs, err := alice.UnspentOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uc, _ := alice.UnlockConditions(addr)
+	txn := types.Transaction{MinerFees: []types.Currency{testMinerFee}}
+	for _, o := range outputs {
+		if o.FundType == types.SpecifierSiacoinOutput && o.UnlockHash == addr {
+			txn.SiacoinInputs = []types.SiacoinInput{{ParentID: types.SiacoinOutputID(o.ID), UnlockConditions: uc}}
+			txn.SiacoinOutputs = []types.SiacoinOutput{{UnlockHash: addr, Value: o.Value.Sub(testMinerFee)}}
+			txn.TransactionSignatures = []types.TransactionSignature{{ParentID: crypto.Hash(o.ID), CoveredFields: types.FullCoveredFields}}
+			break
+		}
+	}
+	if err := alice.SignTransaction(&txn, []crypto.Hash{txn.TransactionSignatures[0].ParentID}); err != nil {
+		t.Fatal(err)
+	} else if err := alice.BroadcastTransaction(txn); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	if err := checkWalletKey(b); err != nil {
+		t.Fatal(err)
+	}
+
+	swap, err := createSwap(b, Basket{SC: sc(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := checkFinish(b, swap, false); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(b, &swap); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, b, swap, swapTransactionPending)
+	c.mine()
+	checkStatus(t, b, swap, swapTransactionConfirmed)
+
+	// open offers are signed with partial covered fields
+	offer, err := createOffer(b, Basket{SC: sc(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := takeOffer(bob, bob, &offer, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	if aliceSC, aliceSF := alice.balance(); !aliceSF.Equals64(4) || aliceSC.Cmp(sc(800)) >= 0 {
+		t.Fatalf("alice has wrong balance after swaps: %v SC, %v SF", aliceSC.HumanString(), aliceSF)
+	}
+}
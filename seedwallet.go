@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/node/api"
+	"go.sia.tech/siad/types"
+)
+
+// seedLookahead is the number of addresses beyond those handed out by
+// Address that a seedWallet watches, so that outputs sent to addresses
+// generated by another wallet with the same seed are found.
+const seedLookahead = 100
+
+// A seedKey is a key derived from a wallet seed.
+type seedKey struct {
+	index uint64
+	uc    types.UnlockConditions
+	sk    crypto.SecretKey
+}
+
+// deriveSeedKey derives the key at index from seed, exactly as siad's wallet
+// does.
+func deriveSeedKey(seed modules.Seed, index uint64) seedKey {
+	sk, pk := crypto.GenerateKeyPairDeterministic(crypto.HashAll(seed, index))
+	return seedKey{
+		index: index,
+		uc: types.UnlockConditions{
+			PublicKeys:         []types.SiaPublicKey{types.Ed25519PublicKey(pk)},
+			SignaturesRequired: 1,
+		},
+		sk: sk,
+	}
+}
+
+// seedWalletState is the persisted state of a seedWallet.
+type seedWalletState struct {
+	CCID         modules.ConsensusChangeID      `json:"ccid"`
+	Height       types.BlockHeight              `json:"height"`
+	AddressIndex uint64                         `json:"addressIndex"`
+	Outputs      []modules.UnspentOutput        `json:"outputs"`
+	Transactions []modules.ProcessedTransaction `json:"transactions"`
+}
+
+// A seedWallet is a Wallet that derives its keys from a seed and signs
+// locally. It finds its outputs by scanning consensus changes, and its
+// unconfirmed transactions in the transaction pool of chain, so it needs no
+// wallet on the siad node it uses.
+type seedWallet struct {
+	seed  modules.Seed
+	chain Chain
+	path  string
+
+	mu           sync.Mutex
+	keys         map[types.UnlockHash]seedKey
+	ccid         modules.ConsensusChangeID
+	height       types.BlockHeight
+	addressIndex uint64
+	outputs      map[types.OutputID]modules.UnspentOutput
+	txns         map[types.TransactionID]modules.ProcessedTransaction
+}
+
+// save atomically writes the wallet's state to disk.
+func (w *seedWallet) save() error {
+	state := seedWalletState{
+		CCID:         w.ccid,
+		Height:       w.height,
+		AddressIndex: w.addressIndex,
+	}
+	for _, o := range w.outputs {
+		state.Outputs = append(state.Outputs, o)
+	}
+	for _, pt := range w.txns {
+		state.Transactions = append(state.Transactions, pt)
+	}
+	tmp := w.path + "_temp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := encodeJSON(f, state); err != nil {
+		return err
+	} else if err := f.Sync(); err != nil {
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.path)
+}
+
+// deriveKeys derives keys up to the lookahead window past the address index.
+func (w *seedWallet) deriveKeys() {
+	for i := uint64(len(w.keys)); i < w.addressIndex+seedLookahead; i++ {
+		key := deriveSeedKey(w.seed, i)
+		w.keys[key.uc.UnlockHash()] = key
+	}
+}
+
+// owns reports whether addr belongs to the wallet, advancing the address
+// index past it if necessary.
+func (w *seedWallet) owns(addr types.UnlockHash) bool {
+	key, ok := w.keys[addr]
+	if ok && key.index >= w.addressIndex {
+		w.addressIndex = key.index + 1
+		w.deriveKeys()
+	}
+	return ok
+}
+
+// relevant reports whether txn spends from or pays to the wallet.
+func (w *seedWallet) relevant(txn types.Transaction) bool {
+	var ours bool
+	for _, sci := range txn.SiacoinInputs {
+		ours = w.owns(sci.UnlockConditions.UnlockHash()) || ours
+	}
+	for _, sfi := range txn.SiafundInputs {
+		ours = w.owns(sfi.UnlockConditions.UnlockHash()) || ours
+	}
+	for _, sco := range txn.SiacoinOutputs {
+		ours = w.owns(sco.UnlockHash) || ours
+	}
+	for _, sfo := range txn.SiafundOutputs {
+		ours = w.owns(sfo.UnlockHash) || ours
+	}
+	return ours
+}
+
+// ProcessConsensusChange implements modules.ConsensusSetSubscriber.
+func (w *seedWallet) ProcessConsensusChange(cc modules.ConsensusChange) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, b := range cc.RevertedBlocks {
+		for _, txn := range b.Transactions {
+			delete(w.txns, txn.ID())
+		}
+	}
+	for i, b := range cc.AppliedBlocks {
+		height := cc.BlockHeight - types.BlockHeight(len(cc.AppliedBlocks)-1-i)
+		for _, txn := range b.Transactions {
+			if w.relevant(txn) {
+				w.txns[txn.ID()] = modules.ProcessedTransaction{
+					Transaction:           txn,
+					TransactionID:         txn.ID(),
+					ConfirmationHeight:    height,
+					ConfirmationTimestamp: b.Timestamp,
+				}
+			}
+		}
+	}
+	for _, diff := range cc.SiacoinOutputDiffs {
+		if !w.owns(diff.SiacoinOutput.UnlockHash) {
+			continue
+		} else if diff.Direction == modules.DiffRevert {
+			delete(w.outputs, types.OutputID(diff.ID))
+			continue
+		}
+		w.outputs[types.OutputID(diff.ID)] = modules.UnspentOutput{
+			ID:                 types.OutputID(diff.ID),
+			FundType:           types.SpecifierSiacoinOutput,
+			UnlockHash:         diff.SiacoinOutput.UnlockHash,
+			Value:              diff.SiacoinOutput.Value,
+			ConfirmationHeight: cc.BlockHeight,
+		}
+	}
+	for _, diff := range cc.SiafundOutputDiffs {
+		if !w.owns(diff.SiafundOutput.UnlockHash) {
+			continue
+		} else if diff.Direction == modules.DiffRevert {
+			delete(w.outputs, types.OutputID(diff.ID))
+			continue
+		}
+		w.outputs[types.OutputID(diff.ID)] = modules.UnspentOutput{
+			ID:                 types.OutputID(diff.ID),
+			FundType:           types.SpecifierSiafundOutput,
+			UnlockHash:         diff.SiafundOutput.UnlockHash,
+			Value:              diff.SiafundOutput.Value,
+			ConfirmationHeight: cc.BlockHeight,
+		}
+	}
+	w.ccid = cc.ID
+	w.height = cc.BlockHeight
+
+	// while catching up, save only occasionally
+	if cc.Synced || cc.BlockHeight%1000 == 0 {
+		if err := w.save(); err != nil {
+			log.Println("Warning: failed to save seed wallet:", err)
+		}
+	}
+}
+
+// Address implements Wallet.
+func (w *seedWallet) Address() (types.UnlockHash, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := deriveSeedKey(w.seed, w.addressIndex)
+	w.addressIndex++
+	w.deriveKeys()
+	if err := w.save(); err != nil {
+		return types.UnlockHash{}, fmt.Errorf("failed to save seed wallet: %w", err)
+	}
+	return key.uc.UnlockHash(), nil
+}
+
+// Addresses implements Wallet.
+func (w *seedWallet) Addresses() ([]types.UnlockHash, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	addrs := make([]types.UnlockHash, 0, w.addressIndex)
+	for addr, key := range w.keys {
+		if key.index < w.addressIndex {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// poolState returns the outputs spent by the transaction pool, and the pool's
+// transactions that are relevant to the wallet.
+func (w *seedWallet) poolState() (map[types.OutputID]bool, []types.Transaction, error) {
+	txns, err := w.chain.PoolTransactions()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get transaction pool: %w", err)
+	}
+	spent := make(map[types.OutputID]bool)
+	var relevant []types.Transaction
+	for _, txn := range txns {
+		for _, sci := range txn.SiacoinInputs {
+			spent[types.OutputID(sci.ParentID)] = true
+		}
+		for _, sfi := range txn.SiafundInputs {
+			spent[types.OutputID(sfi.ParentID)] = true
+		}
+		if w.relevant(txn) {
+			relevant = append(relevant, txn)
+		}
+	}
+	return spent, relevant, nil
+}
+
+// UnspentOutputs implements Wallet.
+func (w *seedWallet) UnspentOutputs() ([]modules.UnspentOutput, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	spent, pool, err := w.poolState()
+	if err != nil {
+		return nil, err
+	}
+	var outputs []modules.UnspentOutput
+	for _, o := range w.outputs {
+		if !spent[o.ID] {
+			outputs = append(outputs, o)
+		}
+	}
+	for _, txn := range pool {
+		for i, sco := range txn.SiacoinOutputs {
+			id := types.OutputID(txn.SiacoinOutputID(uint64(i)))
+			if _, ok := w.keys[sco.UnlockHash]; ok && !spent[id] {
+				outputs = append(outputs, modules.UnspentOutput{
+					ID:                 id,
+					FundType:           types.SpecifierSiacoinOutput,
+					UnlockHash:         sco.UnlockHash,
+					Value:              sco.Value,
+					ConfirmationHeight: types.BlockHeight(math.MaxUint64),
+				})
+			}
+		}
+		for i, sfo := range txn.SiafundOutputs {
+			id := types.OutputID(txn.SiafundOutputID(uint64(i)))
+			if _, ok := w.keys[sfo.UnlockHash]; ok && !spent[id] {
+				outputs = append(outputs, modules.UnspentOutput{
+					ID:                 id,
+					FundType:           types.SpecifierSiafundOutput,
+					UnlockHash:         sfo.UnlockHash,
+					Value:              sfo.Value,
+					ConfirmationHeight: types.BlockHeight(math.MaxUint64),
+				})
+			}
+		}
+	}
+	return outputs, nil
+}
+
+// unconfirmed returns the wallet's view of a transaction in the pool.
+func (w *seedWallet) unconfirmed(txn types.Transaction) modules.ProcessedTransaction {
+	pt := modules.ProcessedTransaction{
+		Transaction:        txn,
+		TransactionID:      txn.ID(),
+		ConfirmationHeight: types.BlockHeight(math.MaxUint64),
+	}
+	for _, sci := range txn.SiacoinInputs {
+		_, ours := w.keys[sci.UnlockConditions.UnlockHash()]
+		pt.Inputs = append(pt.Inputs, modules.ProcessedInput{
+			ParentID:       types.OutputID(sci.ParentID),
+			FundType:       types.SpecifierSiacoinInput,
+			WalletAddress:  ours,
+			RelatedAddress: sci.UnlockConditions.UnlockHash(),
+		})
+	}
+	for _, sfi := range txn.SiafundInputs {
+		_, ours := w.keys[sfi.UnlockConditions.UnlockHash()]
+		pt.Inputs = append(pt.Inputs, modules.ProcessedInput{
+			ParentID:       types.OutputID(sfi.ParentID),
+			FundType:       types.SpecifierSiafundInput,
+			WalletAddress:  ours,
+			RelatedAddress: sfi.UnlockConditions.UnlockHash(),
+		})
+	}
+	return pt
+}
+
+// UnconfirmedTransactions implements Wallet.
+func (w *seedWallet) UnconfirmedTransactions() ([]modules.ProcessedTransaction, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, pool, err := w.poolState()
+	if err != nil {
+		return nil, err
+	}
+	var pts []modules.ProcessedTransaction
+	for _, txn := range pool {
+		pts = append(pts, w.unconfirmed(txn))
+	}
+	return pts, nil
+}
+
+// UnlockConditions implements Wallet.
+func (w *seedWallet) UnlockConditions(addr types.UnlockHash) (types.UnlockConditions, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key, ok := w.keys[addr]
+	if !ok {
+		return types.UnlockConditions{}, errors.New("no record of UnlockConditions for that UnlockHash")
+	}
+	return key.uc, nil
+}
+
+// SignTransaction implements Wallet.
+func (w *seedWallet) SignTransaction(txn *types.Transaction, toSign []crypto.Hash) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ucs := make(map[crypto.Hash]types.UnlockConditions)
+	for _, sci := range txn.SiacoinInputs {
+		ucs[crypto.Hash(sci.ParentID)] = sci.UnlockConditions
+	}
+	for _, sfi := range txn.SiafundInputs {
+		ucs[crypto.Hash(sfi.ParentID)] = sfi.UnlockConditions
+	}
+	for _, id := range toSign {
+		uc, ok := ucs[id]
+		if !ok {
+			return errors.New("toSign references IDs not present in transaction")
+		}
+		key, ok := w.keys[uc.UnlockHash()]
+		if !ok {
+			return fmt.Errorf("could not locate signing key for %v", id)
+		}
+		var signed bool
+		for i, sig := range txn.TransactionSignatures {
+			if sig.ParentID == id {
+				sig := crypto.SignHash(txn.SigHash(i, w.height), key.sk)
+				txn.TransactionSignatures[i].Signature = sig[:]
+				signed = true
+			}
+		}
+		if !signed {
+			return errors.New("toSign references signatures not present in transaction")
+		}
+	}
+	return nil
+}
+
+// Transaction implements Wallet.
+func (w *seedWallet) Transaction(id types.TransactionID) (modules.ProcessedTransaction, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if pt, ok := w.txns[id]; ok {
+		return pt, nil
+	}
+	_, pool, err := w.poolState()
+	if err != nil {
+		return modules.ProcessedTransaction{}, err
+	}
+	for _, txn := range pool {
+		if txn.ID() == id {
+			return w.unconfirmed(txn), nil
+		}
+	}
+	return modules.ProcessedTransaction{}, errors.New("could not find transaction")
+}
+
+// Status implements Wallet.
+func (w *seedWallet) Status() (api.WalletGET, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wg := api.WalletGET{
+		Encrypted: true,
+		Unlocked:  true,
+		Height:    w.height,
+	}
+	for _, o := range w.outputs {
+		if o.FundType == types.SpecifierSiacoinOutput {
+			wg.ConfirmedSiacoinBalance = wg.ConfirmedSiacoinBalance.Add(o.Value)
+		} else {
+			wg.SiafundBalance = wg.SiafundBalance.Add(o.Value)
+		}
+	}
+	return wg, nil
+}
+
+// newSeedWallet returns a seedWallet for seed that stores its state in dir,
+// loading any state saved by a previous run.
+func newSeedWallet(seed modules.Seed, chain Chain, dir string) (*seedWallet, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// each seed keeps its own state, named after its first address
+	first := deriveSeedKey(seed, 0).uc.UnlockHash()
+	w := &seedWallet{
+		seed:    seed,
+		chain:   chain,
+		path:    filepath.Join(dir, fmt.Sprintf("seed_%x.json", first[:4])),
+		keys:    make(map[types.UnlockHash]seedKey),
+		outputs: make(map[types.OutputID]modules.UnspentOutput),
+		txns:    make(map[types.TransactionID]modules.ProcessedTransaction),
+	}
+	f, err := os.Open(w.path)
+	if err == nil {
+		defer f.Close()
+		var state seedWalletState
+		if err := json.NewDecoder(f).Decode(&state); err != nil {
+			return nil, fmt.Errorf("failed to decode seed wallet: %w", err)
+		}
+		w.ccid, w.height, w.addressIndex = state.CCID, state.Height, state.AddressIndex
+		for _, o := range state.Outputs {
+			w.outputs[o.ID] = o
+		}
+		for _, pt := range state.Transactions {
+			w.txns[pt.TransactionID] = pt
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	w.deriveKeys()
+	return w, nil
+}
+
+// seedBackend implements Backend with a seedWallet, using siad only for its
+// consensus set and transaction pool.
+type seedBackend struct {
+	*seedWallet
+	Chain
+}
+
+// openSeedBackend returns a seedBackend for seed that stores its state in dir
+// and tracks the consensus set of the siad node behind s. It blocks until the
+// wallet has caught up with siad.
+func openSeedBackend(s *siadBackend, seed modules.Seed, dir string) (Backend, error) {
+	w, err := newSeedWallet(seed, s, dir)
+	if err != nil {
+		return nil, err
+	}
+	errCh, _ := s.c.ConsensusSetSubscribe(w, w.ccid, nil)
+	if err := <-errCh; err != nil {
+		return nil, fmt.Errorf("failed to scan siad's consensus set: %w", err)
+	}
+	w.mu.Lock()
+	err = w.save()
+	w.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to save seed wallet: %w", err)
+	}
+	go func() {
+		if err := <-errCh; err != nil {
+			log.Println("Warning: seed wallet stopped following siad's consensus set:", err)
+		}
+	}()
+	return seedBackend{w, s}, nil
+}
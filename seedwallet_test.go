@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+)
+
+func TestSeedWallet(t *testing.T) {
+	seed := modules.Seed{1, 2, 3}
+	dir := t.TempDir()
+	w, err := newSeedWallet(seed, newMemChain(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an output paid to an address within the lookahead window is found,
+	// and the address is then reported as the wallet's own
+	addr := deriveSeedKey(seed, 5).uc.UnlockHash()
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision.Mul64(100)}},
+		SiafundOutputs: []types.SiafundOutput{{UnlockHash: addr, Value: types.NewCurrency64(2)}},
+	}
+	block := types.Block{Transactions: []types.Transaction{txn}}
+	applied := modules.ConsensusChangeDiffs{
+		SiacoinOutputDiffs: []modules.SiacoinOutputDiff{{Direction: modules.DiffApply, ID: txn.SiacoinOutputID(0), SiacoinOutput: txn.SiacoinOutputs[0]}},
+		SiafundOutputDiffs: []modules.SiafundOutputDiff{{Direction: modules.DiffApply, ID: txn.SiafundOutputID(0), SiafundOutput: txn.SiafundOutputs[0]}},
+	}
+	w.ProcessConsensusChange(modules.ConsensusChange{
+		ID:                   modules.ConsensusChangeID{1},
+		BlockHeight:          10,
+		AppliedBlocks:        []types.Block{block},
+		ConsensusChangeDiffs: applied,
+		Synced:               true,
+	})
+	if outputs, _ := w.UnspentOutputs(); len(outputs) != 2 {
+		t.Fatalf("expected 2 unspent outputs, got %v", len(outputs))
+	} else if addrs, _ := w.Addresses(); len(addrs) != 6 {
+		t.Fatalf("expected 6 addresses, got %v", len(addrs))
+	} else if pt, err := w.Transaction(txn.ID()); err != nil || pt.ConfirmationHeight != 10 {
+		t.Fatalf("expected transaction confirmed at height 10, got %v (%v)", pt.ConfirmationHeight, err)
+	} else if next, _ := w.Address(); next != deriveSeedKey(seed, 6).uc.UnlockHash() {
+		t.Fatal("expected the next address to follow the last one used")
+	}
+
+	// the wallet signs a spend of its output
+	uc, err := w.UnlockConditions(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spend := types.Transaction{
+		SiacoinInputs:         []types.SiacoinInput{{ParentID: txn.SiacoinOutputID(0), UnlockConditions: uc}},
+		MinerFees:             []types.Currency{types.SiacoinPrecision.Mul64(100)},
+		TransactionSignatures: []types.TransactionSignature{{ParentID: crypto.Hash(txn.SiacoinOutputID(0)), CoveredFields: types.FullCoveredFields}},
+	}
+	if err := w.SignTransaction(&spend, []crypto.Hash{crypto.Hash(txn.SiacoinOutputID(0))}); err != nil {
+		t.Fatal(err)
+	} else if err := spend.StandaloneValid(10); err != nil {
+		t.Fatal(err)
+	}
+
+	// the wallet's state survives a restart
+	w, err = newSeedWallet(seed, newMemChain(), dir)
+	if err != nil {
+		t.Fatal(err)
+	} else if st, _ := w.Status(); !st.ConfirmedSiacoinBalance.Equals(types.SiacoinPrecision.Mul64(100)) || !st.SiafundBalance.Equals(types.NewCurrency64(2)) {
+		t.Fatalf("wrong balance after restart: %v, %v SF", st.ConfirmedSiacoinBalance.HumanString(), st.SiafundBalance)
+	} else if w.ccid != (modules.ConsensusChangeID{1}) || w.addressIndex != 7 {
+		t.Fatalf("wrong state after restart: ccid %v, address index %v", w.ccid, w.addressIndex)
+	}
+
+	// reverting the block removes its outputs and transaction
+	reverted := applied
+	reverted.SiacoinOutputDiffs = []modules.SiacoinOutputDiff{applied.SiacoinOutputDiffs[0]}
+	reverted.SiacoinOutputDiffs[0].Direction = modules.DiffRevert
+	reverted.SiafundOutputDiffs = []modules.SiafundOutputDiff{applied.SiafundOutputDiffs[0]}
+	reverted.SiafundOutputDiffs[0].Direction = modules.DiffRevert
+	w.ProcessConsensusChange(modules.ConsensusChange{
+		ID:                   modules.ConsensusChangeID{2},
+		BlockHeight:          9,
+		RevertedBlocks:       []types.Block{block},
+		ConsensusChangeDiffs: reverted,
+		Synced:               true,
+	})
+	if outputs, _ := w.UnspentOutputs(); len(outputs) != 0 {
+		t.Fatalf("expected no unspent outputs, got %v", len(outputs))
+	} else if _, err := w.Transaction(txn.ID()); err == nil {
+		t.Fatal("expected reverted transaction to be forgotten")
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -12,6 +15,7 @@ import (
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"go.sia.tech/siad/types"
 )
 
 func writeJSON(w http.ResponseWriter, v interface{}) {
@@ -25,91 +29,518 @@ func writeError(w http.ResponseWriter, err string, code int) {
 	http.Error(w, err, code)
 }
 
+// A server serves the embc API using a Backend, recording swaps in a journal.
+type server struct {
+	backend   Backend
+	journal   *journal
+	whitelist *whitelist
+	// password authenticates changes to the whitelist; if empty, the
+	// whitelist cannot be changed through the API.
+	password string
+	key      x25519Key
+	// peer holds mailboxes through which peers exchange swaps with this
+	// server directly, served under peerURL.
+	peer    *relayServer
+	peerURL string
+
+	watcher *watcher
+}
+
+// localMailbox returns the ID of mailbox if it is held by s.peer.
+func (s *server) localMailbox(mailbox string) (string, bool) {
+	prefix := s.peerURL + "/mailbox/"
+	if s.peer == nil || !strings.HasPrefix(mailbox, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(mailbox, prefix), true
+}
+
+// postMailbox posts envelope to mailbox, which may be held locally.
+func (s *server) postMailbox(mailbox, stage string, envelope interface{}) error {
+	id, ok := s.localMailbox(mailbox)
+	if !ok {
+		return postMailbox(mailbox, stage, envelope)
+	}
+	js, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	_, err = s.peer.post(id, RelayMessage{Stage: stage, Envelope: js})
+	return err
+}
+
+// pullMailbox returns the latest message at stage in mailbox, which may be
+// held locally.
+func (s *server) pullMailbox(mailbox, stage string) (RelayMessage, error) {
+	id, ok := s.localMailbox(mailbox)
+	if !ok {
+		return pullMailbox(mailbox, stage, false)
+	}
+	msgs, _, err := s.peer.messages(id, 0)
+	if err != nil {
+		return RelayMessage{}, err
+	} else if msg, ok := latestMessage(msgs, stage); ok {
+		return msg, nil
+	}
+	return RelayMessage{}, errNoSwapPosted
+}
+
+// shareSwap wraps the swap of rec in a new swap file, encrypted as selected
+// by opts, and posts it to mailbox, if set. It returns the encrypted file, if
+// any.
+func (s *server) shareSwap(rec SwapRecord, opts EncryptionOptions, mailbox string) (*EncryptedSwapFile, error) {
+	if !opts.encrypted() && mailbox == "" {
+		return nil, nil
+	}
+	swap := rec.Swap
+	f, err := newSwapFile(s.backend, swap, defaultSwapExpiry, "")
+	if err != nil {
+		return nil, err
+	}
+	recordExpiry(s.journal, rec.ID, f)
+	envelope, _, err := sealSwapFile(f, opts)
+	if err != nil {
+		return nil, err
+	}
+	if mailbox != "" {
+		sum, err := summarize(s.backend, swap)
+		if err != nil {
+			return nil, err
+		} else if err := s.postMailbox(mailbox, swapStage(sum), envelope); err != nil {
+			return nil, err
+		}
+	}
+	if ef, ok := envelope.(EncryptedSwapFile); ok {
+		return &ef, nil
+	}
+	return nil, nil
+}
+
+// openSwap decodes a swap file, decrypting it with passphrase or the
+// server's key if necessary, and checks that it is still valid.
+func (s *server) openSwap(js []byte, passphrase string) (SwapTransaction, error) {
+	f, err := decodeSwapEnvelope(js, func() (string, error) {
+		if passphrase == "" {
+			return "", errors.New("swap file is encrypted with a passphrase")
+		}
+		return passphrase, nil
+	}, s.key)
+	if err != nil {
+		return SwapTransaction{}, err
+	} else if err := checkSwapFile(s.backend, f); err != nil {
+		return SwapTransaction{}, err
+	}
+	return f.Swap, nil
+}
+
+// receiveSwap returns the swap to act on: the latest swap at stage in
+// mailbox, if set, or else the encrypted file ef, if set, or else swap.
+func (s *server) receiveSwap(swap SwapTransaction, ef *EncryptedSwapFile, mailbox, stage, passphrase string) (SwapTransaction, error) {
+	if mailbox != "" {
+		msg, err := s.pullMailbox(mailbox, stage)
+		if err != nil {
+			return SwapTransaction{}, err
+		}
+		return s.openSwap(msg.Envelope, passphrase)
+	} else if ef != nil {
+		js, err := json.Marshal(ef)
+		if err != nil {
+			return SwapTransaction{}, err
+		}
+		return s.openSwap(js, passphrase)
+	}
+	return swap, nil
+}
+
 type createRequest struct {
-	Offer   string `json:"offer"`
-	Receive string `json:"receive"`
+	Offer            string        `json:"offer"`
+	Receive          string        `json:"receive"`
+	Fee              string        `json:"fee"`
+	FeePayer         string        `json:"feePayer"`
+	CoinSelection    string        `json:"coinSelection"`
+	DustThreshold    string        `json:"dustThreshold"`
+	AllowUnconfirmed bool          `json:"allowUnconfirmed"`
+	Open             bool          `json:"open"`
+	Destinations     []Destination `json:"destinations"`
+	Label            string        `json:"label"`
+	Notes            string        `json:"notes"`
+	// Encrypt, if set, also returns the swap as an encrypted swap file.
+	Encrypt EncryptionOptions `json:"encrypt"`
+	// Relay, if set, is the URL of a relay on which to create a mailbox for
+	// the swap. If Share is set, the mailbox is instead held by this server,
+	// so that a peer can exchange the swap with it directly.
+	Relay string `json:"relay"`
+	Share bool   `json:"share"`
 }
 
 type createResponse struct {
-	Swap SwapTransaction `json:"swap"`
+	SwapID    string             `json:"swapID"`
+	Swap      SwapTransaction    `json:"swap"`
+	Encrypted *EncryptedSwapFile `json:"encrypted,omitempty"`
+	Mailbox   string             `json:"mailbox,omitempty"`
 }
 
-func createHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (s *server) createHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var cr createRequest
 	if err := json.NewDecoder(r.Body).Decode(&cr); err != nil {
 		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if strings.Contains(cr.Offer, "SF") == strings.Contains(cr.Receive, "SF") {
-		writeError(w, "Invalid swap: must specify one SC value and one SF value", http.StatusBadRequest)
+	send, err := parseBasket(cr.Offer)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	receive, err := parseBasket(cr.Receive)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fee, err := parseMinerFee(s.backend, cr.Fee)
+	if err != nil && cr.Fee != "" {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	input, output := parseCurrency(cr.Offer), parseCurrency(cr.Receive)
-	swap, err := createSwap(input, output, strings.Contains(cr.Offer, "SF"))
+	opts, err := parseFundingOptions(cr.CoinSelection, cr.DustThreshold, cr.AllowUnconfirmed)
 	if err != nil {
 		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	opts.destinations = cr.Destinations
+	if err := cr.Encrypt.check(); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var mailbox string
+	if cr.Share {
+		if s.peer == nil {
+			writeError(w, "sharing a swap requires the server to be started with -peer-addr", http.StatusBadRequest)
+			return
+		}
+		id, err := s.peer.create()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		mailbox = s.peerURL + "/mailbox/" + id
+	} else if cr.Relay != "" {
+		if mailbox, err = createMailbox(cr.Relay); err != nil {
+			writeError(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	create := createSwap
+	if cr.Open {
+		create = createOffer
+	}
+	rec, err := s.journal.FundSwap(s.backend, stageCreated, func(w Wallet) (SwapTransaction, error) {
+		return create(w, send, receive, fee, cr.FeePayer, opts)
+	})
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	annotateSwap(s.journal, rec.ID, cr.Label, cr.Notes)
+	ef, err := s.shareSwap(rec, cr.Encrypt, mailbox)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	writeJSON(w, createResponse{
-		Swap: swap,
+		SwapID:    rec.ID,
+		Swap:      rec.Swap,
+		Encrypted: ef,
+		Mailbox:   mailbox,
 	})
 }
 
 type acceptRequest struct {
-	Swap SwapTransaction `json:"swap"`
+	Swap             SwapTransaction `json:"swap"`
+	CoinSelection    string          `json:"coinSelection"`
+	DustThreshold    string          `json:"dustThreshold"`
+	AllowUnconfirmed bool            `json:"allowUnconfirmed"`
+	Destinations     []Destination   `json:"destinations"`
+	Label            string          `json:"label"`
+	Notes            string          `json:"notes"`
+	// Encrypted, if set, is decrypted with Passphrase or the server's key
+	// and used in place of Swap. If Mailbox is set, the swap is instead
+	// pulled from the mailbox, and the accepted swap is posted back to it.
+	Encrypted  *EncryptedSwapFile `json:"encrypted"`
+	Mailbox    string             `json:"mailbox"`
+	Passphrase string             `json:"passphrase"`
+	Encrypt    EncryptionOptions  `json:"encrypt"`
 }
 
 type acceptResponse struct {
-	ID   string          `json:"id"`
-	Swap SwapTransaction `json:"swap"`
+	ID        string             `json:"id"`
+	SwapID    string             `json:"swapID"`
+	Swap      SwapTransaction    `json:"swap"`
+	Encrypted *EncryptedSwapFile `json:"encrypted,omitempty"`
 }
 
-func acceptHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (s *server) acceptHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var ar acceptRequest
 	if err := json.NewDecoder(r.Body).Decode(&ar); err != nil {
 		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := checkAccept(ar.Swap); err != nil {
+	swap, err := s.receiveSwap(ar.Swap, ar.Encrypted, ar.Mailbox, stageCreated, ar.Passphrase)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ar.Swap = swap
+	if err := ar.Encrypt.check(); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err := checkAccept(ar.Swap); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := parseFundingOptions(ar.CoinSelection, ar.DustThreshold, ar.AllowUnconfirmed)
+	if err != nil {
 		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := acceptSwap(&ar.Swap); err != nil {
+	opts.destinations = ar.Destinations
+	stage, accept := stageAccepted, func(w Wallet) error {
+		return acceptSwap(w, &ar.Swap, opts)
+	}
+	if isOpenOffer(ar.Swap) {
+		// filling an open offer also broadcasts it
+		stage, accept = stageBroadcast, func(w Wallet) error {
+			return takeOffer(w, s.backend, &ar.Swap, opts)
+		}
+	}
+	rec, err := s.journal.FundSwap(s.backend, stage, func(w Wallet) (SwapTransaction, error) {
+		err := accept(w)
+		return ar.Swap, err
+	})
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	annotateSwap(s.journal, rec.ID, ar.Label, ar.Notes)
+	ef, err := s.shareSwap(rec, ar.Encrypt, ar.Mailbox)
+	if err != nil {
 		writeError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	writeJSON(w, acceptResponse{
-		ID:   ar.Swap.transaction().ID().String(),
-		Swap: ar.Swap,
+		ID:        rec.TxnID.String(),
+		SwapID:    rec.ID,
+		Swap:      rec.Swap,
+		Encrypted: ef,
 	})
 }
 
 type finishRequest struct {
 	Swap SwapTransaction `json:"swap"`
+	// Encrypted, if set, is decrypted with Passphrase or the server's key
+	// and used in place of Swap. If Mailbox is set, the swap is instead
+	// pulled from the mailbox, and the broadcast swap is posted back to it.
+	Encrypted  *EncryptedSwapFile `json:"encrypted"`
+	Mailbox    string             `json:"mailbox"`
+	Passphrase string             `json:"passphrase"`
 }
 
 type finishResponse struct {
-	ID   string          `json:"id"`
-	Swap SwapTransaction `json:"swap"`
+	ID     string          `json:"id"`
+	SwapID string          `json:"swapID"`
+	Swap   SwapTransaction `json:"swap"`
 }
 
-func finishHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (s *server) finishHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var fr finishRequest
 	if err := json.NewDecoder(r.Body).Decode(&fr); err != nil {
 		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := checkFinish(fr.Swap, false); err != nil {
+	swap, err := s.receiveSwap(fr.Swap, fr.Encrypted, fr.Mailbox, stageAccepted, fr.Passphrase)
+	if err != nil {
 		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := finishSwap(&fr.Swap); err != nil {
+	fr.Swap = swap
+	if err := checkFinish(s.backend, fr.Swap, false); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err := s.journal.CheckChangeFee(fr.Swap); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := finishSwap(s.backend, &fr.Swap); err != nil {
+		if fullySigned(fr.Swap) {
+			recordSwap(s.journal, fr.Swap, stageFinished)
+		}
 		writeError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	rec := recordSwap(s.journal, fr.Swap, stageBroadcast)
+	if _, err := s.shareSwap(rec, EncryptionOptions{}, fr.Mailbox); err != nil {
+		log.Println("Warning: failed to post broadcast swap to mailbox:", err)
+	}
 	writeJSON(w, finishResponse{
-		ID:   fr.Swap.transaction().ID().String(),
-		Swap: fr.Swap,
+		ID:     fr.Swap.transaction().ID().String(),
+		SwapID: rec.ID,
+		Swap:   fr.Swap,
+	})
+}
+
+type cancelRequest struct {
+	Swap SwapTransaction `json:"swap"`
+}
+
+type cancelResponse struct {
+	ID     string `json:"id"`
+	SwapID string `json:"swapID"`
+}
+
+func (s *server) cancelHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var cr cancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&cr); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch status(s.backend, cr.Swap) {
+	case swapTransactionPending, swapTransactionConfirmed:
+		writeError(w, "swap transaction has already been broadcast", http.StatusBadRequest)
+		return
+	case swapCancelled:
+		writeError(w, "swap has already been cancelled", http.StatusBadRequest)
+		return
+	}
+	txn, err := cancelSwap(s.backend, cr.Swap)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rec, err := s.journal.Cancel(cr.Swap, txn.ID())
+	if err != nil {
+		log.Println("Warning: failed to record swap in journal:", err)
+	}
+	writeJSON(w, cancelResponse{
+		ID:     txn.ID().String(),
+		SwapID: rec.ID,
+	})
+}
+
+type batchCreateRequest struct {
+	Participants []string `json:"participants"`
+}
+
+func (s *server) batchCreateHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var br batchCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&br); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var participants []BatchParticipant
+	for _, terms := range br.Participants {
+		p, err := parseBatchTerms(terms)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		participants = append(participants, p)
+	}
+	batch, err := createBatch(participants)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, batch)
+}
+
+type batchJoinRequest struct {
+	Batch            BatchSwap     `json:"batch"`
+	Name             string        `json:"name"`
+	CoinSelection    string        `json:"coinSelection"`
+	AllowUnconfirmed bool          `json:"allowUnconfirmed"`
+	Destinations     []Destination `json:"destinations"`
+}
+
+func (s *server) batchJoinHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var br batchJoinRequest
+	if err := json.NewDecoder(r.Body).Decode(&br); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := parseFundingOptions(br.CoinSelection, "", br.AllowUnconfirmed)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.destinations = br.Destinations
+	if _, err := reserveBatchJoin(s.backend, s.journal, &br.Batch, br.Name, opts); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, br.Batch)
+}
+
+type batchSignRequest struct {
+	Batch BatchSwap `json:"batch"`
+	Name  string    `json:"name"`
+}
+
+type batchResponse struct {
+	ID     string    `json:"id"`
+	Status string    `json:"status"`
+	Batch  BatchSwap `json:"batch"`
+}
+
+func (s *server) batchSignHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var br batchSignRequest
+	if err := json.NewDecoder(r.Body).Decode(&br); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := finishBatch(s.backend, &br.Batch, br.Name); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stage := stageFinished
+	if batchFullySigned(br.Batch) {
+		stage = stageBroadcast
+	}
+	recordSwap(s.journal, br.Batch.journalSwap(br.Name), stage)
+	writeJSON(w, batchResponse{
+		ID:     br.Batch.transaction().ID().String(),
+		Status: batchStatus(s.backend, br.Batch),
+		Batch:  br.Batch,
+	})
+}
+
+type batchMergeRequest struct {
+	Batches []BatchSwap `json:"batches"`
+}
+
+func (s *server) batchMergeHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var br batchMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&br); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	batch, err := mergeBatches(br.Batches...)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if batchFullySigned(batch) {
+		if err := s.backend.BroadcastTransaction(batch.transaction()); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordSwap(s.journal, batch.journalSwap(batch.owner(s.backend)), stageBroadcast)
+	}
+	writeJSON(w, batchResponse{
+		ID:     batch.transaction().ID().String(),
+		Status: batchStatus(s.backend, batch),
+		Batch:  batch,
 	})
 }
 
@@ -122,13 +553,13 @@ type summarizeResponse struct {
 	Summary SwapSummary `json:"summary"`
 }
 
-func summarizeHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (s *server) summarizeHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var fr summarizeRequest
 	if err := json.NewDecoder(r.Body).Decode(&fr); err != nil {
 		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	summary, err := summarize(fr.Swap)
+	summary, err := summarize(s.backend, fr.Swap)
 	if err != nil {
 		writeError(w, err.Error(), http.StatusBadRequest)
 		return
@@ -139,8 +570,84 @@ func summarizeHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Param
 	})
 }
 
-func walletHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	c, err := siad.WalletGet()
+func (s *server) swapsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := s.journal.Refresh(s.backend); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, s.journal.Swaps())
+}
+
+func (s *server) swapHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := s.journal.Refresh(s.backend); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rec, err := s.journal.Swap(ps.ByName("id"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, rec)
+}
+
+type annotateRequest struct {
+	Label string `json:"label"`
+	Notes string `json:"notes"`
+}
+
+func (s *server) annotateHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ar annotateRequest
+	if err := json.NewDecoder(r.Body).Decode(&ar); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec, err := s.journal.Annotate(ps.ByName("id"), ar.Label, ar.Notes)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, rec)
+}
+
+type whitelistRequest struct {
+	Add    []types.UnlockHash `json:"add"`
+	Remove []types.UnlockHash `json:"remove"`
+}
+
+func (s *server) whitelistHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, s.whitelist.Addresses())
+}
+
+// updateWhitelistHandler adds and removes whitelisted addresses. Since the
+// whitelist decides where swap proceeds may be paid, changes must be
+// authenticated with the API password.
+func (s *server) updateWhitelistHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if s.password == "" {
+		writeError(w, "whitelist changes through the API require EMBC_API_PASSWORD to be set; use 'embc whitelist' instead", http.StatusForbidden)
+		return
+	} else if _, pass, ok := r.BasicAuth(); !ok || subtle.ConstantTimeCompare([]byte(pass), []byte(s.password)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="embc"`)
+		writeError(w, "incorrect API password", http.StatusUnauthorized)
+		return
+	}
+	var wr whitelistRequest
+	if err := json.NewDecoder(r.Body).Decode(&wr); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.whitelist.Add(wr.Add...); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if err := s.whitelist.Remove(wr.Remove...); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, s.whitelist.Addresses())
+}
+
+func (s *server) walletHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	c, err := s.backend.Status()
 	if err != nil {
 		writeError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -148,8 +655,8 @@ func walletHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 	writeJSON(w, c)
 }
 
-func consensusHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	c, err := siad.ConsensusGet()
+func (s *server) consensusHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	c, err := s.backend.Consensus()
 	if err != nil {
 		writeError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -157,14 +664,91 @@ func consensusHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Param
 	writeJSON(w, c)
 }
 
-func serve(addr string, dev bool) {
+type mailboxRequest struct {
+	Mailbox    string `json:"mailbox"`
+	Stage      string `json:"stage"`
+	Passphrase string `json:"passphrase"`
+}
+
+// mailboxHandler returns the latest swap at a stage in a relay mailbox, so
+// that the UI can poll for the counterparty's response.
+func (s *server) mailboxHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var mr mailboxRequest
+	if err := json.NewDecoder(r.Body).Decode(&mr); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if mr.Mailbox == "" {
+		writeError(w, "no mailbox specified", http.StatusBadRequest)
+		return
+	}
+	swap, err := s.receiveSwap(SwapTransaction{}, nil, mr.Mailbox, mr.Stage, mr.Passphrase)
+	if errors.Is(err, errNoSwapPosted) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, swap)
+}
+
+func (s *server) keyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, s.key.PublicKey())
+}
+
+// serve serves the web UI and wallet API on addr, which must be a loopback
+// address, since the API is unauthenticated. If peerAddr is set, mailboxes
+// through which peers exchange swaps with the server are served on it, and
+// nothing else.
+func serve(b Backend, j *journal, wl *whitelist, password string, key x25519Key, addr, peerAddr, peerURL string, dev bool) {
+	if !isLoopbackAddr(addr) {
+		log.Fatalf("Refusing to serve the wallet API on %v: it is unauthenticated, so it must listen on a loopback address. Use -peer-addr to let peers reach the server.", addr)
+	}
+	srv := &server{
+		backend:   b,
+		journal:   j,
+		whitelist: wl,
+		password:  password,
+		key:       key,
+		watcher:   newWatcher(b, j),
+	}
+	if peerAddr != "" {
+		if peerURL == "" {
+			peerURL = "http://" + peerAddr
+		}
+		srv.peer = newRelayServer()
+		srv.peerURL = strings.TrimSuffix(peerURL, "/") + "/api/peer"
+		peer := httprouter.New()
+		srv.peer.register(peer, "/api/peer")
+		go func() {
+			log.Printf("Serving peer mailboxes on %v...", peerAddr)
+			if err := http.ListenAndServe(peerAddr, peer); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+	go srv.watcher.run(watchInterval)
 	api := httprouter.New()
-	api.POST("/api/create", createHandler)
-	api.POST("/api/accept", acceptHandler)
-	api.POST("/api/finish", finishHandler)
-	api.POST("/api/summarize", summarizeHandler)
-	api.GET("/api/wallet", walletHandler)
-	api.GET("/api/consensus", consensusHandler)
+	api.POST("/api/create", srv.createHandler)
+	api.POST("/api/accept", srv.acceptHandler)
+	api.POST("/api/finish", srv.finishHandler)
+	api.POST("/api/cancel", srv.cancelHandler)
+	api.POST("/api/batch/create", srv.batchCreateHandler)
+	api.POST("/api/batch/join", srv.batchJoinHandler)
+	api.POST("/api/batch/sign", srv.batchSignHandler)
+	api.POST("/api/batch/merge", srv.batchMergeHandler)
+	api.POST("/api/summarize", srv.summarizeHandler)
+	api.GET("/api/swaps", srv.swapsHandler)
+	api.GET("/api/swaps/:id", srv.swapHandler)
+	api.POST("/api/swaps/:id", srv.annotateHandler)
+	api.GET("/api/swaps/:id/events", srv.swapEventsHandler)
+	api.GET("/api/whitelist", srv.whitelistHandler)
+	api.POST("/api/whitelist", srv.updateWhitelistHandler)
+	api.GET("/api/wallet", srv.walletHandler)
+	api.GET("/api/consensus", srv.consensusHandler)
+	api.GET("/api/events", srv.eventsHandler)
+	api.GET("/api/key", srv.keyHandler)
+	api.POST("/api/mailbox", srv.mailboxHandler)
 
 	go func() {
 		ui := buildUIHandler()
@@ -205,6 +789,23 @@ func serve(addr string, dev bool) {
 	fmt.Println("Received interrupt, shutting down...")
 }
 
+// isLoopbackHost reports whether host, a hostname or IP address, refers to
+// the local machine.
+func isLoopbackHost(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+// isLoopbackAddr reports whether addr, a host:port address to listen on, is a
+// loopback address. An address without a host listens on every interface.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	return isLoopbackHost(host)
+}
+
 func open(url string) error {
 	if !strings.HasPrefix(url, "http") {
 		url = "http://" + url
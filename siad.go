@@ -0,0 +1,88 @@
+package main
+
+import (
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/node/api"
+	"go.sia.tech/siad/node/api/client"
+	"go.sia.tech/siad/types"
+)
+
+// siadBackend implements Backend using the API of a siad node.
+type siadBackend struct {
+	c *client.Client
+}
+
+func (s *siadBackend) Address() (types.UnlockHash, error) {
+	wag, err := s.c.WalletAddressGet()
+	return wag.Address, err
+}
+
+func (s *siadBackend) Addresses() ([]types.UnlockHash, error) {
+	wag, err := s.c.WalletAddressesGet()
+	return wag.Addresses, err
+}
+
+func (s *siadBackend) UnspentOutputs() ([]modules.UnspentOutput, error) {
+	wug, err := s.c.WalletUnspentGet()
+	return wug.Outputs, err
+}
+
+func (s *siadBackend) UnconfirmedTransactions() ([]modules.ProcessedTransaction, error) {
+	// the unconfirmed transactions are always included, so request only the
+	// confirmed transactions of the current block
+	cg, err := s.c.ConsensusGet()
+	if err != nil {
+		return nil, err
+	}
+	wtg, err := s.c.WalletTransactionsGet(cg.Height, cg.Height)
+	return wtg.UnconfirmedTransactions, err
+}
+
+func (s *siadBackend) UnlockConditions(addr types.UnlockHash) (types.UnlockConditions, error) {
+	wucg, err := s.c.WalletUnlockConditionsGet(addr)
+	return wucg.UnlockConditions, err
+}
+
+func (s *siadBackend) SignTransaction(txn *types.Transaction, toSign []crypto.Hash) error {
+	wspr, err := s.c.WalletSignPost(*txn, toSign)
+	if err != nil {
+		return err
+	}
+	*txn = wspr.Transaction
+	return nil
+}
+
+func (s *siadBackend) Transaction(id types.TransactionID) (modules.ProcessedTransaction, error) {
+	wtg, err := s.c.WalletTransactionGet(id)
+	return wtg.Transaction, err
+}
+
+func (s *siadBackend) Status() (api.WalletGET, error) {
+	return s.c.WalletGet()
+}
+
+func (s *siadBackend) BroadcastTransaction(txn types.Transaction) error {
+	return s.c.TransactionPoolRawPost(txn, nil)
+}
+
+func (s *siadBackend) PoolTransactions() ([]types.Transaction, error) {
+	tptg, err := s.c.TransactionPoolTransactionsGet()
+	return tptg.Transactions, err
+}
+
+func (s *siadBackend) Consensus() (api.ConsensusGET, error) {
+	return s.c.ConsensusGet()
+}
+
+func (s *siadBackend) FeeEstimate() (min, max types.Currency, err error) {
+	tfg, err := s.c.TransactionPoolFeeGet()
+	return tfg.Minimum, tfg.Maximum, err
+}
+
+// newSiadBackend returns a Backend that talks to the siad API at addr.
+func newSiadBackend(addr string) *siadBackend {
+	opts, _ := client.DefaultOptions()
+	opts.Address = addr
+	return &siadBackend{c: client.New(opts)}
+}
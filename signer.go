@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+// An external signer holds the wallet's keys outside of embc, e.g. in a
+// hardware wallet or a key-management service. embc builds and checks each
+// swap as usual, then sends the transaction to the signer, which returns the
+// signatures for the requested inputs.
+//
+// A signer is either a command, which is run once per request with the
+// SignerRequest on its stdin and must write a SignerResponse to its stdout, or
+// an HTTP endpoint, to which the SignerRequest is POSTed. The endpoint must be
+// on a loopback address or a unix socket (unix:///path/to/socket), unless
+// remote signers are explicitly allowed, since anyone who can answer the
+// request sees every transaction the wallet signs.
+
+// A SignerRequest asks an external signer to sign inputs of a transaction.
+// Every signature whose ParentID is in ToSign must be filled in, using the
+// sighash of the transaction at Height.
+type SignerRequest struct {
+	Transaction types.Transaction `json:"transaction"`
+	ToSign      []crypto.Hash     `json:"toSign"`
+	Height      types.BlockHeight `json:"height"`
+}
+
+// A SignerResponse is an external signer's reply to a SignerRequest. If the
+// signer refuses or fails to sign, it sets Error.
+type SignerResponse struct {
+	Signatures []types.TransactionSignature `json:"signatures"`
+	Error      string                       `json:"error,omitempty"`
+}
+
+// signerTimeout is how long to wait for an external signer. It is generous,
+// since a signer may ask its user to approve each request.
+const signerTimeout = 5 * time.Minute
+
+// An externalSignerBackend is a Backend whose transactions are signed by an
+// external signer.
+type externalSignerBackend struct {
+	Backend
+	signer string
+}
+
+// isSignerURL reports whether signer is an HTTP endpoint rather than a
+// command.
+func isSignerURL(signer string) bool {
+	return strings.HasPrefix(signer, "http://") || strings.HasPrefix(signer, "https://") || strings.HasPrefix(signer, "unix:")
+}
+
+// signerSocket returns the path of the unix socket named by signer, if any.
+func signerSocket(signer string) (string, bool) {
+	u, err := url.Parse(signer)
+	if err != nil || u.Scheme != "unix" {
+		return "", false
+	} else if u.Opaque != "" {
+		return u.Opaque, true
+	}
+	return u.Path, u.Path != ""
+}
+
+// checkSigner checks that signer is a command, a unix socket, or an HTTP
+// endpoint on a loopback address. If allowRemote is set, any HTTP endpoint is
+// accepted.
+func checkSigner(signer string, allowRemote bool) error {
+	if !isSignerURL(signer) {
+		return nil
+	} else if strings.HasPrefix(signer, "unix:") {
+		if _, ok := signerSocket(signer); !ok {
+			return fmt.Errorf("invalid signer socket %q; must be of the form unix:///path/to/socket", signer)
+		}
+		return nil
+	}
+	u, err := url.Parse(signer)
+	if err != nil {
+		return fmt.Errorf("invalid signer URL: %w", err)
+	} else if allowRemote {
+		return nil
+	}
+	if !isLoopbackHost(u.Hostname()) {
+		return fmt.Errorf("signer %v is not on a loopback address or unix socket; pass -signer-remote to use it anyway", signer)
+	}
+	return nil
+}
+
+// callSigner sends req to signer and returns its signatures.
+func callSigner(signer string, req SignerRequest) ([]types.TransactionSignature, error) {
+	js, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	if isSignerURL(signer) {
+		client := http.Client{Timeout: signerTimeout}
+		if path, ok := signerSocket(signer); ok {
+			client.Transport = &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			}
+			signer = "http://unix/"
+		}
+		resp, err := client.Post(signer, "application/json", bytes.NewReader(js))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(resp.Body); err != nil {
+			return nil, err
+		}
+		out = buf.Bytes()
+		if resp.StatusCode != http.StatusOK {
+			var sr SignerResponse
+			if json.Unmarshal(out, &sr) == nil && sr.Error != "" {
+				return nil, errors.New(sr.Error)
+			}
+			return nil, fmt.Errorf("signer returned %v", resp.Status)
+		}
+	} else {
+		args := strings.Fields(signer)
+		if len(args) == 0 {
+			return nil, errors.New("no signer command")
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(js)
+		cmd.Stderr = os.Stderr
+		out, err = cmd.Output()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var sr SignerResponse
+	if err := json.Unmarshal(out, &sr); err != nil {
+		return nil, fmt.Errorf("failed to decode signer response: %w", err)
+	} else if sr.Error != "" {
+		return nil, errors.New(sr.Error)
+	}
+	return sr.Signatures, nil
+}
+
+// SignTransaction implements Wallet.
+func (b externalSignerBackend) SignTransaction(txn *types.Transaction, toSign []crypto.Hash) error {
+	cg, err := b.Consensus()
+	if err != nil {
+		return fmt.Errorf("failed to get consensus height: %w", err)
+	}
+	sigs, err := callSigner(b.signer, SignerRequest{
+		Transaction: *txn,
+		ToSign:      toSign,
+		Height:      cg.Height,
+	})
+	if err != nil {
+		return fmt.Errorf("external signer failed: %w", err)
+	}
+
+	// never trust the signer to have signed what we asked; check each
+	// signature before adding it to the transaction
+	ucs := make(map[crypto.Hash]types.UnlockConditions)
+	for _, sci := range txn.SiacoinInputs {
+		ucs[crypto.Hash(sci.ParentID)] = sci.UnlockConditions
+	}
+	for _, sfi := range txn.SiafundInputs {
+		ucs[crypto.Hash(sfi.ParentID)] = sfi.UnlockConditions
+	}
+	signed := make([][]byte, len(txn.TransactionSignatures))
+	for _, id := range toSign {
+		var found bool
+		for i, ts := range txn.TransactionSignatures {
+			if ts.ParentID != id {
+				continue
+			}
+			found = true
+			var sig []byte
+			for _, s := range sigs {
+				if s.ParentID == id && s.PublicKeyIndex == ts.PublicKeyIndex {
+					sig = s.Signature
+					break
+				}
+			}
+			if sig == nil {
+				return fmt.Errorf("external signer did not sign input %v", id)
+			} else if err := verifySignature(ucs[id], ts.PublicKeyIndex, txn.SigHash(i, cg.Height), sig); err != nil {
+				return fmt.Errorf("external signer returned an invalid signature for input %v: %w", id, err)
+			}
+			signed[i] = sig
+		}
+		if !found {
+			return fmt.Errorf("transaction has no signature for input %v", id)
+		}
+	}
+	for i, sig := range signed {
+		if sig != nil {
+			txn.TransactionSignatures[i].Signature = sig
+		}
+	}
+	return nil
+}
+
+// signerBackend returns b, or, if signer is set, an externalSignerBackend
+// that signs with it. Unless allowRemote is set, an HTTP signer must be local;
+// see checkSigner.
+func signerBackend(b Backend, signer string, allowRemote bool) (Backend, error) {
+	if signer == "" {
+		return b, nil
+	} else if err := checkSigner(signer, allowRemote); err != nil {
+		return nil, err
+	}
+	return externalSignerBackend{b, signer}, nil
+}
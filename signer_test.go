@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mnemonics "gitlab.com/NebulousLabs/entropy-mnemonics"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/modules/wallet"
+	"go.sia.tech/siad/types"
+)
+
+func TestExternalSignerHTTP(t *testing.T) {
+	c, alice, bob := newTestSwappers()
+
+	// alice's keys are held by a signing service
+	var tamper bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var sr SignerRequest
+		if err := json.NewDecoder(req.Body).Decode(&sr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var resp SignerResponse
+		if err := alice.SignTransaction(&sr.Transaction, sr.ToSign); err != nil {
+			resp.Error = err.Error()
+		}
+		for _, sig := range sr.Transaction.TransactionSignatures {
+			for _, id := range sr.ToSign {
+				if sig.ParentID == id {
+					resp.Signatures = append(resp.Signatures, sig)
+				}
+			}
+		}
+		if tamper {
+			resp.Signatures[0].Signature[0] ^= 1
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	b := externalSignerBackend{alice, srv.URL}
+
+	swap, err := createSwap(b, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := checkFinish(b, swap, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// a bad signature from the signer is caught before broadcast
+	tamper = true
+	bad := swap
+	bad.Signatures = append([]types.TransactionSignature(nil), swap.Signatures...)
+	if err := finishSwap(b, &bad); err == nil {
+		t.Fatal("expected finishSwap to reject an invalid signature")
+	}
+	tamper = false
+
+	if err := finishSwap(b, &swap); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, alice, swap, swapTransactionConfirmed)
+
+	// the signer can also listen on a unix socket
+	sock := filepath.Join(t.TempDir(), "signer.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, handler)
+	defer l.Close()
+	b = externalSignerBackend{alice, "unix://" + sock}
+	swap, err = createSwap(b, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(b, &swap); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, alice, swap, swapTransactionConfirmed)
+}
+
+func TestCheckSigner(t *testing.T) {
+	for _, signer := range []string{
+		"my-signer --key ops",
+		"http://localhost:9000/sign",
+		"http://127.0.0.1:9000/sign",
+		"https://[::1]:9000/sign",
+		"unix:///run/signer.sock",
+	} {
+		if err := checkSigner(signer, false); err != nil {
+			t.Errorf("expected %q to be accepted: %v", signer, err)
+		}
+	}
+	for _, signer := range []string{
+		"http://example.com/sign",
+		"http://192.168.1.2:9000/sign",
+		"https://localhost.example.com/sign",
+		"unix:",
+	} {
+		if err := checkSigner(signer, false); err == nil {
+			t.Errorf("expected %q to be rejected", signer)
+		}
+	}
+	if err := checkSigner("https://example.com/sign", true); err != nil {
+		t.Error("expected remote signer to be accepted when allowed:", err)
+	}
+}
+
+func TestExternalSignerCommand(t *testing.T) {
+	c := newMemChain()
+	seed := modules.Seed{4, 5, 6}
+	alice := newMemWallet(c, types.SiacoinPrecision.Mul64(1000), types.ZeroCurrency)
+	bob := newMemSeedWallet(c, seed, types.ZeroCurrency, types.NewCurrency64(10))
+
+	// bob's signer is this test binary, rerun as a helper process that
+	// signs with his seed
+	phrase, err := modules.SeedToString(seed, mnemonics.English)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EMBC_TEST_SIGNER_SEED", phrase)
+	b := externalSignerBackend{bob, os.Args[0] + " -test.run=TestSignerHelperProcess"}
+
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(b, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(alice, &swap); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, bob, swap, swapTransactionConfirmed)
+
+	// a failing signer is reported
+	t.Setenv("EMBC_TEST_SIGNER_SEED", "")
+	swap, err = createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(b, &swap, fundingOptions{}); err == nil {
+		t.Fatal("expected acceptSwap to fail when the signer fails")
+	}
+}
+
+// TestSignerHelperProcess is not a real test; it is run as an external signer
+// by TestExternalSignerCommand.
+func TestSignerHelperProcess(t *testing.T) {
+	phrase, ok := os.LookupEnv("EMBC_TEST_SIGNER_SEED")
+	if !ok {
+		return
+	}
+	var resp SignerResponse
+	var req SignerRequest
+	if seed, err := parseSeed(phrase); err != nil {
+		resp.Error = err.Error()
+	} else if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		resp.Error = err.Error()
+	} else if err := wallet.SignTransaction(&req.Transaction, seed, req.ToSign, req.Height); err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Signatures = req.Transaction.TransactionSignatures
+	}
+	json.NewEncoder(os.Stdout).Encode(resp)
+	os.Exit(0)
+}
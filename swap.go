@@ -5,21 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"math/big"
 	"reflect"
 	"strings"
 
 	"go.sia.tech/siad/crypto"
-	"go.sia.tech/siad/node/api/client"
+	"go.sia.tech/siad/modules"
 	"go.sia.tech/siad/types"
 )
 
-var siad *client.Client
-
-var minerFee = types.SiacoinPrecision.Mul64(5)
-
 const (
 	waitingForYouToAccept          = "waitingForYouToAccept"
 	waitingForCounterpartyToAccept = "waitingForCounterpartyToAccept"
@@ -27,27 +22,101 @@ const (
 	waitingForCounterpartyToFinish = "waitingForCounterpartyToFinish"
 	swapTransactionPending         = "swapTransactionPending"
 	swapTransactionConfirmed       = "swapTransactionConfirmed"
+	swapCancelled                  = "swapCancelled"
+	offerClosed                    = "offerClosed"
+)
+
+// Fee policies determine which party pays the miner fee. Since the party
+// contributing siafunds may not hold any siacoins, their share of the fee is
+// deducted from the siacoins they receive.
+const (
+	feePayerSC    = "sc"
+	feePayerSF    = "sf"
+	feePayerSplit = "split"
 )
 
-// A SwapTransaction is a transaction that swaps Siacoin for Siafunds between
-// two parties.
+// A Basket is an amount of siacoins and siafunds.
+type Basket struct {
+	SC types.Currency `json:"sc"`
+	SF types.Currency `json:"sf"`
+}
+
+// IsZero reports whether the basket is empty.
+func (b Basket) IsZero() bool {
+	return b.SC.IsZero() && b.SF.IsZero()
+}
+
+// Equals reports whether two baskets hold the same amounts.
+func (b Basket) Equals(o Basket) bool {
+	return b.SC.Equals(o.SC) && b.SF.Equals(o.SF)
+}
+
+// String implements fmt.Stringer.
+func (b Basket) String() string {
+	switch {
+	case b.IsZero():
+		return "nothing"
+	case b.SF.IsZero():
+		return b.SC.HumanString()
+	case b.SC.IsZero():
+		return b.SF.String() + " SF"
+	default:
+		return b.SC.HumanString() + " + " + b.SF.String() + " SF"
+	}
+}
+
+// SwapTerms record what each party to a swap sends. Each party receives what
+// the other sends, less their share of the miner fee.
+type SwapTerms struct {
+	Creator  Basket `json:"creator"`
+	Acceptor Basket `json:"acceptor"`
+}
+
+// A SwapTransaction is a transaction that swaps Siacoin and Siafunds between
+// two parties. ChangeFee and AcceptChangeFee are the siacoin change that the
+// creator and acceptor, respectively, added to the miner fee.
 type SwapTransaction struct {
-	SiacoinInputs  []types.SiacoinInput         `json:"siacoinInputs"`
-	SiafundInputs  []types.SiafundInput         `json:"siafundInputs"`
-	SiacoinOutputs []types.SiacoinOutput        `json:"siacoinOutputs"`
-	SiafundOutputs []types.SiafundOutput        `json:"siafundOutputs"`
-	Signatures     []types.TransactionSignature `json:"signatures"`
+	Terms           SwapTerms                    `json:"terms"`
+	SiacoinInputs   []types.SiacoinInput         `json:"siacoinInputs"`
+	SiafundInputs   []types.SiafundInput         `json:"siafundInputs"`
+	SiacoinOutputs  []types.SiacoinOutput        `json:"siacoinOutputs"`
+	SiafundOutputs  []types.SiafundOutput        `json:"siafundOutputs"`
+	MinerFee        types.Currency               `json:"minerFee"`
+	ChangeFee       types.Currency               `json:"changeFee"`
+	AcceptChangeFee types.Currency               `json:"acceptChangeFee"`
+	FeePayer        string                       `json:"feePayer"`
+	Signatures      []types.TransactionSignature `json:"signatures"`
+}
+
+// A PartySummary details what one party to a swap sends and receives, and
+// their share of the miner fee, including any change they added to it.
+type PartySummary struct {
+	Send    Basket         `json:"send"`
+	Receive Basket         `json:"receive"`
+	Fee     types.Currency `json:"fee"`
 }
 
 // A SwapSummary details the amount of Siacoins and Siafunds received and spent
-// by a party during a swap.
+// by each party during a swap. You and Counterparty describe the swap from
+// the perspective of the summarizing wallet.
+//
+// The remaining fields describe the swap in terms of a "siacoin party", the
+// party that sends more siacoins, and a "siafund party". AmountSC and AmountSF
+// are the total amounts exchanged before fees; the siacoin party pays
+// SCPartyFee, and the siafund party pays SFPartyFee.
 type SwapSummary struct {
-	ReceiveSF bool           `json:"receiveSF"`
-	ReceiveSC bool           `json:"receiveSC"`
-	AmountSF  types.Currency `json:"amountSF"`
-	AmountSC  types.Currency `json:"amountSC"`
-	MinerFee  types.Currency `json:"minerFee"`
-	Status    string         `json:"status"`
+	You          PartySummary   `json:"you"`
+	Counterparty PartySummary   `json:"counterparty"`
+	ReceiveSF    bool           `json:"receiveSF"`
+	ReceiveSC    bool           `json:"receiveSC"`
+	AmountSF     types.Currency `json:"amountSF"`
+	AmountSC     types.Currency `json:"amountSC"`
+	MinerFee     types.Currency `json:"minerFee"`
+	FeePayer     string         `json:"feePayer"`
+	SCPartyFee   types.Currency `json:"scPartyFee"`
+	SFPartyFee   types.Currency `json:"sfPartyFee"`
+	Open         bool           `json:"open"`
+	Status       string         `json:"status"`
 }
 
 // transaction converts the swap transaction into a full transaction.
@@ -57,21 +126,213 @@ func (swap *SwapTransaction) transaction() types.Transaction {
 		SiafundInputs:         swap.SiafundInputs,
 		SiacoinOutputs:        swap.SiacoinOutputs,
 		SiafundOutputs:        swap.SiafundOutputs,
-		MinerFees:             []types.Currency{minerFee},
+		MinerFees:             []types.Currency{swap.MinerFee.Add(swap.ChangeFee).Add(swap.AcceptChangeFee)},
 		TransactionSignatures: swap.Signatures,
 	}
 }
 
+// terms returns the swap's terms. Swaps created by older versions do not
+// record their terms, which always exchanged siacoins for siafunds; their
+// terms are instead derived from their first siacoin and siafund outputs.
+func (swap *SwapTransaction) terms() SwapTerms {
+	if !swap.Terms.Creator.IsZero() || !swap.Terms.Acceptor.IsZero() || len(swap.SiacoinOutputs) == 0 || len(swap.SiafundOutputs) == 0 {
+		return swap.Terms
+	}
+	// before the swap is signed, only the creator's inputs are present;
+	// afterwards, the first signature belongs to the acceptor, unless the
+	// swap is an open offer
+	creatorSendsSF := len(swap.SiafundInputs) > 0
+	if len(swap.Signatures) > 0 {
+		firstSendsSF := true
+		for _, sci := range swap.SiacoinInputs {
+			if crypto.Hash(sci.ParentID) == swap.Signatures[0].ParentID {
+				firstSendsSF = false
+			}
+		}
+		creatorSendsSF = firstSendsSF == isOpenOffer(*swap)
+	}
+	_, sfFee := feeShares(swap.MinerFee, swap.FeePayer)
+	sc := Basket{SC: swap.SiacoinOutputs[0].Value.Add(sfFee)}
+	sf := Basket{SF: swap.SiafundOutputs[0].Value}
+	if creatorSendsSF {
+		return SwapTerms{Creator: sf, Acceptor: sc}
+	}
+	return SwapTerms{Creator: sc, Acceptor: sf}
+}
+
+// A swapParty describes one side of a swap: what the party sends and
+// receives, their share of the miner fee, and the change they added to it.
+// Since a party may not hold any siacoins, their share of the fee is deducted
+// from the siacoins they receive, if any; otherwise they fund it from their
+// own inputs.
+type swapParty struct {
+	send      Basket
+	receive   Basket
+	fee       types.Currency
+	changeFee types.Currency
+}
+
+// deducted returns the portion of the party's fee that is deducted from the
+// siacoins they receive.
+func (p swapParty) deducted() types.Currency {
+	if p.fee.Cmp(p.receive.SC) > 0 {
+		return p.receive.SC
+	}
+	return p.fee
+}
+
+// proceedsSC returns the siacoins that the swap pays to the party.
+func (p swapParty) proceedsSC() types.Currency {
+	return p.receive.SC.Sub(p.deducted())
+}
+
+// fundSC returns the siacoins that the party funds from their own inputs.
+func (p swapParty) fundSC() types.Currency {
+	return p.send.SC.Add(p.fee).Sub(p.deducted())
+}
+
+// summary returns a summary of the party's side of the swap.
+func (p swapParty) summary() PartySummary {
+	return PartySummary{
+		Send:    p.send,
+		Receive: p.receive,
+		Fee:     p.fee.Add(p.changeFee),
+	}
+}
+
+// parties returns the creator's and acceptor's sides of the swap. For the
+// purposes of the fee policy, the siacoin party is whichever party sends more
+// siacoins, or the creator if they send the same amount.
+func (swap *SwapTransaction) parties() (creator, acceptor swapParty) {
+	t := swap.terms()
+	creatorFee, acceptorFee := feeShares(swap.MinerFee, swap.FeePayer)
+	if t.Creator.SC.Cmp(t.Acceptor.SC) < 0 {
+		creatorFee, acceptorFee = acceptorFee, creatorFee
+	}
+	creator = swapParty{send: t.Creator, receive: t.Acceptor, fee: creatorFee, changeFee: swap.ChangeFee}
+	acceptor = swapParty{send: t.Acceptor, receive: t.Creator, fee: acceptorFee, changeFee: swap.AcceptChangeFee}
+	return
+}
+
+// checkTerms checks that the swap's terms are valid: each party must send
+// something, neither may add more than dust to the miner fee as change, and a
+// party that receives siacoins must receive more than their share of the
+// miner fee.
+func checkTerms(swap SwapTransaction) error {
+	t := swap.terms()
+	if t.Creator.IsZero() || t.Acceptor.IsZero() {
+		return errors.New("each party must send something")
+	} else if swap.ChangeFee.Cmp(maxDustThreshold) >= 0 || swap.AcceptChangeFee.Cmp(maxDustThreshold) >= 0 {
+		return fmt.Errorf("change added to the miner fee must be less than %v", maxDustThreshold.HumanString())
+	}
+	creator, acceptor := swap.parties()
+	for _, p := range []swapParty{creator, acceptor} {
+		if !p.receive.SC.IsZero() && p.receive.SC.Cmp(p.fee) <= 0 {
+			return errors.New("siacoins received must exceed the receiving party's share of the miner fee")
+		}
+	}
+	return nil
+}
+
+// hasBlankOutput reports whether any of the swap's outputs have yet to be
+// assigned an address.
+func hasBlankOutput(swap SwapTransaction) bool {
+	for _, sco := range swap.SiacoinOutputs {
+		if sco.UnlockHash == (types.UnlockHash{}) {
+			return true
+		}
+	}
+	for _, sfo := range swap.SiafundOutputs {
+		if sfo.UnlockHash == (types.UnlockHash{}) {
+			return true
+		}
+	}
+	return false
+}
+
+// feeShares returns the portions of the miner fee paid by the party
+// contributing siacoins and the party contributing siafunds.
+func feeShares(fee types.Currency, payer string) (sc, sf types.Currency) {
+	switch payer {
+	case feePayerSF:
+		return types.ZeroCurrency, fee
+	case feePayerSplit:
+		sf = fee.Div64(2)
+		return fee.Sub(sf), sf
+	default:
+		return fee, types.ZeroCurrency
+	}
+}
+
+// checkFeePayer checks that payer is a known fee policy.
+func checkFeePayer(payer string) error {
+	switch payer {
+	case "", feePayerSC, feePayerSF, feePayerSplit:
+		return nil
+	default:
+		return fmt.Errorf("unknown fee payer %q (must be %q, %q, or %q)", payer, feePayerSC, feePayerSF, feePayerSplit)
+	}
+}
+
+// estimatedSwapSize is the approximate size, in bytes, of a completed swap
+// transaction in which each party contributes a few inputs and a change output.
+var estimatedSwapSize = func() uint64 {
+	const inputsPerParty = 3
+	uc := types.UnlockConditions{
+		PublicKeys:         []types.SiaPublicKey{{Algorithm: types.SignatureEd25519, Key: make([]byte, crypto.PublicKeySize)}},
+		SignaturesRequired: 1,
+	}
+	txn := types.Transaction{
+		SiacoinOutputs: make([]types.SiacoinOutput, 2),
+		SiafundOutputs: make([]types.SiafundOutput, 2),
+		MinerFees:      []types.Currency{types.SiacoinPrecision},
+	}
+	for i := 0; i < inputsPerParty; i++ {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{UnlockConditions: uc})
+		txn.SiafundInputs = append(txn.SiafundInputs, types.SiafundInput{UnlockConditions: uc})
+	}
+	for i := 0; i < 2*inputsPerParty; i++ {
+		txn.TransactionSignatures = append(txn.TransactionSignatures, types.TransactionSignature{
+			CoveredFields: types.FullCoveredFields,
+			Signature:     make([]byte, crypto.SignatureSize),
+		})
+	}
+	return uint64(txn.MarshalSiaSize())
+}()
+
+// estimateMinerFee returns a miner fee for a swap transaction, based on the
+// transaction pool's current fee estimate.
+func estimateMinerFee(c Chain) (types.Currency, error) {
+	_, max, err := c.FeeEstimate()
+	if err != nil {
+		return types.Currency{}, fmt.Errorf("failed to get fee estimate: %w", err)
+	}
+	return max.Mul64(estimatedSwapSize), nil
+}
+
+// parseMinerFee parses a suffixed Siacoin string into a miner fee. If the string
+// is empty, the fee is estimated from the transaction pool instead.
+func parseMinerFee(c Chain, fee string) (types.Currency, error) {
+	if fee == "" {
+		return estimateMinerFee(c)
+	}
+	f, err := parseCurrency(fee)
+	if err != nil {
+		return types.Currency{}, fmt.Errorf("invalid miner fee: %w", err)
+	}
+	return f, nil
+}
+
 // parseCurrency parses a suffixed Siacoin or Siafund string into a currency
 // value.
-func parseCurrency(amount string) types.Currency {
+func parseCurrency(amount string) (types.Currency, error) {
 	amount = strings.TrimSpace(amount)
 	if strings.HasSuffix(amount, "SF") || strings.HasSuffix(amount, "H") {
 		i, ok := new(big.Int).SetString(strings.TrimRight(amount, "SFH"), 10)
-		if !ok {
-			log.Fatal("Invalid currency")
+		if !ok || i.Sign() < 0 {
+			return types.Currency{}, fmt.Errorf("invalid currency %q", amount)
 		}
-		return types.NewCurrency(i)
+		return types.NewCurrency(i), nil
 	}
 
 	units := []string{"pS", "nS", "uS", "mS", "SC", "KS", "MS", "GS", "TS"}
@@ -79,20 +340,36 @@ func parseCurrency(amount string) types.Currency {
 		if strings.HasSuffix(amount, unit) {
 			value := strings.TrimSpace(strings.TrimSuffix(amount, unit))
 			r, ok := new(big.Rat).SetString(value)
-			if !ok {
-				log.Fatal("Invalid currency")
+			if !ok || r.Sign() < 0 {
+				return types.Currency{}, fmt.Errorf("invalid currency %q", amount)
 			}
 			exp := 24 + 3*(int64(i)-4)
 			mag := new(big.Int).Exp(big.NewInt(10), big.NewInt(exp), nil)
 			r.Mul(r, new(big.Rat).SetInt(mag))
 			if !r.IsInt() {
-				log.Fatal("Currency must be an integer")
+				return types.Currency{}, fmt.Errorf("currency %q must be a whole number of hastings", amount)
 			}
-			return types.NewCurrency(r.Num())
+			return types.NewCurrency(r.Num()), nil
+		}
+	}
+	return types.Currency{}, fmt.Errorf("must specify units of currency %q", amount)
+}
+
+// parseBasket parses a '+'-separated list of suffixed Siacoin and Siafund
+// strings, e.g. "2SF+1KS", into a basket.
+func parseBasket(amount string) (Basket, error) {
+	var b Basket
+	for _, a := range strings.Split(amount, "+") {
+		c, err := parseCurrency(a)
+		if err != nil {
+			return Basket{}, err
+		} else if strings.Contains(a, "SF") {
+			b.SF = b.SF.Add(c)
+		} else {
+			b.SC = b.SC.Add(c)
 		}
 	}
-	log.Fatal("Must specify units of currency")
-	return types.Currency{}
+	return b, nil
 }
 
 func encodeJSON(w io.Writer, v interface{}) error {
@@ -106,318 +383,668 @@ func encodeJSON(w io.Writer, v interface{}) error {
 	return enc.Encode(v)
 }
 
-func addSC(swap *SwapTransaction, amount types.Currency) error {
-	wug, err := siad.WalletUnspentGet()
+// spendableOutputs returns the wallet's unspent outputs that can safely fund a
+// swap: those that are not spent by any of the wallet's unconfirmed
+// transactions and, unless opts allows it, are themselves confirmed.
+// Otherwise, the swap could fail to broadcast after the counterparty has
+// already done their part.
+func spendableOutputs(w Wallet, opts fundingOptions) ([]modules.UnspentOutput, error) {
+	outputs, err := w.UnspentOutputs()
 	if err != nil {
-		return fmt.Errorf("failed to get unspent outputs: %w", err)
+		return nil, fmt.Errorf("failed to get unspent outputs: %w", err)
 	}
-	var inputSum types.Currency
-	for _, u := range wug.Outputs {
+	pts, err := w.UnconfirmedTransactions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unconfirmed transactions: %w", err)
+	}
+	pending := make(map[types.OutputID]bool)
+	for _, pt := range pts {
+		for _, input := range pt.Inputs {
+			pending[input.ParentID] = true
+		}
+	}
+	var spendable []modules.UnspentOutput
+	for _, o := range outputs {
+		if pending[o.ID] {
+			continue
+		} else if o.ConfirmationHeight == types.BlockHeight(math.MaxUint64) && !opts.allowUnconfirmed {
+			continue
+		}
+		spendable = append(spendable, o)
+	}
+	return spendable, nil
+}
+
+// poolSpent returns the set of outputs spent by transactions in the
+// transaction pool.
+func poolSpent(c Chain) (map[types.OutputID]bool, error) {
+	txns, err := c.PoolTransactions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction pool: %w", err)
+	}
+	spent := make(map[types.OutputID]bool)
+	for _, txn := range txns {
+		for _, sci := range txn.SiacoinInputs {
+			spent[types.OutputID(sci.ParentID)] = true
+		}
+		for _, sfi := range txn.SiafundInputs {
+			spent[types.OutputID(sfi.ParentID)] = true
+		}
+	}
+	return spent, nil
+}
+
+// addSC adds siacoin inputs worth at least amount to the swap, selected
+// according to opts, along with a change output if necessary.
+func addSC(w Wallet, swap *SwapTransaction, amount types.Currency, opts fundingOptions) error {
+	outputs, err := spendableOutputs(w, opts)
+	if err != nil {
+		return err
+	}
+	var candidates []modules.UnspentOutput
+	for _, u := range outputs {
 		if u.FundType == types.SpecifierSiacoinOutput {
-			wucg, err := siad.WalletUnlockConditionsGet(u.UnlockHash)
-			if err != nil {
-				return fmt.Errorf("failed to get address %v unlock conditions: %w", u.UnlockHash, err)
-			}
-			swap.SiacoinInputs = append(swap.SiacoinInputs, types.SiacoinInput{
-				ParentID:         types.SiacoinOutputID(u.ID),
-				UnlockConditions: wucg.UnlockConditions,
-			})
-			inputSum = inputSum.Add(u.Value)
-			if inputSum.Cmp(amount) >= 0 {
-				break
-			}
+			candidates = append(candidates, u)
 		}
 	}
-	if inputSum.Cmp(amount) < 0 {
-		return errors.New("insufficient funds")
+	selected, err := opts.selectCoins(candidates, amount, opts.dustThreshold)
+	if err != nil {
+		return err
 	}
-	// add a change output, if necessary
-	if !inputSum.Equals(amount) {
-		wag, err := siad.WalletAddressGet()
+	var inputSum types.Currency
+	for _, u := range selected {
+		uc, err := w.UnlockConditions(u.UnlockHash)
 		if err != nil {
-			return fmt.Errorf("failed to get change output address: %w", err)
+			return fmt.Errorf("failed to get address %v unlock conditions: %w", u.UnlockHash, err)
 		}
-		swap.SiacoinOutputs = append(swap.SiacoinOutputs, types.SiacoinOutput{
-			UnlockHash: wag.Address,
-			Value:      inputSum.Sub(amount),
+		swap.SiacoinInputs = append(swap.SiacoinInputs, types.SiacoinInput{
+			ParentID:         types.SiacoinOutputID(u.ID),
+			UnlockConditions: uc,
 		})
+		inputSum = inputSum.Add(u.Value)
 	}
+	// add a change output, if necessary; change below the dust threshold is
+	// added to the miner fee instead
+	change := inputSum.Sub(amount)
+	if change.IsZero() {
+		return nil
+	} else if change.Cmp(opts.dustThreshold) < 0 {
+		swap.ChangeFee = swap.ChangeFee.Add(change)
+		return nil
+	}
+	addr, err := w.Address()
+	if err != nil {
+		return fmt.Errorf("failed to get change output address: %w", err)
+	}
+	swap.SiacoinOutputs = append(swap.SiacoinOutputs, types.SiacoinOutput{
+		UnlockHash: addr,
+		Value:      change,
+	})
 	return nil
 }
 
-func addSF(swap *SwapTransaction, amount types.Currency) error {
-	wug, err := siad.WalletUnspentGet()
+// addSF adds siafund inputs worth at least amount to the swap, selected
+// according to opts, along with a change output if necessary.
+func addSF(w Wallet, swap *SwapTransaction, amount types.Currency, opts fundingOptions) error {
+	outputs, err := spendableOutputs(w, opts)
 	if err != nil {
-		return fmt.Errorf("failed to get wallet unspent outputs: %w", err)
+		return err
 	}
-	wag, err := siad.WalletAddressGet()
+	addr, err := w.Address()
 	if err != nil {
 		return fmt.Errorf("failed to get wallet address: %w", err)
 	}
-	var inputSum types.Currency
-	for _, u := range wug.Outputs {
+	var candidates []modules.UnspentOutput
+	for _, u := range outputs {
 		if u.FundType == types.SpecifierSiafundOutput {
-			wucg, err := siad.WalletUnlockConditionsGet(u.UnlockHash)
-			if err != nil {
-				return fmt.Errorf("failed to get address %v unlock conditions: %w", u.UnlockHash, err)
-			}
-			swap.SiafundInputs = append(swap.SiafundInputs, types.SiafundInput{
-				ParentID:         types.SiafundOutputID(u.ID),
-				UnlockConditions: wucg.UnlockConditions,
-				ClaimUnlockHash:  wag.Address,
-			})
-			inputSum = inputSum.Add(u.Value)
-			if inputSum.Cmp(amount) >= 0 {
-				break
-			}
+			candidates = append(candidates, u)
 		}
 	}
-	if inputSum.Cmp(amount) < 0 {
-		return errors.New("insufficient funds")
+	// siafunds cannot be given to miners, so there is no tolerance for change
+	selected, err := opts.selectCoins(candidates, amount, types.ZeroCurrency)
+	if err != nil {
+		return err
+	}
+	var inputSum types.Currency
+	for _, u := range selected {
+		uc, err := w.UnlockConditions(u.UnlockHash)
+		if err != nil {
+			return fmt.Errorf("failed to get address %v unlock conditions: %w", u.UnlockHash, err)
+		}
+		swap.SiafundInputs = append(swap.SiafundInputs, types.SiafundInput{
+			ParentID:         types.SiafundOutputID(u.ID),
+			UnlockConditions: uc,
+			ClaimUnlockHash:  addr,
+		})
+		inputSum = inputSum.Add(u.Value)
 	}
 	// add a change output, if necessary
 	if !inputSum.Equals(amount) {
 		swap.SiafundOutputs = append(swap.SiafundOutputs, types.SiafundOutput{
-			UnlockHash: wag.Address,
+			UnlockHash: addr,
 			Value:      inputSum.Sub(amount),
 		})
 	}
 	return nil
 }
 
-func signSC(swap *SwapTransaction) error {
-	var toSign []crypto.Hash
-	for _, sci := range swap.SiacoinInputs {
-		swap.Signatures = append(swap.Signatures, types.TransactionSignature{
-			ParentID:       crypto.Hash(sci.ParentID),
-			PublicKeyIndex: 0,
-			CoveredFields:  types.FullCoveredFields,
-		})
-		toSign = append(toSign, crypto.Hash(sci.ParentID))
+// signInputs signs each of the swap's inputs that belongs to w and has not yet
+// been signed, covering the whole transaction.
+func signInputs(w Wallet, swap *SwapTransaction) error {
+	scIDs, sfIDs, err := ownedInputs(w, *swap)
+	if err != nil {
+		return err
+	}
+	signed := make(map[crypto.Hash]bool)
+	for _, sig := range swap.Signatures {
+		signed[sig.ParentID] = true
 	}
-	txn := swap.transaction()
-	wspr, err := siad.WalletSignPost(txn, toSign)
-	swap.Signatures = wspr.Transaction.TransactionSignatures
-	return err
-}
-
-func signSF(swap *SwapTransaction) error {
 	var toSign []crypto.Hash
-	for _, sfi := range swap.SiafundInputs {
+	for _, id := range append(scIDs, sfIDs...) {
+		if signed[crypto.Hash(id)] {
+			continue
+		}
 		swap.Signatures = append(swap.Signatures, types.TransactionSignature{
-			ParentID:       crypto.Hash(sfi.ParentID),
+			ParentID:       crypto.Hash(id),
 			PublicKeyIndex: 0,
 			CoveredFields:  types.FullCoveredFields,
 		})
-		toSign = append(toSign, crypto.Hash(sfi.ParentID))
+		toSign = append(toSign, crypto.Hash(id))
 	}
 	txn := swap.transaction()
-	wspr, err := siad.WalletSignPost(txn, toSign)
-	swap.Signatures = wspr.Transaction.TransactionSignatures
-	return err
+	if err := w.SignTransaction(&txn, toSign); err != nil {
+		return err
+	}
+	swap.Signatures = txn.TransactionSignatures
+	return nil
 }
 
-// createSwap creates a new SwapTransaction swapping the input amount for the
-// output amount.
-func createSwap(inputAmount, outputAmount types.Currency, offeringSF bool) (SwapTransaction, error) {
-	wag, err := siad.WalletAddressGet()
+// createSwap creates a new SwapTransaction in which the creator sends one
+// basket of siacoins and siafunds in exchange for another, paying the
+// specified miner fee according to the fee policy. The swap pays the creator
+// what they receive, split among opts.destinations if any, and leaves the
+// address of the outputs paying the acceptor unspecified.
+func createSwap(w Wallet, send, receive Basket, minerFee types.Currency, feePayer string, opts fundingOptions) (SwapTransaction, error) {
+	if minerFee.IsZero() {
+		return SwapTransaction{}, errors.New("miner fee must be non-zero")
+	} else if err := checkFeePayer(feePayer); err != nil {
+		return SwapTransaction{}, err
+	} else if feePayer == "" {
+		feePayer = feePayerSC
+	}
+	swap := SwapTransaction{
+		Terms:    SwapTerms{Creator: send, Acceptor: receive},
+		MinerFee: minerFee,
+		FeePayer: feePayer,
+	}
+	if err := checkTerms(swap); err != nil {
+		return SwapTransaction{}, err
+	}
+	creator, acceptor := swap.parties()
+	scos, sfos, err := payProceeds(w, Basket{SC: creator.proceedsSC(), SF: receive.SF}, opts.destinations)
 	if err != nil {
 		return SwapTransaction{}, err
 	}
-	var swap SwapTransaction
-	if offeringSF {
+	swap.SiacoinOutputs = append(swap.SiacoinOutputs, scos...)
+	swap.SiafundOutputs = append(swap.SiafundOutputs, sfos...)
+	if sc := acceptor.proceedsSC(); !sc.IsZero() {
 		swap.SiacoinOutputs = append(swap.SiacoinOutputs, types.SiacoinOutput{
-			Value:      outputAmount,
-			UnlockHash: wag.Address,
-		})
-		swap.SiafundOutputs = append(swap.SiafundOutputs, types.SiafundOutput{
-			Value:      inputAmount,
-			UnlockHash: types.UnlockHash{}, // to be filled in by counterparty
-		})
-		if err := addSF(&swap, inputAmount); err != nil {
-			return SwapTransaction{}, fmt.Errorf("failed to add siafunds to swap transaction: %w", err)
-		}
-	} else {
-		swap.SiacoinOutputs = append(swap.SiacoinOutputs, types.SiacoinOutput{
-			Value:      inputAmount,
+			Value:      sc,
 			UnlockHash: types.UnlockHash{}, // to be filled in by counterparty
 		})
+	}
+	if !send.SF.IsZero() {
 		swap.SiafundOutputs = append(swap.SiafundOutputs, types.SiafundOutput{
-			Value:      outputAmount,
-			UnlockHash: wag.Address,
+			Value:      send.SF,
+			UnlockHash: types.UnlockHash{}, // to be filled in by counterparty
 		})
-		// the party that contributes SC is responsible for paying the miner fee
-		if err := addSC(&swap, inputAmount.Add(minerFee)); err != nil {
+	}
+	if sc := creator.fundSC(); !sc.IsZero() {
+		if err := addSC(w, &swap, sc, opts); err != nil {
 			return SwapTransaction{}, fmt.Errorf("failed to add siacoins to swap transaction: %w", err)
 		}
 	}
+	if !send.SF.IsZero() {
+		if err := addSF(w, &swap, send.SF, opts); err != nil {
+			return SwapTransaction{}, fmt.Errorf("failed to add siafunds to swap transaction: %w", err)
+		}
+	}
 	return swap, nil
 }
 
-// checkAccept checks that the counterparty's swap transaction is valid.
+// checkAccept checks that the counterparty's swap transaction is valid: the
+// outputs left for the acceptor must pay exactly what the terms promise them.
 func checkAccept(swap SwapTransaction) error {
 	if len(swap.SiacoinInputs) == 0 && len(swap.SiafundInputs) == 0 {
 		return errors.New("transaction has no inputs")
-	} else if len(swap.SiacoinInputs) > 0 && len(swap.SiafundInputs) > 0 {
-		return errors.New("only one set of inputs should be provided")
 	} else if len(swap.SiacoinOutputs) == 0 && len(swap.SiafundOutputs) == 0 {
 		return errors.New("transaction has no outputs")
-	} else if swap.SiacoinOutputs[0].UnlockHash == (types.UnlockHash{}) && swap.SiafundOutputs[0].UnlockHash == (types.UnlockHash{}) {
+	} else if !hasBlankOutput(swap) {
 		return errors.New("one output address should be left unspecified")
+	} else if swap.MinerFee.IsZero() {
+		return errors.New("transaction does not specify a miner fee")
+	} else if err := checkFeePayer(swap.FeePayer); err != nil {
+		return err
+	} else if err := checkTerms(swap); err != nil {
+		return err
+	}
+	var sc, sf types.Currency
+	for _, sco := range swap.SiacoinOutputs {
+		if sco.UnlockHash == (types.UnlockHash{}) {
+			sc = sc.Add(sco.Value)
+		}
+	}
+	for _, sfo := range swap.SiafundOutputs {
+		if sfo.UnlockHash == (types.UnlockHash{}) {
+			sf = sf.Add(sfo.Value)
+		}
+	}
+	if _, acceptor := swap.parties(); !sc.Equals(acceptor.proceedsSC()) || !sf.Equals(acceptor.receive.SF) {
+		return errors.New("unspecified outputs do not match the swap terms")
+	} else if isOpenOffer(swap) {
+		return checkOffer(swap)
 	} else if len(swap.Signatures) > 0 {
 		return errors.New("transaction should not have any signatures yet")
 	}
 	return nil
 }
 
-// acceptSwap accepts and signs a swap transaction.
-func acceptSwap(swap *SwapTransaction) error {
-	wag, err := siad.WalletAddressGet()
+// fillProceeds fills in the outputs left unspecified for the acceptor. Without
+// destinations, they are paid to a new wallet address. Otherwise, the
+// unspecified outputs are replaced in place by the outputs paying the
+// destinations, and any remaining outputs are appended; outputs are never
+// reordered, since an open offer's signatures cover them by index.
+func fillProceeds(w Wallet, swap *SwapTransaction, proceeds Basket, dests []Destination) error {
+	if len(dests) == 0 {
+		addr, err := w.Address()
+		if err != nil {
+			return fmt.Errorf("failed to get wallet address: %w", err)
+		}
+		for i := range swap.SiacoinOutputs {
+			if swap.SiacoinOutputs[i].UnlockHash == (types.UnlockHash{}) {
+				swap.SiacoinOutputs[i].UnlockHash = addr
+			}
+		}
+		for i := range swap.SiafundOutputs {
+			if swap.SiafundOutputs[i].UnlockHash == (types.UnlockHash{}) {
+				swap.SiafundOutputs[i].UnlockHash = addr
+			}
+		}
+		return nil
+	}
+	scos, sfos, err := payProceeds(w, proceeds, dests)
 	if err != nil {
-		return fmt.Errorf("failed to get wallet address: %w", err)
-	} else if len(swap.SiacoinInputs) == 0 {
-		swap.SiafundOutputs[0].UnlockHash = wag.Address
-		if err := addSC(swap, swap.SiacoinOutputs[0].Value.Add(minerFee)); err != nil {
+		return err
+	}
+	for i := range swap.SiacoinOutputs {
+		if swap.SiacoinOutputs[i].UnlockHash == (types.UnlockHash{}) {
+			if len(scos) == 0 {
+				return errors.New("swap has more unspecified outputs than destinations")
+			}
+			swap.SiacoinOutputs[i], scos = scos[0], scos[1:]
+		}
+	}
+	for i := range swap.SiafundOutputs {
+		if swap.SiafundOutputs[i].UnlockHash == (types.UnlockHash{}) {
+			if len(sfos) == 0 {
+				return errors.New("swap has more unspecified outputs than destinations")
+			}
+			swap.SiafundOutputs[i], sfos = sfos[0], sfos[1:]
+		}
+	}
+	swap.SiacoinOutputs = append(swap.SiacoinOutputs, scos...)
+	swap.SiafundOutputs = append(swap.SiafundOutputs, sfos...)
+	return nil
+}
+
+// acceptSwap accepts and signs a swap transaction, filling in the acceptor's
+// address and adding their inputs. The result is checked against the terms
+// before it is signed.
+func acceptSwap(w Wallet, swap *SwapTransaction, opts fundingOptions) error {
+	_, acceptor := swap.parties()
+	if err := fillProceeds(w, swap, Basket{SC: acceptor.proceedsSC(), SF: acceptor.receive.SF}, opts.destinations); err != nil {
+		return err
+	}
+	if sc := acceptor.fundSC(); !sc.IsZero() {
+		// addSC adds dust to ChangeFee, which belongs to the creator
+		changeFee := swap.ChangeFee
+		if err := addSC(w, swap, sc, opts); err != nil {
 			return fmt.Errorf("failed to add siacoin inputs: %w", err)
 		}
-		return signSC(swap)
+		swap.AcceptChangeFee = swap.AcceptChangeFee.Add(swap.ChangeFee.Sub(changeFee))
+		swap.ChangeFee = changeFee
+	}
+	if !acceptor.send.SF.IsZero() {
+		if err := addSF(w, swap, acceptor.send.SF, opts); err != nil {
+			return fmt.Errorf("failed to add siafund inputs: %w", err)
+		}
 	}
-	swap.SiacoinOutputs[0].UnlockHash = wag.Address
-	if err := addSF(swap, swap.SiafundOutputs[0].Value); err != nil {
-		return fmt.Errorf("failed to add siafund inputs: %w", err)
+	if err := checkNetChange(w, *swap, false); err != nil {
+		return err
 	}
-	return signSF(swap)
+	return signInputs(w, swap)
 }
 
-// checkFinish checks that the accepted swap transaction is valid.
-func checkFinish(swap SwapTransaction, theirs bool) error {
-	if len(swap.SiacoinInputs) == 0 || len(swap.SiafundInputs) == 0 {
+// ourNetChange returns the total value of the transaction's inputs and
+// outputs that belong to w. The values of our inputs are looked up among the
+// wallet's unspent outputs.
+func ourNetChange(w Wallet, txn types.Transaction) (in, out Basket, err error) {
+	addrs, err := w.Addresses()
+	if err != nil {
+		return Basket{}, Basket{}, fmt.Errorf("failed to get wallet addresses: %w", err)
+	}
+	belongsToUs := make(map[types.UnlockHash]bool)
+	for _, addr := range addrs {
+		belongsToUs[addr] = true
+	}
+	outputs, err := w.UnspentOutputs()
+	if err != nil {
+		return Basket{}, Basket{}, fmt.Errorf("failed to get unspent outputs: %w", err)
+	}
+	values := make(map[types.OutputID]types.Currency)
+	for _, o := range outputs {
+		values[o.ID] = o.Value
+	}
+	for _, sci := range txn.SiacoinInputs {
+		if belongsToUs[sci.UnlockConditions.UnlockHash()] {
+			value, ok := values[types.OutputID(sci.ParentID)]
+			if !ok {
+				return Basket{}, Basket{}, fmt.Errorf("our input %v is not spendable", sci.ParentID)
+			}
+			in.SC = in.SC.Add(value)
+		}
+	}
+	for _, sfi := range txn.SiafundInputs {
+		if belongsToUs[sfi.UnlockConditions.UnlockHash()] {
+			value, ok := values[types.OutputID(sfi.ParentID)]
+			if !ok {
+				return Basket{}, Basket{}, fmt.Errorf("our input %v is not spendable", sfi.ParentID)
+			}
+			in.SF = in.SF.Add(value)
+		}
+	}
+	for _, sco := range txn.SiacoinOutputs {
+		if belongsToUs[sco.UnlockHash] {
+			out.SC = out.SC.Add(sco.Value)
+		}
+	}
+	for _, sfo := range txn.SiafundOutputs {
+		if belongsToUs[sfo.UnlockHash] {
+			out.SF = out.SF.Add(sfo.Value)
+		}
+	}
+	return in, out, nil
+}
+
+// checkNetChange checks that the swap changes our balances by exactly what
+// the terms promise the creator or the acceptor: we must receive what they
+// send, and spend no more than we send plus our share of the fee. The net
+// change is computed over every input and output that belongs to us, so it
+// does not depend on how the outputs are arranged.
+func checkNetChange(w Wallet, swap SwapTransaction, creator bool) error {
+	us, _ := swap.parties()
+	if !creator {
+		_, us = swap.parties()
+	}
+	in, out, err := ourNetChange(w, swap.transaction())
+	if err != nil {
+		return err
+	}
+	proceeds, err := whitelistedProceeds(w, swap.transaction(), us.receive)
+	if err != nil {
+		return err
+	}
+	out.SC, out.SF = out.SC.Add(proceeds.SC), out.SF.Add(proceeds.SF)
+	// compare out - in against receive - send without going negative
+	spendSC := us.send.SC.Add(us.fee).Add(us.changeFee)
+	if !out.SC.Add(spendSC).Equals(in.SC.Add(us.receive.SC)) {
+		return fmt.Errorf("swap does not give us the agreed siacoins: we contribute %v and get back %v, but should receive %v and pay %v", in.SC.HumanString(), out.SC.HumanString(), us.receive.SC.HumanString(), spendSC.HumanString())
+	} else if !out.SF.Add(us.send.SF).Equals(in.SF.Add(us.receive.SF)) {
+		return fmt.Errorf("swap does not give us the agreed siafunds: we contribute %v SF and get back %v SF, but should receive %v SF and pay %v SF", in.SF, out.SF, us.receive.SF, us.send.SF)
+	}
+	return nil
+}
+
+// checkFinish checks that the accepted swap transaction is valid. If theirs
+// is set, the swap is checked as if the counterparty were to finish it, i.e.
+// our inputs must already be signed.
+func checkFinish(w Wallet, swap SwapTransaction, theirs bool) error {
+	if len(swap.SiacoinInputs) == 0 && len(swap.SiafundInputs) == 0 {
 		return errors.New("transaction is missing inputs")
-	} else if len(swap.SiacoinOutputs) == 0 || len(swap.SiafundOutputs) == 0 {
+	} else if len(swap.SiacoinOutputs) == 0 && len(swap.SiafundOutputs) == 0 {
 		return errors.New("transaction is missing outputs")
-	} else if swap.SiacoinOutputs[0].UnlockHash == (types.UnlockHash{}) || swap.SiafundOutputs[0].UnlockHash == (types.UnlockHash{}) {
-		return errors.New("one or both swap output addresses have been left unspecified")
+	} else if hasBlankOutput(swap) {
+		return errors.New("one or more swap output addresses have been left unspecified")
+	} else if swap.MinerFee.IsZero() {
+		return errors.New("transaction does not specify a miner fee")
+	} else if err := checkFeePayer(swap.FeePayer); err != nil {
+		return err
+	} else if err := checkTerms(swap); err != nil {
+		return err
 	} else if len(swap.Signatures) == 0 {
 		return errors.New("transaction is missing counterparty signatures")
+	} else if isOpenOffer(swap) {
+		if err := checkOfferSignatures(swap); err != nil {
+			return err
+		}
 	}
 
-	wag, err := siad.WalletAddressesGet()
+	addrs, err := w.Addresses()
 	if err != nil {
 		return fmt.Errorf("failed to get wallet addresses: %w", err)
 	}
 	belongsToUs := make(map[types.UnlockHash]bool)
-	for _, addr := range wag.Addresses {
+	for _, addr := range addrs {
 		belongsToUs[addr] = true
 	}
-
-	var haveSCSignature bool
-	for _, sci := range swap.SiacoinInputs {
-		if crypto.Hash(sci.ParentID) == swap.Signatures[0].ParentID {
-			haveSCSignature = true
-			break
-		}
+	signed := make(map[crypto.Hash]bool)
+	for _, sig := range swap.Signatures {
+		signed[sig.ParentID] = true
 	}
-	if !theirs && haveSCSignature || theirs && !haveSCSignature {
-		// all of the SF inputs should belong to us
-		for _, sfi := range swap.SiafundInputs {
-			if !belongsToUs[sfi.UnlockConditions.UnlockHash()] {
-				return errors.New("counterparty added an SF input that does not belong to us")
-			}
+	checkSigned := func(id crypto.Hash, uh types.UnlockHash) error {
+		if signed[id] {
+			return nil
+		} else if ours := belongsToUs[uh]; !ours && !theirs {
+			return errors.New("transaction is missing counterparty signatures")
+		} else if ours && theirs {
+			return errors.New("transaction is missing our signatures")
 		}
-		// none of the SC inputs should belong to us
-		for _, sci := range swap.SiacoinInputs {
-			if belongsToUs[sci.UnlockConditions.UnlockHash()] {
-				return errors.New("counterparty added an SC input that belongs to us")
-			}
+		return nil
+	}
+	for _, sci := range swap.SiacoinInputs {
+		if err := checkSigned(crypto.Hash(sci.ParentID), sci.UnlockConditions.UnlockHash()); err != nil {
+			return err
 		}
-		// all of the SF change outputs should belong to us
-		for _, sfo := range swap.SiafundOutputs[1:] {
-			if !belongsToUs[sfo.UnlockHash] {
-				return errors.New("counterparty added an SF output that does not belong to us")
-			}
+	}
+	for _, sfi := range swap.SiafundInputs {
+		if err := checkSigned(crypto.Hash(sfi.ParentID), sfi.UnlockConditions.UnlockHash()); err != nil {
+			return err
 		}
-		// the SC output should belong to us
-		if !belongsToUs[swap.SiacoinOutputs[0].UnlockHash] {
-			return errors.New("the SC output address does not belong to us")
+	}
+
+	// the party that finishes the swap is normally its creator, but the
+	// creator of an open offer signs first
+	return checkNetChange(w, swap, theirs == isOpenOffer(swap))
+}
+
+// fullySigned reports whether every input of the swap has a signature.
+func fullySigned(swap SwapTransaction) bool {
+	return len(swap.Signatures) == len(swap.SiacoinInputs)+len(swap.SiafundInputs)
+}
+
+// finishSwap signs and broadcasts an accepted swap transaction.
+func finishSwap(b Backend, swap *SwapTransaction) error {
+	if err := signInputs(b, swap); err != nil {
+		return fmt.Errorf("failed to sign swap transaction: %w", err)
+	}
+	return b.BroadcastTransaction(swap.transaction())
+}
+
+// ownedInputs returns the IDs of the swap's inputs that belong to the wallet.
+func ownedInputs(w Wallet, swap SwapTransaction) (sc, sf []types.OutputID, err error) {
+	addrs, err := w.Addresses()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get wallet addresses: %w", err)
+	}
+	belongsToUs := make(map[types.UnlockHash]bool)
+	for _, addr := range addrs {
+		belongsToUs[addr] = true
+	}
+	for _, sci := range swap.SiacoinInputs {
+		if belongsToUs[sci.UnlockConditions.UnlockHash()] {
+			sc = append(sc, types.OutputID(sci.ParentID))
 		}
-	} else {
-		// all of the SC inputs should belong to us
-		for _, sci := range swap.SiacoinInputs {
-			if !belongsToUs[sci.UnlockConditions.UnlockHash()] {
-				return errors.New("counterparty added an SC input that does not belong to us")
-			}
+	}
+	for _, sfi := range swap.SiafundInputs {
+		if belongsToUs[sfi.UnlockConditions.UnlockHash()] {
+			sf = append(sf, types.OutputID(sfi.ParentID))
 		}
-		// none of the SF inputs should belong to us
-		for _, sfi := range swap.SiafundInputs {
-			if belongsToUs[sfi.UnlockConditions.UnlockHash()] {
-				return errors.New("counterparty added an SF input that belongs to us")
+	}
+	return sc, sf, nil
+}
+
+// cancelSwap invalidates a swap by double-spending our inputs to it: it sends
+// them back to the wallet in a new transaction and broadcasts it. Once the
+// returned transaction is confirmed, the swap can no longer confirm.
+func cancelSwap(b Backend, swap SwapTransaction) (types.Transaction, error) {
+	scIDs, sfIDs, err := ownedInputs(b, swap)
+	if err != nil {
+		return types.Transaction{}, err
+	} else if len(scIDs) == 0 && len(sfIDs) == 0 {
+		return types.Transaction{}, errors.New("swap does not contain any of our inputs")
+	}
+	outputs, err := b.UnspentOutputs()
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("failed to get unspent outputs: %w", err)
+	}
+	values := make(map[types.OutputID]types.Currency)
+	for _, o := range outputs {
+		values[o.ID] = o.Value
+	}
+	fee, err := estimateMinerFee(b)
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	addr, err := b.Address()
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("failed to get wallet address: %w", err)
+	}
+
+	// reuse the swap's inputs, hiding them from addSC in case additional
+	// siacoins are needed to cover the fee
+	cancel := SwapTransaction{MinerFee: fee}
+	ours := make(map[types.OutputID]bool)
+	var scSum, sfSum types.Currency
+	for _, sci := range swap.SiacoinInputs {
+		id := types.OutputID(sci.ParentID)
+		for _, scID := range scIDs {
+			if id == scID {
+				value, ok := values[id]
+				if !ok {
+					return types.Transaction{}, fmt.Errorf("input %v has already been spent", id)
+				}
+				cancel.SiacoinInputs = append(cancel.SiacoinInputs, sci)
+				scSum = scSum.Add(value)
+				ours[id] = true
 			}
 		}
-		// all of the SC change outputs should belong to us
-		for _, sco := range swap.SiacoinOutputs[1:] {
-			if !belongsToUs[sco.UnlockHash] {
-				return errors.New("counterparty added an SC output that does not belong to us")
+	}
+	for _, sfi := range swap.SiafundInputs {
+		id := types.OutputID(sfi.ParentID)
+		for _, sfID := range sfIDs {
+			if id == sfID {
+				value, ok := values[id]
+				if !ok {
+					return types.Transaction{}, fmt.Errorf("input %v has already been spent", id)
+				}
+				sfi.ClaimUnlockHash = addr
+				cancel.SiafundInputs = append(cancel.SiafundInputs, sfi)
+				sfSum = sfSum.Add(value)
+				ours[id] = true
 			}
 		}
-		// the SF output should belong to us
-		if !belongsToUs[swap.SiafundOutputs[0].UnlockHash] {
-			return errors.New("the SF output address does not belong to us")
+	}
+	if !sfSum.IsZero() {
+		cancel.SiafundOutputs = append(cancel.SiafundOutputs, types.SiafundOutput{
+			UnlockHash: addr,
+			Value:      sfSum,
+		})
+	}
+	if scSum.Cmp(fee) > 0 {
+		cancel.SiacoinOutputs = append(cancel.SiacoinOutputs, types.SiacoinOutput{
+			UnlockHash: addr,
+			Value:      scSum.Sub(fee),
+		})
+	} else if scSum.Cmp(fee) < 0 {
+		if err := addSC(reservingWallet{b, ours}, &cancel, fee.Sub(scSum), fundingOptions{}); err != nil {
+			return types.Transaction{}, fmt.Errorf("failed to add siacoins for miner fee: %w", err)
 		}
 	}
-	return nil
-}
 
-// finishSwap signs and broadcasts an accepted swap transaction.
-func finishSwap(swap *SwapTransaction) error {
-	var haveSCSignatures bool
-	for _, sci := range swap.SiacoinInputs {
-		if crypto.Hash(sci.ParentID) == swap.Signatures[0].ParentID {
-			haveSCSignatures = true
-			break
-		}
+	if err := signInputs(b, &cancel); err != nil {
+		return types.Transaction{}, fmt.Errorf("failed to sign cancellation: %w", err)
 	}
-	var err error
-	if haveSCSignatures {
-		err = signSF(swap)
-	} else {
-		err = signSC(swap)
+	txn := cancel.transaction()
+	if err := b.BroadcastTransaction(txn); err != nil {
+		return types.Transaction{}, fmt.Errorf("failed to broadcast cancellation: %w", err)
 	}
+	return txn, nil
+}
+
+// isCreator reports whether w created the swap. Before the swap is signed,
+// only the creator's inputs are present; afterwards, the first signature
+// belongs to the acceptor, unless the swap is an open offer.
+func isCreator(w Wallet, swap SwapTransaction) (bool, error) {
+	scIDs, sfIDs, err := ownedInputs(w, swap)
 	if err != nil {
-		return fmt.Errorf("failed to sign swap transaction: %w", err)
+		return false, err
 	}
-	return siad.TransactionPoolRawPost(swap.transaction(), nil)
+	ours := append(scIDs, sfIDs...)
+	if len(swap.Signatures) == 0 {
+		return len(ours) > 0, nil
+	}
+	var signedFirst bool
+	for _, id := range ours {
+		if crypto.Hash(id) == swap.Signatures[0].ParentID {
+			signedFirst = true
+		}
+	}
+	return signedFirst == isOpenOffer(swap), nil
 }
 
 // summarize returns a summary of the swap.
-func summarize(swap SwapTransaction) (s SwapSummary, err error) {
-	wag, err := siad.WalletAddressesGet()
-
+func summarize(w Wallet, swap SwapTransaction) (s SwapSummary, err error) {
+	if err := checkTerms(swap); err != nil {
+		return SwapSummary{}, err
+	}
+	creator, err := isCreator(w, swap)
 	if err != nil {
-		return SwapSummary{}, fmt.Errorf("failed to get wallet addresses: %w", err)
+		return SwapSummary{}, err
 	}
-
-	for _, addr := range wag.Addresses {
-		s.ReceiveSC = swap.SiacoinOutputs[0].UnlockHash == addr
-		s.ReceiveSF = swap.SiafundOutputs[0].UnlockHash == addr
-		if s.ReceiveSC || s.ReceiveSF {
-			break
-		}
+	us, them := swap.parties()
+	if !creator {
+		us, them = them, us
 	}
+	s.You, s.Counterparty = us.summary(), them.summary()
+	s.ReceiveSC = !us.receive.SC.IsZero()
+	s.ReceiveSF = !us.receive.SF.IsZero()
 
-	if !s.ReceiveSC && !s.ReceiveSF {
-		if swap.SiacoinOutputs[0].UnlockHash == (types.UnlockHash{}) {
-			s.ReceiveSC = true
-		}
-		if swap.SiafundOutputs[0].UnlockHash == (types.UnlockHash{}) {
-			s.ReceiveSF = true
-		}
+	s.FeePayer = swap.FeePayer
+	if s.FeePayer == "" {
+		s.FeePayer = feePayerSC
 	}
+	scParty, sfParty := us, them
+	if scParty.send.SC.Cmp(sfParty.send.SC) < 0 || (scParty.send.SC.Equals(sfParty.send.SC) && !creator) {
+		scParty, sfParty = sfParty, scParty
+	}
+	s.SCPartyFee = scParty.fee.Add(scParty.changeFee)
+	s.SFPartyFee = sfParty.fee.Add(sfParty.changeFee)
+	s.MinerFee = swap.MinerFee.Add(swap.ChangeFee).Add(swap.AcceptChangeFee)
+	s.AmountSC = us.send.SC.Add(them.send.SC)
+	s.AmountSF = us.send.SF.Add(them.send.SF)
+	s.Open = isOpenOffer(swap)
 
-	s.AmountSC = swap.SiacoinOutputs[0].Value
-	s.AmountSF = swap.SiafundOutputs[0].Value
-	s.MinerFee = minerFee
-
-	s.Status = status(swap)
+	s.Status = status(w, swap)
 
 	if s.Status == "" {
 		return SwapSummary{}, fmt.Errorf("failed to get swap status")
@@ -427,29 +1054,26 @@ func summarize(swap SwapTransaction) (s SwapSummary, err error) {
 }
 
 // acceptStatus checks if the swap is ready to be accepted and which party needs to accept.
-func acceptStatus(swap SwapTransaction) string {
+func acceptStatus(w Wallet, swap SwapTransaction) string {
 	if err := checkAccept(swap); err != nil {
 		return ""
 	}
-	wag, err := siad.WalletAddressesGet()
+	creator, err := isCreator(w, swap)
 	if err != nil {
 		return ""
-	}
-	for _, addr := range wag.Addresses {
-		if swap.SiacoinOutputs[0].UnlockHash == addr || swap.SiafundOutputs[0].UnlockHash == addr {
-			return waitingForCounterpartyToAccept
-		}
+	} else if creator {
+		return waitingForCounterpartyToAccept
 	}
 	return waitingForYouToAccept
 }
 
 // finishStatus checks if the swap is ready to be finished and which party needs to finish.
-func finishStatus(swap SwapTransaction) string {
-	err := checkFinish(swap, false)
+func finishStatus(w Wallet, swap SwapTransaction) string {
+	err := checkFinish(w, swap, false)
 	if err == nil {
 		return waitingForYouToFinish
 	}
-	err = checkFinish(swap, true)
+	err = checkFinish(w, swap, true)
 	if err == nil {
 		return waitingForCounterpartyToFinish
 	}
@@ -457,26 +1081,55 @@ func finishStatus(swap SwapTransaction) string {
 }
 
 // txnStatus checks if the swap txn is in the txn pool and whether its confirmed.
-func txnStatus(swap SwapTransaction) string {
-	wtg, err := siad.WalletTransactionGet(swap.transaction().ID())
+func txnStatus(w Wallet, swap SwapTransaction) string {
+	txn, err := w.Transaction(swap.transaction().ID())
 	if err != nil {
 		return ""
 	}
-	if wtg.Transaction.ConfirmationHeight == math.MaxUint64 {
+	if txn.ConfirmationHeight == math.MaxUint64 {
 		return swapTransactionPending
 	}
 	return swapTransactionConfirmed
 }
 
+// cancelStatus checks whether any of our inputs to the swap have been spent by
+// another transaction, meaning that the swap can no longer confirm.
+func cancelStatus(w Wallet, swap SwapTransaction) string {
+	scIDs, sfIDs, err := ownedInputs(w, swap)
+	if err != nil {
+		return ""
+	}
+	outputs, err := w.UnspentOutputs()
+	if err != nil {
+		return ""
+	}
+	unspent := make(map[types.OutputID]bool)
+	for _, o := range outputs {
+		unspent[o.ID] = true
+	}
+	for _, id := range append(scIDs, sfIDs...) {
+		if !unspent[id] {
+			return swapCancelled
+		}
+	}
+	return ""
+}
+
 // status gets the overall status of a swap txn.
-func status(swap SwapTransaction) string {
-	if status := txnStatus(swap); status != "" {
+func status(w Wallet, swap SwapTransaction) string {
+	if status := txnStatus(w, swap); status != "" {
+		return status
+	}
+	if status := offerStatus(w, swap); status != "" {
+		return status
+	}
+	if status := cancelStatus(w, swap); status != "" {
 		return status
 	}
-	if status := finishStatus(swap); status != "" {
+	if status := finishStatus(w, swap); status != "" {
 		return status
 	}
-	if status := acceptStatus(swap); status != "" {
+	if status := acceptStatus(w, swap); status != "" {
 		return status
 	}
 	return ""
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"go.sia.tech/siad/types"
+)
+
+// testMinerFee is the miner fee used by swaps in tests.
+var testMinerFee = types.SiacoinPrecision.Mul64(5)
+
+// newTestSwappers returns a chain and two wallets: alice, who holds
+// siacoins, and bob, who holds siafunds.
+func newTestSwappers() (*memChain, *memWallet, *memWallet) {
+	c := newMemChain()
+	alice := newMemWallet(c, types.SiacoinPrecision.Mul64(1000), types.ZeroCurrency)
+	bob := newMemWallet(c, types.ZeroCurrency, types.NewCurrency64(10))
+	return c, alice, bob
+}
+
+func checkStatus(t *testing.T, w Wallet, swap SwapTransaction, want string) {
+	t.Helper()
+	s, err := summarize(w, swap)
+	if err != nil {
+		t.Fatal(err)
+	} else if s.Status != want {
+		t.Fatalf("expected status %q, got %q", want, s.Status)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	scAmount := types.SiacoinPrecision.Mul64(100)
+	sfAmount := types.NewCurrency64(2)
+
+	for _, offeringSF := range []bool{false, true} {
+		c, alice, bob := newTestSwappers()
+		creator, acceptor := alice, bob
+		send, receive := Basket{SC: scAmount}, Basket{SF: sfAmount}
+		if offeringSF {
+			creator, acceptor = bob, alice
+			send, receive = receive, send
+		}
+
+		fee, err := estimateMinerFee(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		swap, err := createSwap(creator, send, receive, fee, feePayerSC, fundingOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkStatus(t, creator, swap, waitingForCounterpartyToAccept)
+		checkStatus(t, acceptor, swap, waitingForYouToAccept)
+
+		if err := checkAccept(swap); err != nil {
+			t.Fatal(err)
+		} else if err := acceptSwap(acceptor, &swap, fundingOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		checkStatus(t, creator, swap, waitingForYouToFinish)
+		checkStatus(t, acceptor, swap, waitingForCounterpartyToFinish)
+
+		if err := checkFinish(creator, swap, false); err != nil {
+			t.Fatal(err)
+		} else if err := finishSwap(creator, &swap); err != nil {
+			t.Fatal(err)
+		}
+		checkStatus(t, creator, swap, swapTransactionPending)
+		checkStatus(t, acceptor, swap, swapTransactionPending)
+
+		c.mine()
+		checkStatus(t, creator, swap, swapTransactionConfirmed)
+		checkStatus(t, acceptor, swap, swapTransactionConfirmed)
+
+		aliceSC, aliceSF := alice.balance()
+		bobSC, bobSF := bob.balance()
+		if !aliceSC.Equals(types.SiacoinPrecision.Mul64(1000).Sub(scAmount).Sub(fee)) || !aliceSF.Equals(sfAmount) {
+			t.Fatalf("alice has wrong balance after swap: %v SC, %v SF", aliceSC.HumanString(), aliceSF)
+		} else if !bobSC.Equals(scAmount) || !bobSF.Equals(types.NewCurrency64(8)) {
+			t.Fatalf("bob has wrong balance after swap: %v SC, %v SF", bobSC.HumanString(), bobSF)
+		}
+	}
+}
+
+func TestCheckFinishRejectsTampering(t *testing.T) {
+	_, alice, bob := newTestSwappers()
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob redirects alice's siafunds to himself
+	tampered := swap
+	tampered.SiafundOutputs = append([]types.SiafundOutput(nil), swap.SiafundOutputs...)
+	tampered.SiafundOutputs[0].UnlockHash = bob.addrs[0]
+	if err := checkFinish(alice, tampered, false); err == nil {
+		t.Fatal("expected checkFinish to reject redirected SF output")
+	}
+}
+
+func TestBasketSwap(t *testing.T) {
+	sc := types.SiacoinPrecision.Mul64
+	c := newMemChain()
+	alice := newMemWallet(c, sc(1000), types.ZeroCurrency)
+	bob := newMemWallet(c, sc(50), types.NewCurrency64(10))
+
+	// bob offers 2 SF + 10 SC for 100 SC, splitting a 1 SC fee
+	send := Basket{SC: sc(10), SF: types.NewCurrency64(2)}
+	receive := Basket{SC: sc(100)}
+	swap, err := createSwap(bob, send, receive, sc(1), feePayerSplit, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := checkAccept(swap); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, bob, swap, waitingForCounterpartyToAccept)
+	checkStatus(t, alice, swap, waitingForYouToAccept)
+
+	s, err := summarize(alice, swap)
+	if err != nil {
+		t.Fatal(err)
+	} else if !s.You.Send.Equals(receive) || !s.You.Receive.Equals(send) || !s.You.Fee.Equals(sc(1).Div64(2)) {
+		t.Fatalf("wrong summary for alice: sends %v, receives %v, pays %v", s.You.Send, s.You.Receive, s.You.Fee.HumanString())
+	} else if !s.Counterparty.Send.Equals(send) || !s.Counterparty.Receive.Equals(receive) {
+		t.Fatalf("wrong summary for bob: sends %v, receives %v", s.Counterparty.Send, s.Counterparty.Receive)
+	}
+
+	// outputs left for the acceptor must match the terms
+	short := swap
+	short.SiafundOutputs = append([]types.SiafundOutput(nil), swap.SiafundOutputs...)
+	short.SiafundOutputs[0].Value = types.NewCurrency64(1)
+	if err := checkAccept(short); err == nil {
+		t.Fatal("expected checkAccept to reject outputs that do not match the terms")
+	}
+
+	if err := acceptSwap(alice, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, bob, swap, waitingForYouToFinish)
+	checkStatus(t, alice, swap, waitingForCounterpartyToFinish)
+
+	// alice shaves a siacoin off of bob's proceeds
+	tampered := swap
+	tampered.SiacoinOutputs = append([]types.SiacoinOutput(nil), swap.SiacoinOutputs...)
+	tampered.SiacoinOutputs[0].Value = tampered.SiacoinOutputs[0].Value.Sub(sc(1))
+	tampered.SiacoinOutputs = append(tampered.SiacoinOutputs, types.SiacoinOutput{UnlockHash: alice.addrs[0], Value: sc(1)})
+	if err := checkFinish(bob, tampered, false); err == nil {
+		t.Fatal("expected checkFinish to reject a swap that shortchanges the creator")
+	}
+
+	if err := checkFinish(bob, swap, false); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(bob, &swap); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, alice, swap, swapTransactionConfirmed)
+
+	half := sc(1).Div64(2)
+	if aliceSC, aliceSF := alice.balance(); !aliceSC.Equals(sc(910).Sub(half)) || !aliceSF.Equals(types.NewCurrency64(2)) {
+		t.Fatalf("alice has wrong balance after swap: %v SC, %v SF", aliceSC.HumanString(), aliceSF)
+	} else if bobSC, bobSF := bob.balance(); !bobSC.Equals(sc(140).Sub(half)) || !bobSF.Equals(types.NewCurrency64(8)) {
+		t.Fatalf("bob has wrong balance after swap: %v SC, %v SF", bobSC.HumanString(), bobSF)
+	}
+}
+
+func TestLegacyTerms(t *testing.T) {
+	for _, offeringSF := range []bool{false, true} {
+		_, alice, bob := newTestSwappers()
+		creator, acceptor := alice, bob
+		send, receive := Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}
+		if offeringSF {
+			creator, acceptor = bob, alice
+			send, receive = receive, send
+		}
+		swap, err := createSwap(creator, send, receive, testMinerFee, feePayerSplit, fundingOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// swaps from older versions do not record their terms
+		legacy := swap
+		legacy.Terms = SwapTerms{}
+		if terms := legacy.terms(); !terms.Creator.Equals(swap.Terms.Creator) || !terms.Acceptor.Equals(swap.Terms.Acceptor) {
+			t.Fatalf("expected legacy terms %v, got %v", swap.Terms, terms)
+		}
+		if err := acceptSwap(acceptor, &swap, fundingOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		legacy = swap
+		legacy.Terms = SwapTerms{}
+		if terms := legacy.terms(); !terms.Creator.Equals(swap.Terms.Creator) || !terms.Acceptor.Equals(swap.Terms.Acceptor) {
+			t.Fatalf("expected legacy terms %v, got %v", swap.Terms, terms)
+		} else if err := checkFinish(creator, legacy, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMinerFee(t *testing.T) {
+	c, alice, bob := newTestSwappers()
+	if _, err := createSwap(alice, Basket{SC: types.SiacoinPrecision}, Basket{SF: types.NewCurrency64(1)}, types.ZeroCurrency, feePayerSC, fundingOptions{}); err == nil {
+		t.Fatal("expected create to reject a zero miner fee")
+	}
+
+	fee, err := estimateMinerFee(c)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, max, _ := c.FeeEstimate(); !fee.Equals(max.Mul64(estimatedSwapSize)) {
+		t.Fatalf("expected fee of %v, got %v", max.Mul64(estimatedSwapSize).HumanString(), fee.HumanString())
+	}
+
+	// a swap without a fee, e.g. from an older version, must be rejected
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision}, Basket{SF: types.NewCurrency64(1)}, fee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	noFee := swap
+	noFee.MinerFee = types.ZeroCurrency
+	if err := checkAccept(noFee); err == nil {
+		t.Fatal("expected checkAccept to reject a swap without a miner fee")
+	}
+	if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	swap.MinerFee = types.ZeroCurrency
+	if err := checkFinish(alice, swap, false); err == nil {
+		t.Fatal("expected checkFinish to reject a swap without a miner fee")
+	}
+}
+
+func TestParseCurrency(t *testing.T) {
+	if c, err := parseCurrency("1.5KS"); err != nil {
+		t.Fatal(err)
+	} else if !c.Equals(types.SiacoinPrecision.Mul64(1500)) {
+		t.Fatal("wrong value for 1.5KS:", c)
+	} else if b, err := parseBasket("2SF+1KS"); err != nil {
+		t.Fatal(err)
+	} else if !b.SF.Equals64(2) || !b.SC.Equals(types.SiacoinPrecision.Mul64(1000)) {
+		t.Fatal("wrong value for 2SF+1KS:", b)
+	}
+	for _, s := range []string{"", "5", "abcSC", "1.5SF", "1.5H", "-1SC", "1SC+", "xSF"} {
+		if _, err := parseBasket(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+	c := newMemChain()
+	if _, err := parseMinerFee(c, "5"); err == nil {
+		t.Fatal("expected miner fee without units to be rejected")
+	} else if _, err := parseFundingOptions("", "1.5foo", false); err == nil {
+		t.Fatal("expected invalid dust threshold to be rejected")
+	}
+
+	// a malformed amount is a bad request, not a fatal error
+	_, alice, _ := newTestSwappers()
+	j, err := openJournal(filepath.Join(t.TempDir(), "swaps.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{backend: alice, journal: j}
+	for _, cr := range []createRequest{
+		{Offer: "7XS", Receive: "2SF"},
+		{Offer: "7MS", Receive: "2SF", Fee: "lots"},
+		{Offer: "7MS", Receive: "2SF", DustThreshold: "1.5"},
+	} {
+		js, _ := json.Marshal(cr)
+		rec := httptest.NewRecorder()
+		s.createHandler(rec, httptest.NewRequest("POST", "/api/create", bytes.NewReader(js)), nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %+v, got %v", cr, rec.Code)
+		}
+	}
+}
+
+func TestFeePayer(t *testing.T) {
+	scAmount := types.SiacoinPrecision.Mul64(100)
+	sfAmount := types.NewCurrency64(2)
+	fee := types.SiacoinPrecision.Mul64(3)
+
+	tests := []struct {
+		payer        string
+		scFee, sfFee types.Currency
+	}{
+		{feePayerSC, fee, types.ZeroCurrency},
+		{feePayerSF, types.ZeroCurrency, fee},
+		{feePayerSplit, fee.Div64(2), fee.Div64(2)},
+	}
+	for _, test := range tests {
+		for _, offeringSF := range []bool{false, true} {
+			c, alice, bob := newTestSwappers()
+			creator, acceptor := alice, bob
+			send, receive := Basket{SC: scAmount}, Basket{SF: sfAmount}
+			if offeringSF {
+				creator, acceptor = bob, alice
+				send, receive = receive, send
+			}
+			swap, err := createSwap(creator, send, receive, fee, test.payer, fundingOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := summarize(acceptor, swap)
+			if err != nil {
+				t.Fatal(err)
+			} else if !s.AmountSC.Equals(scAmount) || !s.SCPartyFee.Equals(test.scFee) || !s.SFPartyFee.Equals(test.sfFee) {
+				t.Fatalf("%v: wrong summary: %v SC, SC party pays %v, SF party pays %v", test.payer, s.AmountSC.HumanString(), s.SCPartyFee.HumanString(), s.SFPartyFee.HumanString())
+			}
+			if err := acceptSwap(acceptor, &swap, fundingOptions{}); err != nil {
+				t.Fatal(err)
+			} else if err := finishSwap(creator, &swap); err != nil {
+				t.Fatal(err)
+			}
+			c.mine()
+
+			aliceSC, _ := alice.balance()
+			bobSC, _ := bob.balance()
+			if !aliceSC.Equals(types.SiacoinPrecision.Mul64(1000).Sub(scAmount).Sub(test.scFee)) {
+				t.Fatalf("%v: SC party has wrong balance: %v", test.payer, aliceSC.HumanString())
+			} else if !bobSC.Equals(scAmount.Sub(test.sfFee)) {
+				t.Fatalf("%v: SF party has wrong balance: %v", test.payer, bobSC.HumanString())
+			}
+		}
+	}
+
+	_, alice, _ := newTestSwappers()
+	if _, err := createSwap(alice, Basket{SC: scAmount}, Basket{SF: sfAmount}, fee, "bob", fundingOptions{}); err == nil {
+		t.Fatal("expected create to reject an unknown fee payer")
+	} else if _, err := createSwap(alice, Basket{SC: fee}, Basket{SF: sfAmount}, fee, feePayerSF, fundingOptions{}); err == nil {
+		t.Fatal("expected create to reject a fee larger than the SF party's proceeds")
+	}
+}
+
+func TestSwapFailures(t *testing.T) {
+	scAmount := types.SiacoinPrecision.Mul64(100)
+	sfAmount := types.NewCurrency64(2)
+
+	t.Run("insufficient funds", func(t *testing.T) {
+		_, alice, bob := newTestSwappers()
+		if _, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(1000)}, Basket{SF: sfAmount}, testMinerFee, feePayerSC, fundingOptions{}); err == nil {
+			t.Fatal("expected create to fail without enough to cover the miner fee")
+		}
+		swap, err := createSwap(alice, Basket{SC: scAmount}, Basket{SF: types.NewCurrency64(11)}, testMinerFee, feePayerSC, fundingOptions{})
+		if err != nil {
+			t.Fatal(err)
+		} else if err := acceptSwap(bob, &swap, fundingOptions{}); err == nil {
+			t.Fatal("expected accept to fail with insufficient siafunds")
+		}
+	})
+
+	t.Run("spent inputs", func(t *testing.T) {
+		_, alice, bob := newTestSwappers()
+		swap, err := createSwap(alice, Basket{SC: scAmount}, Basket{SF: sfAmount}, testMinerFee, feePayerSC, fundingOptions{})
+		if err != nil {
+			t.Fatal(err)
+		} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		alice.spend(types.OutputID(swap.SiacoinInputs[0].ParentID))
+		if err := finishSwap(alice, &swap); err == nil {
+			t.Fatal("expected broadcast to fail after inputs were spent")
+		}
+	})
+
+	t.Run("rejected broadcast", func(t *testing.T) {
+		c, alice, bob := newTestSwappers()
+		swap, err := createSwap(alice, Basket{SC: scAmount}, Basket{SF: sfAmount}, testMinerFee, feePayerSC, fundingOptions{})
+		if err != nil {
+			t.Fatal(err)
+		} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		rejected := errors.New("transaction rejected")
+		c.rejectBroadcast = rejected
+		if err := finishSwap(alice, &swap); !errors.Is(err, rejected) {
+			t.Fatalf("expected %v, got %v", rejected, err)
+		}
+	})
+
+	t.Run("locked wallet", func(t *testing.T) {
+		_, alice, bob := newTestSwappers()
+		swap, err := createSwap(alice, Basket{SC: scAmount}, Basket{SF: sfAmount}, testMinerFee, feePayerSC, fundingOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bob.locked = true
+		if err := acceptSwap(bob, &swap, fundingOptions{}); !errors.Is(err, errLocked) {
+			t.Fatalf("expected %v, got %v", errLocked, err)
+		}
+		if _, err := summarize(bob, swap); err == nil {
+			t.Fatal("expected summarize to fail with a locked wallet")
+		}
+	})
+}
+
+func TestCancelSwap(t *testing.T) {
+	scAmount := types.SiacoinPrecision.Mul64(100)
+	sfAmount := types.NewCurrency64(2)
+
+	// the creator cancels after the swap has been accepted
+	c, alice, bob := newTestSwappers()
+	swap, err := createSwap(alice, Basket{SC: scAmount}, Basket{SF: sfAmount}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cancelSwap(alice, swap); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, alice, swap, swapCancelled)
+	if err := finishSwap(alice, &swap); err == nil {
+		t.Fatal("expected cancelled swap to be rejected")
+	} else if _, err := cancelSwap(alice, swap); err == nil {
+		t.Fatal("expected second cancellation to fail")
+	}
+	c.mine()
+	checkStatus(t, alice, swap, swapCancelled)
+	fee, _ := estimateMinerFee(c)
+	if sc, _ := alice.balance(); !sc.Equals(types.SiacoinPrecision.Mul64(1000).Sub(fee)) {
+		t.Fatalf("alice has wrong balance after cancelling: %v", sc.HumanString())
+	}
+
+	// the acceptor cancels; their siafund inputs do not cover the fee, so
+	// siacoins are added from their wallet
+	c, alice, bob = newTestSwappers()
+	c.fund(bob.addrs[0], types.SiacoinPrecision.Mul64(50), types.ZeroCurrency)
+	swap, err = createSwap(alice, Basket{SC: scAmount}, Basket{SF: sfAmount}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cancelSwap(bob, swap); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, bob, swap, swapCancelled)
+	if _, sf := bob.balance(); !sf.Equals(types.NewCurrency64(10)) {
+		t.Fatalf("bob has wrong balance after cancelling: %v SF", sf)
+	}
+
+	// a swap without any of our inputs cannot be cancelled
+	if _, err := cancelSwap(newMemWallet(c, types.SiacoinPrecision, types.ZeroCurrency), swap); err == nil {
+		t.Fatal("expected cancel to fail without any of our inputs")
+	}
+}
+
+func TestUnconfirmedOutputs(t *testing.T) {
+	scAmount := types.SiacoinPrecision.Mul64(100)
+	sfAmount := types.NewCurrency64(2)
+
+	// after the first swap is broadcast, alice's only output is its
+	// unconfirmed change
+	c, alice, bob := newTestSwappers()
+	swap, err := createSwap(alice, Basket{SC: scAmount}, Basket{SF: sfAmount}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(alice, &swap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createSwap(alice, Basket{SC: scAmount}, Basket{SF: types.NewCurrency64(1)}, testMinerFee, feePayerSC, fundingOptions{}); !errors.Is(err, errInsufficientFunds) {
+		t.Fatalf("expected %v, got %v", errInsufficientFunds, err)
+	}
+
+	// market makers can opt in to chaining off of unconfirmed change
+	chained, err := createSwap(alice, Basket{SC: scAmount}, Basket{SF: types.NewCurrency64(1)}, testMinerFee, feePayerSC, fundingOptions{allowUnconfirmed: true})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &chained, fundingOptions{allowUnconfirmed: true}); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(alice, &chained); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, alice, chained, swapTransactionConfirmed)
+
+	// outputs spent in the pool by a transaction the wallet has not seen are
+	// excluded by cross-checking the pool
+	c, alice, bob = newTestSwappers()
+	swap, err = createSwap(alice, Basket{SC: scAmount}, Basket{SF: sfAmount}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(alice, &swap); err != nil {
+		t.Fatal(err)
+	}
+	alice.unaware = true
+	j, err := openJournal(filepath.Join(t.TempDir(), "swaps.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = j.FundSwap(alice, stageCreated, func(w Wallet) (SwapTransaction, error) {
+		return createSwap(w, Basket{SC: scAmount}, Basket{SF: types.NewCurrency64(1)}, testMinerFee, feePayerSC, fundingOptions{})
+	})
+	if !errors.Is(err, errInsufficientFunds) {
+		t.Fatalf("expected %v, got %v", errInsufficientFunds, err)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gitlab.com/NebulousLabs/encoding"
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+// swapFileVersion is the current version of the swap file format. Files
+// written before the format was versioned hold a bare SwapTransaction.
+const swapFileVersion = 1
+
+// Networks that a swap file may be intended for. embc is built against
+// siad's mainnet consensus rules, so it only operates on mainnet.
+const (
+	networkMainnet = "mainnet"
+	networkZen     = "zen"
+
+	currentNetwork = networkMainnet
+)
+
+// defaultSwapExpiry is the number of blocks for which a new swap file is
+// valid, about as long as the outputs funding it stay reserved.
+const defaultSwapExpiry = 432
+
+// A SwapFile is the envelope in which a swap is passed between its parties.
+// It records the network the swap is for, the height at which it was
+// created, its miner fee, the height after which it should no longer be
+// accepted or finished (zero if it never expires), and a memo from its
+// creator. Hash is the hash of the other fields, so that a file that has
+// been truncated or edited is detected.
+type SwapFile struct {
+	Version int               `json:"version"`
+	Network string            `json:"network"`
+	Height  types.BlockHeight `json:"height"`
+	Fee     types.Currency    `json:"fee"`
+	Expiry  types.BlockHeight `json:"expiry"`
+	Memo    string            `json:"memo"`
+	Swap    SwapTransaction   `json:"swap"`
+	Hash    crypto.Hash       `json:"hash"`
+}
+
+// contentHash returns the hash of the file's contents.
+func (f SwapFile) contentHash() crypto.Hash {
+	return crypto.HashAll(f.Version, f.Network, f.Height, f.Fee, f.Expiry, f.Memo, f.Swap)
+}
+
+// withSwap returns a copy of f carrying swap.
+func (f SwapFile) withSwap(swap SwapTransaction) SwapFile {
+	f.Swap = swap
+	f.Fee = swap.MinerFee
+	f.Hash = f.contentHash()
+	return f
+}
+
+// newSwapFile returns a SwapFile for swap, created at the current height of
+// c and expiring after expiry blocks, or never if expiry is zero.
+func newSwapFile(c Chain, swap SwapTransaction, expiry types.BlockHeight, memo string) (SwapFile, error) {
+	cg, err := c.Consensus()
+	if err != nil {
+		return SwapFile{}, fmt.Errorf("failed to get consensus height: %w", err)
+	}
+	f := SwapFile{
+		Version: swapFileVersion,
+		Network: currentNetwork,
+		Height:  cg.Height,
+		Memo:    memo,
+	}
+	if expiry != 0 {
+		f.Expiry = cg.Height + expiry
+	}
+	return f.withSwap(swap), nil
+}
+
+// legacyMinerFee is the miner fee paid by every swap created before the fee
+// was recorded in the swap.
+var legacyMinerFee = types.SiacoinPrecision.Mul64(5)
+
+// readSwapFile reads a SwapFile, rejecting unknown fields. A file written
+// before the format was versioned is migrated to the current version; it is
+// assumed to be for the current network, and never expires. If it predates
+// recorded miner fees, it is given the fixed fee that it was signed with.
+func readSwapFile(r io.Reader) (SwapFile, error) {
+	js, err := io.ReadAll(r)
+	if err != nil {
+		return SwapFile{}, err
+	}
+	var probe struct {
+		Swap json.RawMessage `json:"swap"`
+	}
+	if err := json.Unmarshal(js, &probe); err != nil {
+		return SwapFile{}, fmt.Errorf("failed to decode swap file: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	if probe.Swap == nil {
+		var swap SwapTransaction
+		if err := dec.Decode(&swap); err != nil {
+			return SwapFile{}, fmt.Errorf("failed to decode swap file: %w", err)
+		}
+		if swap.MinerFee.IsZero() {
+			// swaps from before the fee was recorded paid a fixed fee,
+			// funded entirely by the siacoin party
+			swap.MinerFee = legacyMinerFee
+			swap.FeePayer = feePayerSC
+		}
+		// their terms can only be inferred while the inputs and signatures
+		// are as the file left them, so record them now
+		swap.Terms = swap.terms()
+		f := SwapFile{
+			Version: swapFileVersion,
+			Network: currentNetwork,
+		}
+		return f.withSwap(swap), nil
+	}
+
+	var f SwapFile
+	if err := dec.Decode(&f); err != nil {
+		return SwapFile{}, fmt.Errorf("failed to decode swap file: %w", err)
+	}
+	if err := validateSwapFile(f); err != nil {
+		return SwapFile{}, err
+	}
+	return f, nil
+}
+
+// validateSwapFile checks that a decoded SwapFile is well-formed.
+func validateSwapFile(f SwapFile) error {
+	if f.Version < 1 {
+		return fmt.Errorf("swap file has invalid version %v", f.Version)
+	} else if f.Version > swapFileVersion {
+		return fmt.Errorf("swap file version %v is not supported; upgrade embc", f.Version)
+	} else if f.Hash != f.contentHash() {
+		return errors.New("swap file is corrupted: content hash does not match")
+	} else if f.Network != networkMainnet && f.Network != networkZen {
+		return fmt.Errorf("swap file is for unknown network %q", f.Network)
+	} else if !f.Fee.Equals(f.Swap.MinerFee) {
+		return errors.New("swap file fee does not match the swap's miner fee")
+	}
+	return nil
+}
+
+// checkSwapFile checks that a SwapFile is for the network we are on and has
+// not expired.
+func checkSwapFile(c Chain, f SwapFile) error {
+	if f.Network != currentNetwork {
+		return fmt.Errorf("swap file is for %v, not %v", f.Network, currentNetwork)
+	} else if f.Expiry == 0 {
+		return nil
+	}
+	cg, err := c.Consensus()
+	if err != nil {
+		return fmt.Errorf("failed to get consensus height: %w", err)
+	} else if cg.Height > f.Expiry {
+		return fmt.Errorf("swap file expired at height %v", f.Expiry)
+	}
+	return nil
+}
+
+// marshalSwapFile returns the Sia binary encoding of f's contents.
+func marshalSwapFile(f SwapFile) []byte {
+	return encoding.MarshalAll(f.Version, f.Network, f.Height, f.Fee, f.Expiry, f.Memo, f.Swap)
+}
+
+// unmarshalSwapFile decodes a SwapFile encoded with marshalSwapFile.
+func unmarshalSwapFile(b []byte) (SwapFile, error) {
+	var f SwapFile
+	if err := encoding.UnmarshalAll(b, &f.Version, &f.Network, &f.Height, &f.Fee, &f.Expiry, &f.Memo, &f.Swap); err != nil {
+		return SwapFile{}, fmt.Errorf("failed to decode swap file: %w", err)
+	}
+	f.Hash = f.contentHash()
+	if err := validateSwapFile(f); err != nil {
+		return SwapFile{}, err
+	}
+	return f, nil
+}
+
+// swapTextPrefix begins the text encoding of a swap file.
+const swapTextPrefix = "embc:"
+
+// encodeSwapText returns a compact text encoding of f, suitable for pasting
+// into a chat message: the Sia binary encoding of its contents, followed by
+// the first four bytes of their hash as a checksum, in URL-safe base64.
+func encodeSwapText(f SwapFile) string {
+	b := marshalSwapFile(f)
+	checksum := crypto.HashBytes(b)
+	return swapTextPrefix + base64.RawURLEncoding.EncodeToString(append(b, checksum[:4]...))
+}
+
+// decodeSwapText decodes a swap file encoded with encodeSwapText.
+func decodeSwapText(s string) (SwapFile, error) {
+	if !strings.HasPrefix(s, swapTextPrefix) {
+		return SwapFile{}, fmt.Errorf("swap text must begin with %q", swapTextPrefix)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(s, swapTextPrefix)))
+	if err != nil {
+		return SwapFile{}, fmt.Errorf("failed to decode swap text: %w", err)
+	} else if len(b) < 4 {
+		return SwapFile{}, errors.New("swap text is too short")
+	}
+	b, checksum := b[:len(b)-4], b[len(b)-4:]
+	if h := crypto.HashBytes(b); !bytes.Equal(h[:4], checksum) {
+		return SwapFile{}, errors.New("swap text is corrupted: checksum does not match")
+	}
+	return unmarshalSwapFile(b)
+}
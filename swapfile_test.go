@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.sia.tech/siad/types"
+)
+
+func TestSwapFile(t *testing.T) {
+	c, alice, _ := newTestSwappers()
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newSwapFile(alice, swap, 2, "thanks!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, f); err != nil {
+		t.Fatal(err)
+	}
+	js := buf.String()
+
+	if f2, err := readSwapFile(strings.NewReader(js)); err != nil {
+		t.Fatal(err)
+	} else if f2.Memo != "thanks!" || f2.Network != networkMainnet || !f2.Fee.Equals(testMinerFee) || f2.Hash != f.Hash {
+		t.Fatalf("swap file changed after round trip: %+v", f2)
+	}
+
+	// edited, unknown, and future files are rejected
+	bad := map[string]string{
+		"edited":  strings.Replace(js, "thanks!", "thanks?", 1),
+		"unknown": strings.Replace(js, `"memo"`, `"foo": 1, "memo"`, 1),
+		"future":  strings.Replace(js, `"version": 1`, `"version": 2`, 1),
+	}
+	for name, s := range bad {
+		if _, err := readSwapFile(strings.NewReader(s)); err == nil {
+			t.Errorf("expected %v swap file to be rejected", name)
+		}
+	}
+
+	// files holding a bare swap are migrated
+	buf.Reset()
+	if err := encodeJSON(&buf, swap); err != nil {
+		t.Fatal(err)
+	}
+	if f2, err := readSwapFile(&buf); err != nil {
+		t.Fatal(err)
+	} else if f2.Version != swapFileVersion || f2.Network != currentNetwork || f2.Expiry != 0 || f2.Hash != f2.contentHash() {
+		t.Fatalf("legacy swap file was not migrated: %+v", f2)
+	}
+
+	// files expire
+	if err := checkSwapFile(c, f); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	c.mine()
+	c.mine()
+	if err := checkSwapFile(c, f); err == nil {
+		t.Fatal("expected swap file to have expired")
+	}
+	f.Network = networkZen
+	f.Expiry = 0
+	if err := checkSwapFile(c, f); err == nil {
+		t.Fatal("expected swap file for another network to be rejected")
+	}
+}
+
+func TestLegacySwapFile(t *testing.T) {
+	c, alice, bob := newTestSwappers()
+
+	// the format written before swap files were versioned, which omitted the
+	// miner fee
+	type legacySwap struct {
+		SiacoinInputs  []types.SiacoinInput         `json:"siacoinInputs"`
+		SiafundInputs  []types.SiafundInput         `json:"siafundInputs"`
+		SiacoinOutputs []types.SiacoinOutput        `json:"siacoinOutputs"`
+		SiafundOutputs []types.SiafundOutput        `json:"siafundOutputs"`
+		Signatures     []types.TransactionSignature `json:"signatures"`
+	}
+	roundTrip := func(swap SwapTransaction) SwapTransaction {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := encodeJSON(&buf, legacySwap{
+			SiacoinInputs:  swap.SiacoinInputs,
+			SiafundInputs:  swap.SiafundInputs,
+			SiacoinOutputs: swap.SiacoinOutputs,
+			SiafundOutputs: swap.SiafundOutputs,
+			Signatures:     swap.Signatures,
+		}); err != nil {
+			t.Fatal(err)
+		} else if strings.Contains(buf.String(), "minerFee") {
+			t.Fatal("legacy swap file should not record a miner fee")
+		}
+		f, err := readSwapFile(&buf)
+		if err != nil {
+			t.Fatal(err)
+		} else if !f.Swap.MinerFee.Equals(legacyMinerFee) || f.Swap.FeePayer != feePayerSC {
+			t.Fatalf("legacy swap was not given the fixed fee: %v paid by %q", f.Swap.MinerFee, f.Swap.FeePayer)
+		}
+		return f.Swap
+	}
+
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, legacyMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	swap = roundTrip(swap)
+	if err := checkAccept(swap); err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob's signatures cover the fixed fee, so they survive the migration
+	swap = roundTrip(swap)
+	if len(swap.Signatures) == 0 {
+		t.Fatal("expected legacy swap file to carry bob's signatures")
+	} else if err := checkFinish(alice, swap, false); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(alice, &swap); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	checkStatus(t, bob, swap, swapTransactionConfirmed)
+}
+
+func TestSwapText(t *testing.T) {
+	_, alice, bob := newTestSwappers()
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := newSwapFile(alice, swap, defaultSwapExpiry, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := encodeSwapText(f)
+	if len(text) > 2000 {
+		t.Fatalf("swap text is too long for a chat message: %v characters", len(text))
+	}
+
+	f2, err := decodeSwapFile(text, x25519Key{})
+	if err != nil {
+		t.Fatal(err)
+	} else if f2.Hash != f.Hash || f2.Swap.transaction().ID() != swap.transaction().ID() {
+		t.Fatal("swap changed after round trip")
+	}
+
+	// a typo is caught by the checksum
+	typo := []byte(text)
+	if typo[100] == 'A' {
+		typo[100] = 'B'
+	} else {
+		typo[100] = 'A'
+	}
+	if _, err := decodeSwapText(string(typo)); err == nil {
+		t.Fatal("expected corrupted swap text to be rejected")
+	} else if _, err := decodeSwapText(text[:len(text)-10]); err == nil {
+		t.Fatal("expected truncated swap text to be rejected")
+	}
+
+	// the text fits in a QR code
+	var buf bytes.Buffer
+	if err := writeQR(&buf, text); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for _, line := range lines {
+		if len([]rune(line)) != len([]rune(lines[0])) {
+			t.Fatal("QR code rows have different widths")
+		}
+	}
+}
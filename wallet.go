@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/node/api"
+	"go.sia.tech/siad/types"
+)
+
+// A Wallet provides the wallet operations required to fund and sign a swap.
+type Wallet interface {
+	// Address returns a new address controlled by the wallet.
+	Address() (types.UnlockHash, error)
+	// Addresses returns every address controlled by the wallet.
+	Addresses() ([]types.UnlockHash, error)
+	// UnspentOutputs returns the wallet's unspent siacoin and siafund outputs.
+	// Outputs that are not yet confirmed have a ConfirmationHeight of
+	// math.MaxUint64.
+	UnspentOutputs() ([]modules.UnspentOutput, error)
+	// UnconfirmedTransactions returns the wallet's transactions that are not
+	// yet confirmed.
+	UnconfirmedTransactions() ([]modules.ProcessedTransaction, error)
+	// UnlockConditions returns the unlock conditions of a wallet address.
+	UnlockConditions(addr types.UnlockHash) (types.UnlockConditions, error)
+	// SignTransaction fills in the signatures of txn whose parent IDs are
+	// listed in toSign.
+	SignTransaction(txn *types.Transaction, toSign []crypto.Hash) error
+	// Transaction returns a transaction relevant to the wallet.
+	Transaction(id types.TransactionID) (modules.ProcessedTransaction, error)
+	// Status returns the current state of the wallet.
+	Status() (api.WalletGET, error)
+}
+
+// A Chain provides access to the transaction pool and consensus state.
+type Chain interface {
+	// BroadcastTransaction submits a transaction to the transaction pool.
+	BroadcastTransaction(txn types.Transaction) error
+	// PoolTransactions returns the transactions in the transaction pool.
+	PoolTransactions() ([]types.Transaction, error)
+	// Consensus returns the current consensus state.
+	Consensus() (api.ConsensusGET, error)
+	// FeeEstimate returns the transaction pool's estimate of the minimum and
+	// maximum fee per byte required for a transaction to be confirmed.
+	FeeEstimate() (min, max types.Currency, err error)
+}
+
+// A Backend is a Wallet paired with the Chain it tracks.
+type Backend interface {
+	Wallet
+	Chain
+}
+
+// checkWalletKey checks that w knows the unlock conditions of its address,
+// without which it cannot add inputs to a swap.
+func checkWalletKey(w Wallet) error {
+	addr, err := w.Address()
+	if err != nil {
+		return fmt.Errorf("failed to get wallet address: %w", err)
+	}
+	_, err = w.UnlockConditions(addr)
+	return err
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.sia.tech/siad/node/api"
+)
+
+const (
+	// watchInterval is how often the watcher polls the backend.
+	watchInterval = 5 * time.Second
+	// eventKeepalive is how often an idle event stream is sent a comment, so
+	// that proxies do not close it.
+	eventKeepalive = 30 * time.Second
+)
+
+// Events pushed to the web UI.
+const (
+	eventConsensus = "consensus"
+	eventWallet    = "wallet"
+	eventSwap      = "swap"
+)
+
+// A watchedSwap is a journal record and the sequence number of its last
+// change.
+type watchedSwap struct {
+	record SwapRecord
+	seq    uint64
+}
+
+// watchState is a snapshot of everything the watcher tracks. Each part
+// carries the sequence number of its last change, so that a subscriber can
+// tell which parts changed since it last looked; zero means never polled.
+type watchState struct {
+	consensus    api.ConsensusGET
+	consensusSeq uint64
+	wallet       api.WalletGET
+	walletSeq    uint64
+	swaps        map[string]watchedSwap
+}
+
+// A watcher polls the backend and journal, so that changes to the consensus
+// height, wallet balance, and status of known swaps can be pushed to the web
+// UI, instead of the UI polling for them.
+type watcher struct {
+	backend Backend
+	journal *journal
+
+	mu      sync.Mutex
+	seq     uint64
+	state   watchState
+	updated chan struct{} // closed when state changes
+}
+
+// snapshot returns the current state, and a channel that is closed when it
+// changes.
+func (w *watcher) snapshot() (watchState, <-chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state := w.state
+	state.swaps = make(map[string]watchedSwap, len(w.state.swaps))
+	for id, ws := range w.state.swaps {
+		state.swaps[id] = ws
+	}
+	return state, w.updated
+}
+
+// poll refreshes the watcher's state, notifying subscribers of any changes.
+// Swaps are shown at the stage the backend reports them to have reached, but
+// the journal itself is only read; it is updated by the commands that act on
+// a swap, and by 'embc list'. Swaps whose files expired before they were
+// broadcast are not queried.
+func (w *watcher) poll() error {
+	cg, err := w.backend.Consensus()
+	if err != nil {
+		return fmt.Errorf("failed to get consensus: %w", err)
+	}
+	wg, err := w.backend.Status()
+	if err != nil {
+		return fmt.Errorf("failed to get wallet status: %w", err)
+	}
+	records := w.journal.Swaps()
+	for i, r := range records {
+		if r.expired(cg.Height) {
+			// querying the status of a swap is costly, and one that
+			// expired before it was broadcast will not progress
+			continue
+		} else if stage, ok := refreshedStage(w.backend, r); ok {
+			records[i].Stage = stage
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	changed := false
+	next := func() uint64 {
+		changed = true
+		w.seq++
+		return w.seq
+	}
+	if w.state.consensusSeq == 0 || cg.Height != w.state.consensus.Height || cg.CurrentBlock != w.state.consensus.CurrentBlock || cg.Synced != w.state.consensus.Synced {
+		w.state.consensus, w.state.consensusSeq = cg, next()
+	}
+	if w.state.walletSeq == 0 || !walletEqual(wg, w.state.wallet) {
+		w.state.wallet, w.state.walletSeq = wg, next()
+	}
+	current := make(map[string]bool, len(records))
+	for _, r := range records {
+		current[r.ID] = true
+		if old, ok := w.state.swaps[r.ID]; !ok || !recordEqual(old.record, r) {
+			w.state.swaps[r.ID] = watchedSwap{record: r, seq: next()}
+		}
+	}
+	// forget swaps that have left the journal
+	for id := range w.state.swaps {
+		if !current[id] {
+			delete(w.state.swaps, id)
+		}
+	}
+	if changed {
+		close(w.updated)
+		w.updated = make(chan struct{})
+	}
+	return nil
+}
+
+// run polls the backend every interval, forever.
+func (w *watcher) run(interval time.Duration) {
+	var lastErr string
+	for {
+		// log each distinct error once, rather than every interval
+		var errStr string
+		if err := w.poll(); err != nil {
+			errStr = err.Error()
+			if errStr != lastErr {
+				log.Println("Warning: failed to watch for changes:", err)
+			}
+		}
+		lastErr = errStr
+		time.Sleep(interval)
+	}
+}
+
+// walletEqual reports whether two wallet statuses have the same balances.
+func walletEqual(a, b api.WalletGET) bool {
+	return a.Height == b.Height &&
+		a.ConfirmedSiacoinBalance.Equals(b.ConfirmedSiacoinBalance) &&
+		a.UnconfirmedIncomingSiacoins.Equals(b.UnconfirmedIncomingSiacoins) &&
+		a.UnconfirmedOutgoingSiacoins.Equals(b.UnconfirmedOutgoingSiacoins) &&
+		a.SiafundBalance.Equals(b.SiafundBalance) &&
+		a.SiacoinClaimBalance.Equals(b.SiacoinClaimBalance)
+}
+
+// recordEqual reports whether two versions of a journal record are the same.
+func recordEqual(a, b SwapRecord) bool {
+	return a.Stage == b.Stage && a.TxnID == b.TxnID && a.Label == b.Label &&
+		a.Notes == b.Notes && len(a.History) == len(b.History)
+}
+
+// newWatcher returns a watcher for the swaps in j and the state of b. It does
+// not poll until run.
+func newWatcher(b Backend, j *journal) *watcher {
+	return &watcher{
+		backend: b,
+		journal: j,
+		state:   watchState{swaps: make(map[string]watchedSwap)},
+		updated: make(chan struct{}),
+	}
+}
+
+// writeEvent writes a server-sent event.
+func writeEvent(w http.ResponseWriter, event string, v interface{}) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, js)
+	return err
+}
+
+// streamEvents streams changes to the watcher's state as server-sent events,
+// starting with the current state. If swapID is set, only changes to that
+// swap are sent; otherwise changes to the consensus, the wallet, and every
+// swap are.
+func (s *server) streamEvents(w http.ResponseWriter, r *http.Request, swapID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var last uint64
+	for {
+		state, updated := s.watcher.snapshot()
+		seen := last
+		send := func(event string, v interface{}, seq uint64) bool {
+			if seq <= last {
+				return true
+			} else if seq > seen {
+				seen = seq
+			}
+			return writeEvent(w, event, v) == nil
+		}
+		ok := true
+		if swapID == "" {
+			ok = send(eventConsensus, state.consensus, state.consensusSeq) &&
+				send(eventWallet, state.wallet, state.walletSeq)
+			for _, ws := range state.swaps {
+				ok = ok && send(eventSwap, ws.record, ws.seq)
+			}
+		} else if ws, found := state.swaps[swapID]; found {
+			ok = send(eventSwap, ws.record, ws.seq)
+		}
+		if !ok {
+			return
+		}
+		flusher.Flush()
+		last = seen
+
+		select {
+		case <-updated:
+		case <-time.After(eventKeepalive):
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// eventsHandler streams consensus, wallet, and swap changes to the UI.
+func (s *server) eventsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	s.streamEvents(w, r, "")
+}
+
+// swapEventsHandler streams changes to a single swap to the UI.
+func (s *server) swapEventsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rec, err := s.journal.Swap(ps.ByName("id"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.streamEvents(w, r, rec.ID)
+}
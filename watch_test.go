@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"go.sia.tech/siad/node/api"
+	"go.sia.tech/siad/types"
+)
+
+// readEvent reads the next server-sent event from r.
+func readEvent(t *testing.T, r *bufio.Reader) (string, []byte) {
+	t.Helper()
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, []byte(data)
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	c, alice, bob := newTestSwappers()
+	j, err := openJournal(filepath.Join(t.TempDir(), "swaps.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{backend: alice, journal: j, watcher: newWatcher(alice, j)}
+	router := httprouter.New()
+	router.GET("/api/events", s.eventsHandler)
+	router.GET("/api/swaps/:id/events", s.swapEventsHandler)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := recordSwap(j, swap, stageCreated)
+	if err := s.watcher.poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	stream := func(path string) (*bufio.Reader, func()) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		} else if resp.StatusCode != http.StatusOK {
+			t.Fatal("failed to open event stream:", resp.Status)
+		} else if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatal("unexpected content type:", ct)
+		}
+		return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+	}
+	expectSwap := func(r *bufio.Reader, stage string) {
+		t.Helper()
+		event, data := readEvent(t, r)
+		var sr SwapRecord
+		if event != eventSwap {
+			t.Fatalf("expected %v event, got %v", eventSwap, event)
+		} else if err := json.Unmarshal(data, &sr); err != nil {
+			t.Fatal(err)
+		} else if sr.ID != rec.ID || sr.Stage != stage {
+			t.Fatalf("expected swap %v at stage %v, got %v at %v", rec.ID, stage, sr.ID, sr.Stage)
+		}
+	}
+
+	// the current state is sent first
+	events, closeEvents := stream("/api/events")
+	defer closeEvents()
+	var height types.BlockHeight
+	for _, want := range []string{eventConsensus, eventWallet} {
+		event, data := readEvent(t, events)
+		if event != want {
+			t.Fatalf("expected %v event, got %v", want, event)
+		} else if event == eventConsensus {
+			var cg api.ConsensusGET
+			if err := json.Unmarshal(data, &cg); err != nil {
+				t.Fatal(err)
+			}
+			height = cg.Height
+		}
+	}
+	expectSwap(events, stageCreated)
+	swapEvents, closeSwapEvents := stream("/api/swaps/" + rec.ID + "/events")
+	defer closeSwapEvents()
+	expectSwap(swapEvents, stageCreated)
+
+	// unknown swaps are rejected
+	if resp, err := http.Get(srv.URL + "/api/swaps/foo/events"); err != nil {
+		t.Fatal(err)
+	} else if resp.Body.Close(); resp.StatusCode != http.StatusNotFound {
+		t.Fatal("expected unknown swap to be rejected, got", resp.Status)
+	}
+
+	// the swap is broadcast, then confirmed, without the UI asking
+	if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(alice, &swap); err != nil {
+		t.Fatal(err)
+	}
+	recordSwap(j, swap, stageBroadcast)
+	if err := s.watcher.poll(); err != nil {
+		t.Fatal(err)
+	}
+	expectSwap(swapEvents, stageBroadcast)
+	stat, err := os.Stat(j.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	if err := s.watcher.poll(); err != nil {
+		t.Fatal(err)
+	}
+	expectSwap(swapEvents, stageConfirmed)
+
+	// watching never writes the journal
+	if stat2, err := os.Stat(j.path); err != nil {
+		t.Fatal(err)
+	} else if !os.SameFile(stat, stat2) || !stat2.ModTime().Equal(stat.ModTime()) {
+		t.Fatal("polling rewrote the journal")
+	}
+
+	// the full stream also carries the new height and balance
+	seen := make(map[string]bool)
+	for !seen[eventConsensus] || !seen[eventWallet] {
+		event, data := readEvent(t, events)
+		seen[event] = true
+		if event == eventConsensus {
+			var cg api.ConsensusGET
+			if err := json.Unmarshal(data, &cg); err != nil {
+				t.Fatal(err)
+			} else if cg.Height != height+1 {
+				t.Fatalf("expected height %v, got %v", height+1, cg.Height)
+			}
+		}
+	}
+}
+
+func TestWatcherExpiry(t *testing.T) {
+	c, alice, bob := newTestSwappers()
+	j, err := openJournal(filepath.Join(t.TempDir(), "swaps.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newWatcher(alice, j)
+	stage := func(id string) string {
+		t.Helper()
+		if err := w.poll(); err != nil {
+			t.Fatal(err)
+		}
+		state, _ := w.snapshot()
+		return state.swaps[id].record.Stage
+	}
+
+	cg, err := alice.Consensus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	swap, err := createSwap(alice, Basket{SC: types.SiacoinPrecision.Mul64(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := recordSwap(j, swap, stageCreated)
+	if _, err := j.SetExpiry(rec.ID, cg.Height); err != nil {
+		t.Fatal(err)
+	}
+
+	// the swap completes without being recorded, after its file expired
+	if err := acceptSwap(bob, &swap, fundingOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(alice, &swap); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+
+	// the watcher no longer queries it, so it stays as recorded
+	if s := stage(rec.ID); s != stageCreated {
+		t.Fatalf("expected expired swap to stay %q, got %q", stageCreated, s)
+	}
+	if _, err := j.SetExpiry(rec.ID, 0); err != nil {
+		t.Fatal(err)
+	} else if s := stage(rec.ID); s == stageCreated {
+		t.Fatal("expected unexpired swap to be refreshed")
+	}
+}
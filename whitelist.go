@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.sia.tech/siad/types"
+)
+
+// A Destination is an address that receives part of a swap's proceeds.
+type Destination struct {
+	Address types.UnlockHash `json:"address"`
+	Amount  Basket           `json:"amount"`
+}
+
+// parseDestinations parses a comma-separated list of destinations, each
+// written as address=amount, e.g. "<addr>=1SF,<addr>=1SF+10KS".
+func parseDestinations(s string) ([]Destination, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var dests []Destination
+	for _, d := range strings.Split(s, ",") {
+		eq := strings.Index(d, "=")
+		if eq == -1 {
+			return nil, fmt.Errorf("invalid destination %q: must be of the form address=amount", d)
+		}
+		var dest Destination
+		if err := dest.Address.LoadString(strings.TrimSpace(d[:eq])); err != nil {
+			return nil, fmt.Errorf("invalid destination address %q: %w", d[:eq], err)
+		}
+		amount, err := parseBasket(d[eq+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination amount %q: %w", d[eq+1:], err)
+		}
+		dest.Amount = amount
+		if dest.Amount.IsZero() {
+			return nil, fmt.Errorf("destination %v receives nothing", dest.Address)
+		}
+		dests = append(dests, dest)
+	}
+	return dests, nil
+}
+
+// payProceeds returns outputs paying amount to the destinations, in order,
+// and any remainder to a new address from w. Each destination must be owned
+// by w or, if w is a whitelistBackend, whitelisted.
+func payProceeds(w Wallet, amount Basket, dests []Destination) ([]types.SiacoinOutput, []types.SiafundOutput, error) {
+	var scos []types.SiacoinOutput
+	var sfos []types.SiafundOutput
+	remaining := amount
+	for _, d := range dests {
+		if ok, err := isDestination(w, d.Address); err != nil {
+			return nil, nil, err
+		} else if !ok {
+			return nil, nil, fmt.Errorf("destination %v is neither owned by the wallet nor whitelisted", d.Address)
+		} else if d.Amount.SC.Cmp(remaining.SC) > 0 || d.Amount.SF.Cmp(remaining.SF) > 0 {
+			return nil, nil, fmt.Errorf("destinations receive more than the swap's proceeds of %v", amount)
+		}
+		if !d.Amount.SC.IsZero() {
+			scos = append(scos, types.SiacoinOutput{UnlockHash: d.Address, Value: d.Amount.SC})
+		}
+		if !d.Amount.SF.IsZero() {
+			sfos = append(sfos, types.SiafundOutput{UnlockHash: d.Address, Value: d.Amount.SF})
+		}
+		remaining.SC = remaining.SC.Sub(d.Amount.SC)
+		remaining.SF = remaining.SF.Sub(d.Amount.SF)
+	}
+	if remaining.IsZero() {
+		return scos, sfos, nil
+	}
+	addr, err := w.Address()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get wallet address: %w", err)
+	}
+	if !remaining.SC.IsZero() {
+		scos = append(scos, types.SiacoinOutput{UnlockHash: addr, Value: remaining.SC})
+	}
+	if !remaining.SF.IsZero() {
+		sfos = append(sfos, types.SiafundOutput{UnlockHash: addr, Value: remaining.SF})
+	}
+	return scos, sfos, nil
+}
+
+// A whitelist is a persistent set of addresses, such as cold storage, that
+// the user has approved as destinations for swap proceeds even though the
+// wallet does not own them.
+type whitelist struct {
+	mu    sync.Mutex
+	path  string
+	addrs []types.UnlockHash
+}
+
+// save atomically writes the whitelist to disk.
+func (wl *whitelist) save() error {
+	tmp := wl.path + "_temp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := encodeJSON(f, wl.addrs); err != nil {
+		return err
+	} else if err := f.Sync(); err != nil {
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, wl.path)
+}
+
+// Addresses returns the whitelisted addresses.
+func (wl *whitelist) Addresses() []types.UnlockHash {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	return append([]types.UnlockHash(nil), wl.addrs...)
+}
+
+// Add whitelists addrs.
+func (wl *whitelist) Add(addrs ...types.UnlockHash) error {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	for _, addr := range addrs {
+		var found bool
+		for _, a := range wl.addrs {
+			found = found || a == addr
+		}
+		if !found {
+			wl.addrs = append(wl.addrs, addr)
+		}
+	}
+	return wl.save()
+}
+
+// Remove removes addrs from the whitelist.
+func (wl *whitelist) Remove(addrs ...types.UnlockHash) error {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	remove := make(map[types.UnlockHash]bool)
+	for _, addr := range addrs {
+		remove[addr] = true
+	}
+	kept := wl.addrs[:0]
+	for _, a := range wl.addrs {
+		if !remove[a] {
+			kept = append(kept, a)
+		}
+	}
+	wl.addrs = kept
+	return wl.save()
+}
+
+// openWhitelist loads the whitelist at path, creating it if it does not exist.
+func openWhitelist(path string) (*whitelist, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	wl := &whitelist{path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return wl, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&wl.addrs); err != nil {
+		return nil, fmt.Errorf("failed to decode address whitelist: %w", err)
+	}
+	return wl, nil
+}
+
+// A whitelistBackend is a Backend with a whitelist of addresses that may
+// receive swap proceeds. The whitelisted addresses are not the wallet's own:
+// they are only accepted as destinations, never as inputs or change.
+type whitelistBackend struct {
+	Backend
+	whitelist *whitelist
+}
+
+// whitelistOf returns the whitelist of w, or nil if it has none.
+func whitelistOf(w Wallet) *whitelist {
+	switch w := w.(type) {
+	case whitelistBackend:
+		return w.whitelist
+	case watchOnlyBackend:
+		return whitelistOf(w.Backend)
+	case reservingWallet:
+		return whitelistOf(w.Wallet)
+	}
+	return nil
+}
+
+// isDestination reports whether w may pay swap proceeds to addr, i.e.
+// whether addr is owned by w or whitelisted.
+func isDestination(w Wallet, addr types.UnlockHash) (bool, error) {
+	addrs, err := w.Addresses()
+	if err != nil {
+		return false, fmt.Errorf("failed to get wallet addresses: %w", err)
+	}
+	for _, a := range addrs {
+		if a == addr {
+			return true, nil
+		}
+	}
+	if wl := whitelistOf(w); wl != nil {
+		for _, a := range wl.Addresses() {
+			if a == addr {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// whitelistedProceeds returns the value of the outputs of txn that pay
+// addresses whitelisted by w but not owned by it. Such outputs can only be
+// proceeds, so the total must not exceed receive.
+func whitelistedProceeds(w Wallet, txn types.Transaction, receive Basket) (Basket, error) {
+	wl := whitelistOf(w)
+	if wl == nil {
+		return Basket{}, nil
+	}
+	addrs, err := w.Addresses()
+	if err != nil {
+		return Basket{}, fmt.Errorf("failed to get wallet addresses: %w", err)
+	}
+	belongsToUs := make(map[types.UnlockHash]bool)
+	for _, addr := range addrs {
+		belongsToUs[addr] = true
+	}
+	whitelisted := make(map[types.UnlockHash]bool)
+	for _, addr := range wl.Addresses() {
+		whitelisted[addr] = !belongsToUs[addr]
+	}
+	var proceeds Basket
+	for _, sco := range txn.SiacoinOutputs {
+		if whitelisted[sco.UnlockHash] {
+			proceeds.SC = proceeds.SC.Add(sco.Value)
+		}
+	}
+	for _, sfo := range txn.SiafundOutputs {
+		if whitelisted[sfo.UnlockHash] {
+			proceeds.SF = proceeds.SF.Add(sfo.Value)
+		}
+	}
+	if proceeds.SC.Cmp(receive.SC) > 0 || proceeds.SF.Cmp(receive.SF) > 0 {
+		return Basket{}, fmt.Errorf("whitelisted addresses receive %v, more than our proceeds of %v", proceeds, receive)
+	}
+	return proceeds, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"go.sia.tech/siad/types"
+)
+
+func TestWhitelist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "whitelist.json")
+	wl, err := openWhitelist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := types.UnlockHash{1}, types.UnlockHash{2}
+	if err := wl.Add(a, b, a); err != nil {
+		t.Fatal(err)
+	} else if err := wl.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+
+	// the whitelist should survive a restart
+	wl, err = openWhitelist(path)
+	if err != nil {
+		t.Fatal(err)
+	} else if addrs := wl.Addresses(); len(addrs) != 1 || addrs[0] != b {
+		t.Fatalf("expected whitelist to contain only %v, got %v", b, addrs)
+	}
+}
+
+func TestParseDestinations(t *testing.T) {
+	addr := types.UnlockHash{1}.String()
+	dests, err := parseDestinations(addr + "=1SF," + addr + "=2SF+10KS")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(dests) != 2 || !dests[1].Amount.Equals(Basket{SC: types.SiacoinPrecision.Mul64(10e3), SF: types.NewCurrency64(2)}) {
+		t.Fatalf("wrong destinations: %v", dests)
+	}
+	for _, s := range []string{addr, "foo=1SF", addr + "=0SF"} {
+		if _, err := parseDestinations(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestSwapDestinations(t *testing.T) {
+	sc := types.SiacoinPrecision.Mul64
+	sf := types.NewCurrency64
+	c, alice, bob := newTestSwappers()
+	wl, err := openWhitelist(filepath.Join(t.TempDir(), "whitelist.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cold := types.UnlockHash{1}
+	dests := []Destination{{Address: cold, Amount: Basket{SF: sf(3)}}}
+
+	// alice cannot send her proceeds to cold storage until she whitelists it
+	if _, err := createSwap(alice, Basket{SC: sc(100)}, Basket{SF: sf(4)}, testMinerFee, feePayerSC, fundingOptions{destinations: dests}); err == nil {
+		t.Fatal("expected createSwap to reject a destination that is not whitelisted")
+	} else if err := wl.Add(cold); err != nil {
+		t.Fatal(err)
+	}
+	wlAlice := whitelistBackend{alice, wl}
+	if addrs, _ := wlAlice.Addresses(); len(addrs) != len(alice.addrs) {
+		t.Fatal("whitelisted addresses should not be counted as the wallet's own")
+	}
+	swap, err := createSwap(wlAlice, Basket{SC: sc(100)}, Basket{SF: sf(4)}, testMinerFee, feePayerSC, fundingOptions{destinations: dests})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bob splits his proceeds across two of his own addresses
+	bobAddr, _ := bob.Address()
+	bobDests := []Destination{{Address: bobAddr, Amount: Basket{SC: sc(40)}}}
+	if err := acceptSwap(bob, &swap, fundingOptions{destinations: bobDests}); err != nil {
+		t.Fatal(err)
+	}
+
+	// without the whitelist, alice's wallet does not receive the agreed
+	// siafunds
+	if err := checkFinish(alice, swap, false); err == nil {
+		t.Fatal("expected checkFinish to reject proceeds paid to an address that is not whitelisted")
+	} else if err := checkFinish(wlAlice, swap, false); err != nil {
+		t.Fatal(err)
+	} else if err := finishSwap(wlAlice, &swap); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+
+	// whitelisted outputs count only as proceeds, never more
+	txn := types.Transaction{SiafundOutputs: []types.SiafundOutput{{UnlockHash: cold, Value: sf(5)}}}
+	if _, err := whitelistedProceeds(wlAlice, txn, Basket{SF: sf(4)}); err == nil {
+		t.Fatal("expected whitelisted outputs exceeding the proceeds to be rejected")
+	}
+
+	var coldSF, bobSC types.Currency
+	for _, sfo := range swap.SiafundOutputs {
+		if sfo.UnlockHash == cold {
+			coldSF = coldSF.Add(sfo.Value)
+		}
+	}
+	for _, sco := range swap.SiacoinOutputs {
+		if sco.UnlockHash == bobAddr {
+			bobSC = bobSC.Add(sco.Value)
+		}
+	}
+	if !coldSF.Equals(sf(3)) {
+		t.Fatalf("cold storage should receive 3 SF, got %v", coldSF)
+	} else if !bobSC.Equals(sc(40)) {
+		t.Fatalf("bob's address should receive 40 SC, got %v", bobSC.HumanString())
+	} else if _, aliceSF := alice.balance(); !aliceSF.Equals(sf(1)) {
+		t.Fatalf("alice should keep 1 SF, got %v", aliceSF)
+	} else if bobSC, bobSF := bob.balance(); !bobSC.Equals(sc(100)) || !bobSF.Equals(sf(6)) {
+		t.Fatalf("bob has wrong balance after swap: %v SC, %v SF", bobSC.HumanString(), bobSF)
+	}
+}
+
+func TestOfferDestinations(t *testing.T) {
+	sc := types.SiacoinPrecision.Mul64
+	c, alice, bob := newTestSwappers()
+	offer, err := createOffer(alice, Basket{SC: sc(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bob fills the offer, paying part of his proceeds to a second address
+	// appended after the offer's covered outputs
+	addr, _ := bob.Address()
+	dests := []Destination{{Address: addr, Amount: Basket{SC: sc(30)}}}
+	if err := takeOffer(bob, bob, &offer, fundingOptions{destinations: dests}); err != nil {
+		t.Fatal(err)
+	}
+	c.mine()
+	if bobSC, _ := bob.balance(); !bobSC.Equals(sc(100)) {
+		t.Fatalf("bob should receive 100 SC, got %v", bobSC.HumanString())
+	}
+
+	// a destination outside the wallet is rejected
+	offer, err = createOffer(alice, Basket{SC: sc(100)}, Basket{SF: types.NewCurrency64(2)}, testMinerFee, feePayerSC, fundingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dests = []Destination{{Address: types.UnlockHash{1}, Amount: Basket{SC: sc(30)}}}
+	if err := takeOffer(bob, bob, &offer, fundingOptions{destinations: dests}); err == nil {
+		t.Fatal("expected takeOffer to reject a destination that is not whitelisted")
+	}
+}
+
+func TestUpdateWhitelistAuth(t *testing.T) {
+	wl, err := openWhitelist(filepath.Join(t.TempDir(), "whitelist.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := func(s *server, password string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/whitelist", bytes.NewReader([]byte(`{"add":["`+types.UnlockHash{1}.String()+`"]}`)))
+		if password != "" {
+			req.SetBasicAuth("", password)
+		}
+		rec := httptest.NewRecorder()
+		s.updateWhitelistHandler(rec, req, nil)
+		return rec.Code
+	}
+
+	// without a password, the whitelist cannot be changed through the API
+	if code := update(&server{whitelist: wl}, "foo"); code != http.StatusForbidden {
+		t.Fatalf("expected %v, got %v", http.StatusForbidden, code)
+	}
+	s := &server{whitelist: wl, password: "foo"}
+	if code := update(s, ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected %v, got %v", http.StatusUnauthorized, code)
+	} else if code := update(s, "bar"); code != http.StatusUnauthorized {
+		t.Fatalf("expected %v, got %v", http.StatusUnauthorized, code)
+	} else if len(wl.Addresses()) != 0 {
+		t.Fatal("unauthenticated request changed the whitelist")
+	} else if code := update(s, "foo"); code != http.StatusOK {
+		t.Fatalf("expected %v, got %v", http.StatusOK, code)
+	} else if len(wl.Addresses()) != 1 {
+		t.Fatal("authenticated request did not change the whitelist")
+	}
+}